@@ -0,0 +1,121 @@
+// Package goEagi of agc.go provides automatic gain control with
+// a peak limiter for 16-bit mono audio, so that quiet callers reach
+// a consistent level before voice detection and speech recognition.
+
+package goEagi
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	defaultAGCTargetLevel = -20.0
+	defaultAGCMaxGain     = 24.0
+	defaultAGCMinGain     = -12.0
+	defaultAGCGateLevel   = -50.0
+	defaultAGCLimitLevel  = -1.0
+
+	agcLevelTime   = 100 * time.Millisecond
+	agcAttackTime  = 50 * time.Millisecond
+	agcReleaseTime = 800 * time.Millisecond
+	limiterRelease = 50 * time.Millisecond
+)
+
+// AGC adjusts the gain so that speech reaches TargetLevel, the gain only adapts while
+// the signal is above GateLevel so that silence is not amplified. All levels are in dBFS.
+// The limiter keeps the output below LimitLevel. An AGC is not safe for concurrent use.
+type AGC struct {
+	TargetLevel float64
+	MaxGain     float64
+	MinGain     float64
+	GateLevel   float64
+	LimitLevel  float64
+
+	levelCoefficient   float64
+	attackCoefficient  float64
+	releaseCoefficient float64
+	limiterCoefficient float64
+
+	power    float64
+	gain     float64
+	envelope float64
+}
+
+// NewAGC creates an AGC for audio at rate, a zero targetLevel uses the default of -20 dBFS.
+func NewAGC(rate int, targetLevel float64) *AGC {
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+	if targetLevel == 0 {
+		targetLevel = defaultAGCTargetLevel
+	}
+
+	return &AGC{
+		TargetLevel:        targetLevel,
+		MaxGain:            defaultAGCMaxGain,
+		MinGain:            defaultAGCMinGain,
+		GateLevel:          defaultAGCGateLevel,
+		LimitLevel:         defaultAGCLimitLevel,
+		levelCoefficient:   smoothingCoefficient(agcLevelTime, rate),
+		attackCoefficient:  smoothingCoefficient(agcAttackTime, rate),
+		releaseCoefficient: smoothingCoefficient(agcReleaseTime, rate),
+		limiterCoefficient: smoothingCoefficient(limiterRelease, rate),
+	}
+}
+
+// Latency returns the delay the AGC adds to the audio, the AGC works sample by sample.
+func (a *AGC) Latency() time.Duration {
+	return 0
+}
+
+// Gain returns the current gain in dB, not including the limiter.
+func (a *AGC) Gain() float64 {
+	return a.gain
+}
+
+// Process applies the gain and the limiter to src, writing the result into dst[:0].
+func (a *AGC) Process(dst []int16, src []int16) []int16 {
+	dst = dst[:0]
+
+	gate := dbToAmplitudePower(a.GateLevel)
+	limit := math.Pow(10, a.LimitLevel/20) * math.MaxInt16
+
+	for _, s := range src {
+		x := float64(s)
+
+		a.power = a.levelCoefficient*a.power + (1-a.levelCoefficient)*x*x
+		if a.power > gate {
+			level := 10 * math.Log10(a.power/(fullScale*fullScale))
+			desired := math.Max(a.MinGain, math.Min(a.MaxGain, a.TargetLevel-level))
+
+			coefficient := a.releaseCoefficient
+			if desired < a.gain {
+				coefficient = a.attackCoefficient
+			}
+			a.gain = coefficient*a.gain + (1-coefficient)*desired
+		}
+
+		y := x * math.Pow(10, a.gain/20)
+
+		// the limiter follows the peak envelope with an instant attack and a short release.
+		a.envelope = math.Max(math.Abs(y), a.limiterCoefficient*a.envelope)
+		if a.envelope > limit {
+			y *= limit / a.envelope
+		}
+
+		dst = append(dst, clampInt16(float32(y)))
+	}
+
+	return dst
+}
+
+// smoothingCoefficient returns the one-pole coefficient of a time constant at rate.
+func smoothingCoefficient(d time.Duration, rate int) float64 {
+	return math.Exp(-1 / (d.Seconds() * float64(rate)))
+}
+
+// dbToAmplitudePower converts a level in dBFS to the mean square of 16-bit samples.
+func dbToAmplitudePower(db float64) float64 {
+	return math.Pow(10, db/10) * fullScale * fullScale
+}
@@ -0,0 +1,68 @@
+package goEagi
+
+import (
+	"math"
+	"testing"
+)
+
+// levelTone returns n samples of a 440 Hz tone with the given RMS level in dBFS.
+func levelTone(n int, level float64) []int16 {
+	amplitude := math.Sqrt2 * math.Pow(10, level/20) * fullScale
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(2*math.Pi*440*float64(i)/audioSampleRate))
+	}
+	return samples
+}
+
+func TestAGCReachesTargetLevel(t *testing.T) {
+	for _, level := range []float64{-35, -25, -12} {
+		a := NewAGC(audioSampleRate, 0)
+		output := a.Process(nil, levelTone(3*audioSampleRate, level))
+
+		if got := rmsLevel(output[2*audioSampleRate:]); math.Abs(got-defaultAGCTargetLevel) > 1 {
+			t.Errorf("input at %.0f dBFS: output at %.1f dBFS, want %.0f ± 1", level, got, defaultAGCTargetLevel)
+		}
+	}
+}
+
+func TestAGCGainIsBounded(t *testing.T) {
+	a := NewAGC(audioSampleRate, 0)
+	output := a.Process(nil, levelTone(5*audioSampleRate, -48))
+
+	if a.Gain() > a.MaxGain+1e-9 {
+		t.Fatalf("gain %.2f dB above MaxGain %.0f dB", a.Gain(), a.MaxGain)
+	}
+	if got := rmsLevel(output[4*audioSampleRate:]); math.Abs(got-(-48+a.MaxGain)) > 0.5 {
+		t.Fatalf("quiet input amplified to %.1f dBFS, want %.0f", got, -48+a.MaxGain)
+	}
+
+	a = NewAGC(audioSampleRate, -30)
+	a.Process(nil, levelTone(3*audioSampleRate, -6))
+	if a.Gain() < a.MinGain-1e-9 {
+		t.Fatalf("gain %.2f dB below MinGain %.0f dB", a.Gain(), a.MinGain)
+	}
+}
+
+func TestAGCDoesNotAmplifySilence(t *testing.T) {
+	a := NewAGC(audioSampleRate, 0)
+	a.Process(nil, levelTone(2*audioSampleRate, -60))
+
+	if a.Gain() != 0 {
+		t.Fatalf("gain adapted to %.2f dB below GateLevel", a.Gain())
+	}
+}
+
+func TestAGCLimiter(t *testing.T) {
+	a := NewAGC(audioSampleRate, 0)
+	// the gain rises to MaxGain on a quiet caller, who then suddenly shouts.
+	a.Process(nil, levelTone(2*audioSampleRate, -45))
+	output := a.Process(nil, levelTone(audioSampleRate/2, -3))
+
+	limit := math.Pow(10, a.LimitLevel/20) * math.MaxInt16
+	for i, s := range output {
+		if math.Abs(float64(s)) > limit+1 {
+			t.Fatalf("sample %d is %d, above the limit of %.0f", i, s, limit)
+		}
+	}
+}
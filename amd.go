@@ -0,0 +1,371 @@
+// Package goEagi of amd.go provides answering machine detection
+// from the caller audio, it follows the word and silence counting
+// of Asterisk's AMD() and adds beep and transcript cues.
+
+package goEagi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AMDStatus is the verdict of an AMD analysis.
+type AMDStatus string
+
+const (
+	AMDHuman   AMDStatus = "HUMAN"
+	AMDMachine AMDStatus = "MACHINE"
+	AMDNotSure AMDStatus = "NOTSURE"
+)
+
+const (
+	amdBlockDuration = 20 * time.Millisecond
+	amdBlockSize     = audioSampleRate * int(amdBlockDuration/time.Millisecond) / 1000
+
+	amdBeepMinFrequency = 400
+	amdBeepMaxFrequency = 2100
+	amdBeepStep         = 25
+	amdBeepMinPurity    = 0.7
+	amdBeepMinDuration  = 150 * time.Millisecond
+)
+
+// AMDConfig holds the thresholds of the analysis, the defaults are those of Asterisk's amd.conf.
+type AMDConfig struct {
+	// InitialSilence is the longest silence before the greeting, if exceeded the call is a MACHINE.
+	InitialSilence time.Duration
+	// Greeting is the longest voiced time of the greeting, pauses not counted, if exceeded the call is a MACHINE.
+	Greeting time.Duration
+	// AfterGreetingSilence is the silence after the greeting which marks a HUMAN.
+	AfterGreetingSilence time.Duration
+	// TotalAnalysisTime is the longest analysis, if exceeded the call is NOTSURE.
+	TotalAnalysisTime time.Duration
+	// MinWordLength is the shortest voice duration counted as a word.
+	MinWordLength time.Duration
+	// BetweenWordsSilence is the shortest silence which separates two words.
+	BetweenWordsSilence time.Duration
+	// MaximumNumberOfWords is the word count at which the call is a MACHINE.
+	MaximumNumberOfWords int
+	// MaximumWordLength is the longest single word, if exceeded the call is a MACHINE.
+	MaximumWordLength time.Duration
+	// SilenceThreshold is the amplitude threshold of the Vad, 0 uses the Vad default.
+	SilenceThreshold float64
+	// DetectBeep enables the detection of the voicemail beep, which marks a MACHINE.
+	DetectBeep bool
+	// MachinePhrases are transcript phrases which mark a MACHINE, matched case-insensitively.
+	MachinePhrases []string
+}
+
+// DefaultAMDConfig returns the default AMDConfig.
+func DefaultAMDConfig() AMDConfig {
+	return AMDConfig{
+		InitialSilence:       2500 * time.Millisecond,
+		Greeting:             1500 * time.Millisecond,
+		AfterGreetingSilence: 800 * time.Millisecond,
+		TotalAnalysisTime:    5000 * time.Millisecond,
+		MinWordLength:        100 * time.Millisecond,
+		BetweenWordsSilence:  50 * time.Millisecond,
+		MaximumNumberOfWords: 3,
+		MaximumWordLength:    5000 * time.Millisecond,
+		DetectBeep:           true,
+		MachinePhrases: []string{
+			"leave a message", "leave your message", "after the tone", "after the beep",
+			"not available", "unavailable", "voicemail", "voice mail", "mailbox", "record your message",
+		},
+	}
+}
+
+// AMDResult is the outcome of an AMD analysis and the measurements it was based on.
+type AMDResult struct {
+	Status  AMDStatus
+	Reasons []string
+
+	InitialSilence time.Duration
+	GreetingLength time.Duration
+	VoiceDuration  time.Duration
+	WordCount      int
+	MaxWordLength  time.Duration
+	BeepDetected   bool
+	BeepOffset     time.Duration
+	TranscriptCues []string
+	AnalysisTime   time.Duration
+}
+
+// AMD analyzes the first seconds of an answered call to tell a human from an answering machine.
+// Audio must be fed from a single goroutine, AddTranscript may be called concurrently.
+type AMD struct {
+	Config AMDConfig
+	Vad    *Vad
+
+	block  []byte
+	result AMDResult
+	done   bool
+
+	elapsed        time.Duration
+	inInitial      bool
+	inGreeting     bool
+	inWord         bool
+	voiceDuration  time.Duration
+	silence        time.Duration
+	greetingStart  time.Duration
+	beepFrequency  float64
+	beepDuration   time.Duration
+	beepCandidates []float64
+	samples        [amdBlockSize]float64
+
+	mu   sync.Mutex
+	cues []string
+}
+
+// NewAMD creates an AMD analyzer, the Vad uses config.SilenceThreshold and may be
+// replaced, for example with an adaptive Vad, before the first frame is processed.
+func NewAMD(config AMDConfig) *AMD {
+	a := AMD{
+		Config:    config,
+		Vad:       NewVad(config.SilenceThreshold),
+		block:     make([]byte, 0, amdBlockSize*audioBytesPerSample),
+		inInitial: true,
+	}
+
+	for f := amdBeepMinFrequency; f <= amdBeepMaxFrequency; f += amdBeepStep {
+		a.beepCandidates = append(a.beepCandidates, float64(f))
+	}
+
+	return &a
+}
+
+// AddTranscript feeds a transcript from any recognizer, it is checked against Config.MachinePhrases.
+func (a *AMD) AddTranscript(text string) {
+	text = strings.ToLower(text)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, phrase := range a.Config.MachinePhrases {
+		if strings.Contains(text, strings.ToLower(phrase)) {
+			a.cues = append(a.cues, phrase)
+		}
+	}
+}
+
+// Process analyzes a frame of 8 kHz 16-bit audio.
+// It returns true once a verdict is reached, after which further frames are ignored.
+func (a *AMD) Process(frame []byte) (AMDResult, bool, error) {
+	if a.done {
+		return a.result, true, nil
+	}
+
+	for len(frame) > 0 {
+		n := cap(a.block) - len(a.block)
+		if n > len(frame) {
+			n = len(frame)
+		}
+		a.block = append(a.block, frame[:n]...)
+		frame = frame[n:]
+
+		if len(a.block) < cap(a.block) {
+			break
+		}
+
+		err := a.analyzeBlock(a.block)
+		a.block = a.block[:0]
+		if err != nil {
+			return AMDResult{}, false, err
+		}
+		if a.done {
+			return a.result, true, nil
+		}
+	}
+
+	return AMDResult{}, false, nil
+}
+
+// Result returns the verdict, if no verdict was reached yet the analysis is ended as NOTSURE.
+func (a *AMD) Result() AMDResult {
+	if !a.done {
+		a.finish(AMDNotSure, fmt.Sprintf("INCOMPLETE-%d", a.elapsed.Milliseconds()))
+	}
+	return a.result
+}
+
+// Analyze feeds the audio stream into the analyzer until a verdict is reached,
+// the stream is closed or ctx is done, in the last two cases the verdict is NOTSURE.
+func (a *AMD) Analyze(ctx context.Context, stream <-chan []byte) (AMDResult, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return a.Result(), ctx.Err()
+
+		case buf, ok := <-stream:
+			if !ok {
+				return a.Result(), nil
+			}
+
+			result, done, err := a.Process(buf)
+			if err != nil {
+				return AMDResult{}, err
+			}
+			if done {
+				return result, nil
+			}
+		}
+	}
+}
+
+// analyzeBlock runs the state machine on a 20 ms block.
+func (a *AMD) analyzeBlock(block []byte) error {
+	vad, err := a.Vad.Analyze(block)
+	if err != nil {
+		return err
+	}
+	a.elapsed += amdBlockDuration
+
+	if a.transcriptVerdict() {
+		return nil
+	}
+
+	if a.Config.DetectBeep && a.beepVerdict(block) {
+		return nil
+	}
+
+	if a.elapsed >= a.Config.TotalAnalysisTime {
+		a.finish(AMDNotSure, fmt.Sprintf("TOOLONG-%d", a.elapsed.Milliseconds()))
+		return nil
+	}
+
+	if !vad.Detected {
+		a.silence += amdBlockDuration
+		if a.silence >= a.Config.BetweenWordsSilence {
+			a.inWord = false
+			a.voiceDuration = 0
+		}
+
+		if a.inInitial && a.silence >= a.Config.InitialSilence {
+			a.result.InitialSilence = a.silence
+			a.finish(AMDMachine, fmt.Sprintf("INITIALSILENCE-%d-%d", a.silence.Milliseconds(), a.Config.InitialSilence.Milliseconds()))
+			return nil
+		}
+
+		if a.inGreeting && a.silence >= a.Config.AfterGreetingSilence {
+			a.result.GreetingLength = a.elapsed - a.silence - a.greetingStart
+			a.finish(AMDHuman, fmt.Sprintf("HUMAN-%d-%d", a.silence.Milliseconds(), a.Config.AfterGreetingSilence.Milliseconds()))
+			return nil
+		}
+		return nil
+	}
+
+	a.voiceDuration += amdBlockDuration
+	a.result.VoiceDuration += amdBlockDuration
+	if a.voiceDuration > a.result.MaxWordLength {
+		a.result.MaxWordLength = a.voiceDuration
+	}
+
+	if a.voiceDuration >= a.Config.MinWordLength && !a.inWord {
+		a.inWord = true
+		a.result.WordCount++
+
+		if a.inInitial {
+			a.inInitial = false
+			a.inGreeting = true
+			a.result.InitialSilence = a.elapsed - a.voiceDuration
+			a.greetingStart = a.result.InitialSilence
+		}
+
+		if a.result.WordCount >= a.Config.MaximumNumberOfWords {
+			a.result.GreetingLength = a.elapsed - a.greetingStart
+			a.finish(AMDMachine, fmt.Sprintf("MAXWORDS-%d-%d", a.result.WordCount, a.Config.MaximumNumberOfWords))
+			return nil
+		}
+	}
+
+	if a.voiceDuration >= a.Config.MaximumWordLength {
+		a.finish(AMDMachine, fmt.Sprintf("MAXWORDLENGTH-%d", a.voiceDuration.Milliseconds()))
+		return nil
+	}
+
+	// like Asterisk, the greeting is measured in voiced time, so that a greeting with pauses is not cut short.
+	if a.inGreeting && a.result.VoiceDuration >= a.Config.Greeting {
+		a.result.GreetingLength = a.elapsed - a.greetingStart
+		a.finish(AMDMachine, fmt.Sprintf("LONGGREETING-%d-%d", a.result.VoiceDuration.Milliseconds(), a.Config.Greeting.Milliseconds()))
+		return nil
+	}
+
+	a.silence = 0
+	return nil
+}
+
+// transcriptVerdict ends the analysis as MACHINE if a machine phrase was transcribed.
+func (a *AMD) transcriptVerdict() bool {
+	a.mu.Lock()
+	cues := append([]string(nil), a.cues...)
+	a.mu.Unlock()
+
+	if len(cues) == 0 {
+		return false
+	}
+
+	a.result.TranscriptCues = cues
+	a.finish(AMDMachine, fmt.Sprintf("TRANSCRIPT-%s", strings.ToUpper(strings.ReplaceAll(cues[0], " ", "_"))))
+	return true
+}
+
+// beepVerdict tracks a steady pure tone across blocks and ends the analysis as MACHINE
+// once it lasted amdBeepMinDuration.
+func (a *AMD) beepVerdict(block []byte) bool {
+	frequency, ok := a.dominantTone(block)
+	if !ok || (a.beepDuration > 0 && math.Abs(frequency-a.beepFrequency) > 2*amdBeepStep) {
+		a.beepDuration = 0
+		a.beepFrequency = 0
+		return false
+	}
+
+	if a.beepDuration == 0 {
+		a.beepFrequency = frequency
+	}
+	a.beepDuration += amdBlockDuration
+
+	if a.beepDuration < amdBeepMinDuration {
+		return false
+	}
+
+	a.result.BeepDetected = true
+	a.result.BeepOffset = a.elapsed - a.beepDuration
+	a.finish(AMDMachine, fmt.Sprintf("BEEP-%.0f", a.beepFrequency))
+	return true
+}
+
+// dominantTone returns the frequency of the block if nearly all of its energy is in a single tone.
+func (a *AMD) dominantTone(block []byte) (float64, bool) {
+	samples := a.samples[:len(block)/audioBytesPerSample]
+	var power float64
+	for i := range samples {
+		s := float64(sampleAt(block, i)) / fullScale
+		samples[i] = s
+		power += s * s
+	}
+	power /= float64(len(samples))
+
+	if power < dbToPower(a.Vad.Threshold()-amplitudeOffset) {
+		return 0, false
+	}
+
+	var best, bestPower float64
+	for _, f := range a.beepCandidates {
+		p := goertzelPower(samples, goertzelCoefficient(f, audioSampleRate))
+		if p > bestPower {
+			best, bestPower = f, p
+		}
+	}
+
+	return best, bestPower/power >= amdBeepMinPurity
+}
+
+func (a *AMD) finish(status AMDStatus, reason string) {
+	a.done = true
+	a.result.Status = status
+	a.result.Reasons = append(a.result.Reasons, reason)
+	a.result.AnalysisTime = a.elapsed
+}
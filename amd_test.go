@@ -0,0 +1,216 @@
+package goEagi
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// amdSegment is a stretch of caller audio, voice when voiced is true, silence otherwise.
+type amdSegment struct {
+	voiced   bool
+	duration time.Duration
+}
+
+func voice(d time.Duration) amdSegment   { return amdSegment{true, d} }
+func silence(d time.Duration) amdSegment { return amdSegment{false, d} }
+
+// amdAudio returns the segments as 16-bit audio, the voice is white noise well above the Vad threshold.
+func amdAudio(segments ...amdSegment) []byte {
+	r := rand.New(rand.NewSource(3))
+
+	var samples Frame
+	for _, s := range segments {
+		for i := 0; i < int(s.duration.Seconds()*audioSampleRate); i++ {
+			var v int16
+			if s.voiced {
+				v = int16(8000 * (2*r.Float64() - 1))
+			}
+			samples = append(samples, v)
+		}
+	}
+	return samples.Bytes(nil)
+}
+
+// runAMD feeds the audio to a in 20 ms frames until a verdict is reached, and returns it.
+func runAMD(t *testing.T, a *AMD, audio []byte) AMDResult {
+	t.Helper()
+
+	for start := 0; start < len(audio); start += 320 {
+		end := start + 320
+		if end > len(audio) {
+			end = len(audio)
+		}
+
+		result, done, err := a.Process(audio[start:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done {
+			return result
+		}
+	}
+	return a.Result()
+}
+
+func TestAMDVerdicts(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name       string
+		config     func(*AMDConfig)
+		audio      []amdSegment
+		wantStatus AMDStatus
+		wantReason string
+	}{
+		{
+			name:       "short hello then silence",
+			audio:      []amdSegment{silence(300 * ms), voice(500 * ms), silence(time.Second)},
+			wantStatus: AMDHuman,
+			wantReason: "HUMAN-800-800",
+		},
+		{
+			name:       "nobody speaks",
+			audio:      []amdSegment{silence(3 * time.Second)},
+			wantStatus: AMDMachine,
+			wantReason: "INITIALSILENCE-2500-2500",
+		},
+		{
+			name:       "too many words",
+			audio:      []amdSegment{voice(200 * ms), silence(200 * ms), voice(200 * ms), silence(200 * ms), voice(200 * ms), silence(time.Second)},
+			wantStatus: AMDMachine,
+			wantReason: "MAXWORDS-3-3",
+		},
+		{
+			name:       "long greeting",
+			audio:      []amdSegment{silence(200 * ms), voice(2 * time.Second)},
+			wantStatus: AMDMachine,
+			wantReason: "LONGGREETING-1500-1500",
+		},
+		{
+			name:   "greeting with pauses is measured in voiced time",
+			config: func(c *AMDConfig) { c.MaximumNumberOfWords = 10 },
+			audio: []amdSegment{
+				voice(300 * ms), silence(500 * ms), voice(300 * ms), silence(500 * ms),
+				voice(300 * ms), silence(500 * ms), voice(300 * ms), silence(time.Second),
+			},
+			wantStatus: AMDHuman,
+			wantReason: "HUMAN-800-800",
+		},
+		{
+			name:   "long greeting with pauses",
+			config: func(c *AMDConfig) { c.MaximumNumberOfWords = 10 },
+			audio: []amdSegment{
+				voice(400 * ms), silence(500 * ms), voice(400 * ms), silence(500 * ms),
+				voice(400 * ms), silence(500 * ms), voice(400 * ms), silence(time.Second),
+			},
+			wantStatus: AMDMachine,
+			wantReason: "LONGGREETING-1500-1500",
+		},
+		{
+			name:       "single long word",
+			config:     func(c *AMDConfig) { c.Greeting = 10 * time.Second; c.MaximumWordLength = 2 * time.Second },
+			audio:      []amdSegment{voice(3 * time.Second)},
+			wantStatus: AMDMachine,
+			wantReason: "MAXWORDLENGTH-2000",
+		},
+		{
+			name:   "analysis too long",
+			config: func(c *AMDConfig) { c.Greeting = 10 * time.Second; c.MaximumNumberOfWords = 100 },
+			audio: []amdSegment{
+				voice(300 * ms), silence(500 * ms), voice(300 * ms), silence(500 * ms), voice(300 * ms), silence(500 * ms),
+				voice(300 * ms), silence(500 * ms), voice(300 * ms), silence(500 * ms), voice(300 * ms), silence(500 * ms),
+				voice(300 * ms), silence(500 * ms),
+			},
+			wantStatus: AMDNotSure,
+			wantReason: "TOOLONG-5000",
+		},
+		{
+			name:       "audio ends before a verdict",
+			audio:      []amdSegment{silence(300 * ms), voice(200 * ms)},
+			wantStatus: AMDNotSure,
+			wantReason: "INCOMPLETE-500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultAMDConfig()
+			if tt.config != nil {
+				tt.config(&config)
+			}
+
+			result := runAMD(t, NewAMD(config), amdAudio(tt.audio...))
+
+			if result.Status != tt.wantStatus || len(result.Reasons) != 1 || result.Reasons[0] != tt.wantReason {
+				t.Fatalf("got %s %v, want %s [%s]", result.Status, result.Reasons, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestAMDMeasurements(t *testing.T) {
+	ms := time.Millisecond
+	result := runAMD(t, NewAMD(DefaultAMDConfig()), amdAudio(silence(400*ms), voice(300*ms), silence(100*ms), voice(200*ms), silence(time.Second)))
+
+	if result.Status != AMDHuman {
+		t.Fatalf("status = %s %v, want HUMAN", result.Status, result.Reasons)
+	}
+	if result.InitialSilence != 400*ms {
+		t.Errorf("initial silence = %v, want 400ms", result.InitialSilence)
+	}
+	if result.WordCount != 2 {
+		t.Errorf("word count = %d, want 2", result.WordCount)
+	}
+	if result.VoiceDuration != 500*ms {
+		t.Errorf("voice duration = %v, want 500ms", result.VoiceDuration)
+	}
+	if result.GreetingLength != 600*ms {
+		t.Errorf("greeting length = %v, want 600ms", result.GreetingLength)
+	}
+	if result.MaxWordLength != 300*ms {
+		t.Errorf("max word length = %v, want 300ms", result.MaxWordLength)
+	}
+}
+
+func TestAMDBeep(t *testing.T) {
+	audio := append(amdAudio(silence(300*time.Millisecond)), mixAudio(400*time.Millisecond, dtmfTone{1000, -13})...)
+
+	result := runAMD(t, NewAMD(DefaultAMDConfig()), audio)
+
+	if result.Status != AMDMachine || !result.BeepDetected || result.Reasons[0] != "BEEP-1000" {
+		t.Fatalf("got %s %v, beep %v, want MACHINE [BEEP-1000]", result.Status, result.Reasons, result.BeepDetected)
+	}
+	if result.BeepOffset != 300*time.Millisecond {
+		t.Fatalf("beep offset = %v, want 300ms", result.BeepOffset)
+	}
+
+	config := DefaultAMDConfig()
+	config.DetectBeep = false
+	result = runAMD(t, NewAMD(config), audio)
+	if result.BeepDetected || strings.HasPrefix(result.Reasons[0], "BEEP") {
+		t.Fatalf("beep detected with DetectBeep disabled: %v", result.Reasons)
+	}
+}
+
+func TestAMDTranscriptCue(t *testing.T) {
+	a := NewAMD(DefaultAMDConfig())
+
+	// a short greeting alone is not a verdict.
+	if _, done, err := a.Process(amdAudio(voice(300 * time.Millisecond))); err != nil || done {
+		t.Fatalf("verdict after 300ms: done %v, err %v", done, err)
+	}
+
+	a.AddTranscript("Hi, please Leave A Message after the tone")
+	result, done, err := a.Process(amdAudio(voice(20 * time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done || result.Status != AMDMachine || result.Reasons[0] != "TRANSCRIPT-LEAVE_A_MESSAGE" {
+		t.Fatalf("got done %v, %s %v, want MACHINE [TRANSCRIPT-LEAVE_A_MESSAGE]", done, result.Status, result.Reasons)
+	}
+	if len(result.TranscriptCues) != 2 || result.TranscriptCues[1] != "after the tone" {
+		t.Fatalf("transcript cues = %q", result.TranscriptCues)
+	}
+}
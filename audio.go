@@ -6,17 +6,13 @@
 package goEagi
 
 import (
-	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
-	"os"
 	"path/filepath"
 	"syscall"
-
-	"github.com/cryptix/wav"
+	"time"
 )
 
 const (
@@ -28,22 +24,34 @@ const (
 	defaultFileDescriptorPath = "/dev/fd/3"
 )
 
+// AudioResult is a frame of an audio stream, or the error which ended it.
+// Captured is when the frame was read from Asterisk, zero when the producer of the stream does not know.
 type AudioResult struct {
-	Error  error
-	Stream []byte
+	Error    error
+	Stream   []byte
+	Captured time.Time
 }
 
 // StreamAudio launches a new goroutine for audio streaming via file descriptor 3.
+// It stops when ctx is cancelled, even if nobody reads the stream anymore.
 func StreamAudio(ctx context.Context) <-chan AudioResult {
 	audioResultStream := make(chan AudioResult)
 
 	go func() {
 		defer close(audioResultStream)
 
+		send := func(r AudioResult) bool {
+			select {
+			case audioResultStream <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
 		fd, err := syscall.Open(defaultFileDescriptorPath, syscall.O_RDONLY, 0755)
 		if err != nil {
-			r := AudioResult{Error: fmt.Errorf("could not open fd3: %v\n", err)}
-			audioResultStream <- r
+			send(AudioResult{Error: fmt.Errorf("could not open fd3: %v\n", err)})
 			return
 		}
 
@@ -57,13 +65,19 @@ func StreamAudio(ctx context.Context) <-chan AudioResult {
 			default:
 				n, err := syscall.Read(fd, buf)
 				if err != nil {
-					r := AudioResult{Error: fmt.Errorf("failed to read fd3: %v\n", err)}
-					audioResultStream <- r
+					send(AudioResult{Error: fmt.Errorf("failed to read fd3: %v\n", err)})
 					return
 				}
 
 				if n > 0 {
-					audioResultStream <- AudioResult{Stream: buf[:n]}
+					captured := time.Now()
+
+					// every frame gets its own copy, consumers may still hold the previous one.
+					frame := make([]byte, n)
+					copy(frame, buf[:n])
+					if !send(AudioResult{Stream: frame, Captured: captured}) {
+						return
+					}
 				}
 			}
 		}
@@ -72,117 +86,29 @@ func StreamAudio(ctx context.Context) <-chan AudioResult {
 	return audioResultStream
 }
 
-// ComputeAmplitude analyzes the amplitude of a sample slice of bytes.
+// amplitudeOffset is how far ComputeAmplitude reads above dBFS: it has always divided the sum of squares
+// by half the sample count, and referenced the samples scaled to 1 to 32768 before adding 90 dB.
+var amplitudeOffset = 10*math.Log10(2) - 20*math.Log10(math.MaxInt16) - 20*math.Log10(32768) + 90 + 20*math.Log10(fullScale)
+
+// ComputeAmplitude analyzes the amplitude of a sample slice of bytes, in the unit the Vad thresholds use,
+// which reads about 2.7 dB above the dBFS of ComputeLevel. It returns -Inf for silence and does not allocate.
 func ComputeAmplitude(sample []byte) (float64, error) {
-	parseData, err := parseRawData(sample)
-	if err != nil {
-		return 0, err
-	}
+	return ComputeLevel(sample) + amplitudeOffset, nil
+}
 
-	computeRms := rms(parseData)
-	maxAmp := maxPossibleAmplitude()
-	db := ratioToDb(computeRms, maxAmp)
-	return db + 90, nil
+// ComputeLevel returns the RMS level of a sample slice of bytes in dBFS, -Inf for silence, and does not allocate.
+func ComputeLevel(sample []byte) float64 {
+	return AmplitudeToDb(bytesRMS(sample))
 }
 
 // GenerateAudio writes a sample slice of bytes into an audio file.
 // It returns a location path of an audio which passed in the function parameters.
 // Please note that only wav extension is supported.
+// For long recordings, use a Recorder instead, which does not need the whole audio in memory.
 func GenerateAudio(sample []byte, audioDirectory string, audioName string) (string, error) {
 	if fileExtension := filepath.Ext(audioName); fileExtension != ".wav" {
 		return "", errors.New("audio name does not contain .wav extension")
 	}
 
-	if _, err := os.Stat(audioDirectory); os.IsNotExist(err) {
-		if err := os.MkdirAll(audioDirectory, os.ModePerm); err != nil {
-			return "", err
-		}
-	}
-
-	audioPath := audioDirectory + audioName
-	file, err := os.Create(audioPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create audio path: %v\n", err)
-	}
-	defer file.Close()
-
-	meta := wav.File{
-		NumberOfSamples: uint32(len(sample)),
-		SampleRate:      audioSampleRate,
-		SignificantBits: audioBitsPerSample,
-		Channels:        audioChannel,
-	}
-
-	writer, err := meta.NewWriter(file)
-	if err != nil {
-		return "", err
-	}
-	defer writer.Close()
-	bytesSampleSize := int(meta.SignificantBits) / 8
-
-	for i := 0; i < len(sample); i += bytesSampleSize {
-		if err := writer.WriteSample(sample[i : i+bytesSampleSize]); err != nil {
-			return "", fmt.Errorf("failed to generate audio: %v\n", err)
-		}
-	}
-
-	return audioPath, nil
-}
-
-// scaleFrame is used in parseRawData.
-func scaleFrame(unscaled int) float64 {
-	maxV := math.MaxInt16
-	return float64(unscaled) / float64(maxV)
-}
-
-// bits16ToInt is used in parseRawData.
-func bits16ToInt(b []byte) (int, error) {
-	if len(b) != 2 {
-		return 0, errors.New("slice of bytes must be length of 2")
-	}
-
-	var payload int16
-	framesPerBuffer := bytes.NewReader(b)
-	if err := binary.Read(framesPerBuffer, binary.LittleEndian, &payload); err != nil {
-		return 0, err
-	}
-	return int(payload), nil
-}
-
-// parseRawData is used in ComputeAmplitude.
-func parseRawData(rawData []byte) ([]float64, error) {
-	var frames []float64
-	for i := 0; i < len(rawData); i += audioBytesPerSample {
-		rawFrame := rawData[i : i+audioBytesPerSample]
-		unscaledFrame, err := bits16ToInt(rawFrame)
-		if err != nil {
-			return nil, err
-		}
-		scaled := scaleFrame(unscaledFrame)
-		frames = append(frames, scaled)
-	}
-	return frames, nil
-}
-
-// rms is used in ComputeAmplitude.
-func rms(samples []float64) float64 {
-	sampleCount := len(samples) / audioBytesPerSample
-	var sumSquare float64
-
-	for _, sample := range samples {
-		sumSquare += sample * sample
-	}
-	return math.Sqrt(sumSquare / float64(sampleCount))
-}
-
-// maxPossibleAmplitude is used in ComputeAmplitude.
-func maxPossibleAmplitude() float64 {
-	maxPossibleVal := math.Pow(2, float64(audioBitsPerSample))
-	return maxPossibleVal / 2
-}
-
-// ratioToDb is used in ComputeAmplitude.
-func ratioToDb(rms, maxAmplitude float64) float64 {
-	ratio := rms / maxAmplitude
-	return 20 * math.Log10(ratio)
+	return GenerateEncodedAudio(sample, LinearCodec{}, audioDirectory, audioName)
 }
@@ -0,0 +1,293 @@
+// Package goEagi of broadcast.go provides a Broadcaster type,
+// which fans a single audio stream out to several consumers,
+// such as a recognizer, a recorder and a DTMF detector,
+// each with its own bounded buffer and drop policy.
+
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what happens to a frame when a subscriber's buffer is full.
+type DropPolicy int
+
+const (
+	// DropPolicyBlock waits for the subscriber. The other subscribers receive the frame meanwhile,
+	// but the next frame is only broadcast once every blocking subscriber took it.
+	DropPolicyBlock DropPolicy = iota
+	// DropPolicyOldest discards the oldest buffered frame to make room for the new one.
+	DropPolicyOldest
+	// DropPolicyNewest discards the new frame.
+	DropPolicyNewest
+)
+
+const defaultSubscriptionSize = 50
+
+// Broadcaster delivers every result of a source to all of its subscriptions.
+// Frames are shared between subscribers and must not be modified.
+type Broadcaster struct {
+	source <-chan AudioResult
+
+	mu            sync.Mutex
+	subscriptions []*Subscription
+	started       bool
+	closed        bool
+}
+
+// Subscription is a consumer of a Broadcaster.
+type Subscription struct {
+	// the counters come first to be 64-bit aligned for atomic access on 32-bit platforms.
+	delivered uint64
+	dropped   uint64
+
+	name   string
+	policy DropPolicy
+	stream chan AudioResult
+	done   chan struct{}
+	once   sync.Once
+
+	// mu is held while sending, so that the stream is never closed in the middle of a send.
+	mu     sync.Mutex
+	closed bool
+
+	broadcaster *Broadcaster
+}
+
+// NewBroadcaster creates a Broadcaster of source, such as the stream returned by StreamAudio.
+// The frames of source must not be reused by its producer once sent.
+func NewBroadcaster(source <-chan AudioResult) *Broadcaster {
+	return &Broadcaster{source: source}
+}
+
+// Subscribe adds a subscription with a buffer of size results, a size of zero or less uses a default of 50.
+// Subscriptions can be added and removed at any time, a subscriber only receives the results sent after it subscribed.
+// Subscribing to a Broadcaster which has stopped returns a subscription whose stream is already closed.
+func (b *Broadcaster) Subscribe(name string, size int, policy DropPolicy) *Subscription {
+	if size <= 0 {
+		size = defaultSubscriptionSize
+	}
+
+	s := &Subscription{
+		name:        name,
+		policy:      policy,
+		stream:      make(chan AudioResult, size),
+		done:        make(chan struct{}),
+		broadcaster: b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		s.once.Do(func() { close(s.done) })
+		s.closeStream()
+		return s
+	}
+
+	b.subscriptions = append(b.subscriptions, s)
+	return s
+}
+
+// Subscriptions returns the current subscriptions.
+func (b *Broadcaster) Subscriptions() []*Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]*Subscription(nil), b.subscriptions...)
+}
+
+// Start launches a new goroutine which broadcasts the source until it is closed or ctx is cancelled,
+// then closes the stream of every subscription. An error of the source ends the broadcast, it is never dropped:
+// subscribers which do not block lose their oldest frame instead.
+func (b *Broadcaster) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return errors.New("broadcaster already started")
+	}
+	b.started = true
+
+	go func() {
+		defer b.close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case audio, ok := <-b.source:
+				if !ok {
+					return
+				}
+
+				b.broadcast(ctx, audio)
+
+				if audio.Error != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// broadcast delivers a result to every subscription, those which do not block first,
+// then the blocking ones concurrently. The subscriptions are sent to without the lock held,
+// so that a slow subscriber never holds back Subscribe and Unsubscribe.
+func (b *Broadcaster) broadcast(ctx context.Context, audio AudioResult) {
+	b.mu.Lock()
+	subscriptions := append([]*Subscription(nil), b.subscriptions...)
+	b.mu.Unlock()
+
+	var blocking []*Subscription
+	for _, s := range subscriptions {
+		policy := s.policy
+		if audio.Error != nil && policy == DropPolicyNewest {
+			policy = DropPolicyOldest
+		}
+		if policy == DropPolicyBlock {
+			blocking = append(blocking, s)
+			continue
+		}
+		s.send(ctx, audio, policy)
+	}
+
+	if len(blocking) == 1 {
+		blocking[0].send(ctx, audio, DropPolicyBlock)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range blocking {
+		wg.Add(1)
+		go func(s *Subscription) {
+			defer wg.Done()
+			s.send(ctx, audio, DropPolicyBlock)
+		}(s)
+	}
+	wg.Wait()
+}
+
+// close ends the broadcast and closes every subscription.
+func (b *Broadcaster) close() {
+	b.mu.Lock()
+	subscriptions := b.subscriptions
+	b.subscriptions = nil
+	b.closed = true
+	b.mu.Unlock()
+
+	for _, s := range subscriptions {
+		s.once.Do(func() { close(s.done) })
+		s.closeStream()
+	}
+}
+
+// remove closes the stream of s if it is still subscribed.
+func (b *Broadcaster) remove(s *Subscription) {
+	b.mu.Lock()
+	subscribed := false
+	for i, sub := range b.subscriptions {
+		if sub == s {
+			b.subscriptions = append(b.subscriptions[:i], b.subscriptions[i+1:]...)
+			subscribed = true
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	if subscribed {
+		s.closeStream()
+	}
+}
+
+// Name returns the name given to Subscribe.
+func (s *Subscription) Name() string {
+	return s.name
+}
+
+// Stream returns the results of the subscription, it is closed when the broadcast ends or on Unsubscribe.
+// It can be used as the source of a Pipeline.
+func (s *Subscription) Stream() <-chan AudioResult {
+	return s.stream
+}
+
+// Delivered returns the number of results handed to the subscriber's buffer.
+func (s *Subscription) Delivered() uint64 {
+	return atomic.LoadUint64(&s.delivered)
+}
+
+// Dropped returns the number of frames discarded because the subscriber's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe removes the subscription and closes its stream, it also releases a broadcast blocked on it.
+// Results still buffered can be drained from the stream.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() { close(s.done) })
+	s.broadcaster.remove(s)
+}
+
+// closeStream closes the stream once no send is in progress.
+func (s *Subscription) closeStream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.stream)
+	}
+}
+
+// send delivers a result according to policy, only the broadcaster sends.
+// A blocked send is released by Unsubscribe, which closes done before the stream.
+func (s *Subscription) send(ctx context.Context, audio AudioResult, policy DropPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.stream <- audio:
+		atomic.AddUint64(&s.delivered, 1)
+		return
+	default:
+	}
+
+	switch policy {
+	case DropPolicyNewest:
+		atomic.AddUint64(&s.dropped, 1)
+
+	case DropPolicyOldest:
+		for {
+			select {
+			case s.stream <- audio:
+				atomic.AddUint64(&s.delivered, 1)
+				return
+			default:
+			}
+
+			select {
+			case <-s.stream:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+
+	default:
+		select {
+		case s.stream <- audio:
+			atomic.AddUint64(&s.delivered, 1)
+		case <-s.done:
+		case <-ctx.Done():
+		}
+	}
+}
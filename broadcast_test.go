@@ -0,0 +1,141 @@
+package goEagi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBroadcasterDeliversToEverySubscriber(t *testing.T) {
+	source := make(chan AudioResult)
+	b := NewBroadcaster(source)
+	first := b.Subscribe("first", 10, DropPolicyBlock)
+	second := b.Subscribe("second", 10, DropPolicyOldest)
+
+	if err := b.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Start(context.Background()); err == nil {
+		t.Fatal("second Start succeeded")
+	}
+
+	for i := 0; i < 5; i++ {
+		source <- AudioResult{Stream: []byte{byte(i)}}
+	}
+	close(source)
+
+	for _, s := range []*Subscription{first, second} {
+		var got []byte
+		for audio := range s.Stream() {
+			got = append(got, audio.Stream...)
+		}
+		if string(got) != "\x00\x01\x02\x03\x04" || s.Delivered() != 5 || s.Dropped() != 0 {
+			t.Errorf("%s received %v, delivered %d, dropped %d", s.Name(), got, s.Delivered(), s.Dropped())
+		}
+	}
+}
+
+func TestBroadcasterDropPolicies(t *testing.T) {
+	source := make(chan AudioResult)
+	b := NewBroadcaster(source)
+	oldest := b.Subscribe("oldest", 2, DropPolicyOldest)
+	newest := b.Subscribe("newest", 2, DropPolicyNewest)
+	b.Start(context.Background())
+
+	for i := 0; i < 5; i++ {
+		source <- AudioResult{Stream: []byte{byte(i)}}
+	}
+	close(source)
+
+	tests := []struct {
+		s    *Subscription
+		want string
+	}{
+		{oldest, "\x03\x04"},
+		{newest, "\x00\x01"},
+	}
+	for _, tc := range tests {
+		var got []byte
+		for audio := range tc.s.Stream() {
+			got = append(got, audio.Stream...)
+		}
+		if string(got) != tc.want || tc.s.Dropped() != 3 {
+			t.Errorf("%s received %v and dropped %d, want %v and 3", tc.s.Name(), got, tc.s.Dropped(), []byte(tc.want))
+		}
+	}
+}
+
+func TestBroadcasterSlowSubscriber(t *testing.T) {
+	source := make(chan AudioResult)
+	b := NewBroadcaster(source)
+	slow := b.Subscribe("slow", 1, DropPolicyBlock)
+	fast := b.Subscribe("fast", 10, DropPolicyNewest)
+	b.Start(context.Background())
+
+	// the second frame blocks on the full buffer of slow.
+	source <- AudioResult{Stream: []byte{0}}
+	source <- AudioResult{Stream: []byte{1}}
+
+	select {
+	case <-fast.Stream():
+	case <-time.After(time.Second):
+		t.Fatal("fast subscriber did not receive the first frame")
+	}
+	select {
+	case audio := <-fast.Stream():
+		if audio.Stream[0] != 1 {
+			t.Fatalf("fast subscriber received %v", audio.Stream)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber held back the frame of the fast one")
+	}
+
+	subscribed := make(chan *Subscription)
+	go func() { subscribed <- b.Subscribe("late", 1, DropPolicyNewest) }()
+	select {
+	case late := <-subscribed:
+		late.Unsubscribe()
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe blocked behind the slow subscriber")
+	}
+
+	// unsubscribing releases the blocked send, the buffered frame can still be drained.
+	slow.Unsubscribe()
+	if audio, ok := <-slow.Stream(); !ok || audio.Stream[0] != 0 {
+		t.Fatalf("slow subscriber drained %v, %v", audio.Stream, ok)
+	}
+	if _, ok := <-slow.Stream(); ok {
+		t.Fatal("stream of an unsubscribed subscriber is open")
+	}
+
+	source <- AudioResult{Stream: []byte{2}}
+	close(source)
+	for range fast.Stream() {
+	}
+	if fast.Delivered() != 3 {
+		t.Errorf("fast subscriber delivered %d, want 3", fast.Delivered())
+	}
+}
+
+func TestBroadcasterSubscribeAfterClose(t *testing.T) {
+	source := make(chan AudioResult)
+	b := NewBroadcaster(source)
+	first := b.Subscribe("first", 1, DropPolicyBlock)
+	b.Start(context.Background())
+	close(source)
+
+	// the stream closes when the broadcast ends.
+	for range first.Stream() {
+	}
+
+	late := b.Subscribe("late", 1, DropPolicyBlock)
+	select {
+	case _, ok := <-late.Stream():
+		if ok {
+			t.Fatal("subscription after the broadcast ended received a result")
+		}
+	default:
+		t.Fatal("subscription after the broadcast ended is open")
+	}
+	late.Unsubscribe()
+}
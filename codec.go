@@ -0,0 +1,213 @@
+// Package goEagi of codec.go provides a common interface over the
+// audio codecs of the package, streaming transcoders which can be put
+// in front of a recognizer, and audio file generation in encoded formats.
+
+package goEagi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Codec converts 16-bit linear samples to an encoded payload and back.
+// Name returns the Asterisk format name, which is also the file extension
+// Asterisk expects for headerless files of the codec.
+type Codec interface {
+	Name() string
+	SampleRate() int
+	Encode(dst []byte, samples []int16) []byte
+	Decode(dst []int16, payload []byte) []int16
+}
+
+// LinearCodec is 16-bit signed linear PCM (slin), it is the identity codec.
+type LinearCodec struct {
+	Rate int
+}
+
+// Name returns the Asterisk format name of the codec.
+func (c LinearCodec) Name() string {
+	if c.SampleRate() == audioSampleRate {
+		return "sln"
+	}
+	return fmt.Sprintf("sln%d", c.SampleRate()/1000)
+}
+
+// SampleRate returns the sample rate of the codec, audioSampleRate if Rate is not set.
+func (c LinearCodec) SampleRate() int {
+	if c.Rate == 0 {
+		return audioSampleRate
+	}
+	return c.Rate
+}
+
+// Encode writes samples as little-endian bytes into dst[:0].
+func (c LinearCodec) Encode(dst []byte, samples []int16) []byte {
+	return Frame(samples).Bytes(dst)
+}
+
+// Decode reads little-endian bytes into dst[:0].
+func (c LinearCodec) Decode(dst []int16, payload []byte) []int16 {
+	return DecodeFrame(dst, payload)
+}
+
+// NewCodec returns the codec of an Asterisk format name: "sln", "sln16", "ulaw", "alaw" or "g722".
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "sln", "slin":
+		return LinearCodec{}, nil
+	case "sln16", "slin16":
+		return LinearCodec{Rate: 16000}, nil
+	case "ulaw", "mulaw", "pcmu":
+		return ULawCodec{}, nil
+	case "alaw", "pcma":
+		return ALawCodec{}, nil
+	case "g722":
+		return NewG722Codec(), nil
+	}
+	return nil, fmt.Errorf("unsupported codec: %s", name)
+}
+
+// EncodeStream launches a new goroutine that encodes a stream of 16-bit linear audio with codec,
+// for example to send μ-law to a recognizer. The audio must already be at codec.SampleRate().
+func EncodeStream(ctx context.Context, stream <-chan []byte, codec Codec) <-chan []byte {
+	encodedStream := make(chan []byte)
+
+	go func() {
+		defer close(encodedStream)
+
+		var samples []int16
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case buf, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				samples = DecodeFrame(samples, buf)
+				encoded := codec.Encode(nil, samples)
+				if len(encoded) == 0 {
+					continue
+				}
+
+				select {
+				case encodedStream <- encoded:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return encodedStream
+}
+
+// DecodeStream launches a new goroutine that decodes a stream encoded with codec to 16-bit linear audio.
+func DecodeStream(ctx context.Context, stream <-chan []byte, codec Codec) <-chan []byte {
+	decodedStream := make(chan []byte)
+
+	go func() {
+		defer close(decodedStream)
+
+		var samples []int16
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case buf, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				samples = codec.Decode(samples, buf)
+
+				select {
+				case decodedStream <- Frame(samples).Bytes(nil):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return decodedStream
+}
+
+// GenerateEncodedAudio encodes a sample slice of 16-bit linear bytes with codec and writes it into an audio file.
+// With a .wav audio name the payload is wrapped in a wav container (slin, ulaw and alaw only),
+// otherwise the extension must be the codec name and a headerless file is written, as Asterisk expects.
+// The sample must already be at codec.SampleRate().
+func GenerateEncodedAudio(sample []byte, codec Codec, audioDirectory string, audioName string) (string, error) {
+	content, err := encodeAudioFile(sample, codec, audioName)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(audioDirectory, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	audioPath := filepath.Join(audioDirectory, audioName)
+	file, err := os.Create(audioPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create audio path: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(content); err != nil {
+		return "", fmt.Errorf("failed to generate audio: %w", err)
+	}
+
+	return audioPath, file.Close()
+}
+
+// encodeAudioFile returns the content of the audio file audioName holding sample encoded with codec.
+func encodeAudioFile(sample []byte, codec Codec, audioName string) ([]byte, error) {
+	extension := filepath.Ext(audioName)
+
+	payload := codec.Encode(nil, DecodeFrame(nil, sample))
+
+	if extension != ".wav" {
+		if extension != "."+codec.Name() {
+			return nil, fmt.Errorf("audio name does not contain .wav or .%s extension", codec.Name())
+		}
+		return payload, nil
+	}
+
+	header, ok := wavHeaderOf(codec)
+	if !ok {
+		return nil, fmt.Errorf("codec %s can not be stored in a wav file", codec.Name())
+	}
+	return append(header.encode(uint32(len(payload))), payload...), nil
+}
+
+// wavHeaderOf returns the wav fmt chunk matching a codec, if the codec has one.
+func wavHeaderOf(codec Codec) (wavHeader, bool) {
+	h := wavHeader{
+		Channels:   audioChannel,
+		SampleRate: uint32(codec.SampleRate()),
+	}
+
+	switch codec.(type) {
+	case LinearCodec:
+		h.AudioFormat = wavFormatPCM
+		h.BitsPerSample = audioBitsPerSample
+	case ULawCodec:
+		h.AudioFormat = wavFormatMuLaw
+		h.BitsPerSample = 8
+	case ALawCodec:
+		h.AudioFormat = wavFormatALaw
+		h.BitsPerSample = 8
+	default:
+		return wavHeader{}, false
+	}
+
+	return h, true
+}
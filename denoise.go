@@ -0,0 +1,231 @@
+// Package goEagi of denoise.go provides a streaming Wiener noise suppressor
+// working on 16-bit mono frames. The noise spectrum is learned during
+// speech pauses, and every bin is attenuated according to its estimated
+// signal to noise ratio.
+
+package goEagi
+
+import (
+	"math"
+	"math/cmplx"
+	"time"
+)
+
+const (
+	denoiseFrameDuration = 32 * time.Millisecond
+
+	denoiseMaxAttenuation  = 30.0
+	denoiseNoiseSmoothing  = 0.95
+	denoiseNoiseDrift      = 1.002
+	denoiseSpeechRatio     = 3.0
+	denoiseDecisionWeight  = 0.98
+	denoiseInitialFrames   = 8
+	defaultDenoiseStrength = 0.7
+)
+
+// NoiseSuppressor reduces stationary background noise with a Wiener filter in the frequency domain,
+// using 50% overlapping sqrt-Hann windowed frames. A NoiseSuppressor is not safe for concurrent use.
+type NoiseSuppressor struct {
+	// Strength between 0 and 1 sets the maximum attenuation of noise, up to 30 dB.
+	Strength float64
+
+	rate   int
+	size   int
+	hop    int
+	window []float64
+
+	frame    []float64
+	pending  int
+	spectrum []complex128
+	overlap  []float64
+	output   []int16
+
+	noise    []float64
+	prevGain []float64
+	prevPost []float64
+	frames   int
+}
+
+// NewNoiseSuppressor creates a NoiseSuppressor for audio at rate with the given strength,
+// a zero strength uses the default.
+func NewNoiseSuppressor(rate int, strength float64) *NoiseSuppressor {
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+	if strength == 0 {
+		strength = defaultDenoiseStrength
+	}
+
+	size := 1
+	for size < rate*int(denoiseFrameDuration/time.Millisecond)/1000 {
+		size <<= 1
+	}
+	hop := size / 2
+	bins := size/2 + 1
+
+	n := NoiseSuppressor{
+		Strength: strength,
+		rate:     rate,
+		size:     size,
+		hop:      hop,
+		window:   make([]float64, size),
+		frame:    make([]float64, size),
+		spectrum: make([]complex128, size),
+		overlap:  make([]float64, size),
+		output:   make([]int16, hop, 4*size),
+		noise:    make([]float64, bins),
+		prevGain: make([]float64, bins),
+		prevPost: make([]float64, bins),
+	}
+
+	for i := range n.window {
+		n.window[i] = math.Sqrt(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size)))
+	}
+
+	return &n
+}
+
+// Latency returns the delay the suppressor adds to the audio, one frame:
+// half of it to complete the overlapping frame and half to fill the output queue.
+func (n *NoiseSuppressor) Latency() time.Duration {
+	return time.Duration(n.size) * time.Second / time.Duration(n.rate)
+}
+
+// Process denoises src into dst[:0] and returns exactly len(src) samples, delayed by Latency().
+func (n *NoiseSuppressor) Process(dst []int16, src []int16) []int16 {
+	for _, s := range src {
+		n.frame[n.size-n.hop+n.pending] = float64(s)
+		n.pending++
+
+		if n.pending == n.hop {
+			n.processFrame()
+			n.pending = 0
+		}
+	}
+
+	dst = append(dst[:0], n.output[:len(src)]...)
+	n.output = n.output[:copy(n.output, n.output[len(src):])]
+	return dst
+}
+
+// processFrame filters the current frame and overlap-adds hop samples to the output.
+func (n *NoiseSuppressor) processFrame() {
+	for i, x := range n.frame {
+		n.spectrum[i] = complex(x*n.window[i], 0)
+	}
+	fft(n.spectrum, false)
+
+	n.updateGains()
+
+	ifft := n.spectrum
+	fft(ifft, true)
+
+	for i := range n.overlap {
+		n.overlap[i] += real(ifft[i]) * n.window[i]
+	}
+	for i := 0; i < n.hop; i++ {
+		n.output = append(n.output, clampInt16(float32(n.overlap[i])))
+	}
+
+	copy(n.overlap, n.overlap[n.hop:])
+	for i := n.size - n.hop; i < n.size; i++ {
+		n.overlap[i] = 0
+	}
+	copy(n.frame, n.frame[n.hop:])
+}
+
+// updateGains updates the noise estimate and applies the decision-directed Wiener gain to the spectrum.
+func (n *NoiseSuppressor) updateGains() {
+	bins := len(n.noise)
+	n.frames++
+
+	var framePower, noisePower float64
+	for k := 0; k < bins; k++ {
+		framePower += sqrAbs(n.spectrum[k])
+		noisePower += n.noise[k]
+	}
+
+	speechAbsent := n.frames <= denoiseInitialFrames || framePower < denoiseSpeechRatio*noisePower
+	for k := 0; k < bins; k++ {
+		p := sqrAbs(n.spectrum[k])
+		switch {
+		case n.frames == 1:
+			n.noise[k] = p
+		case speechAbsent:
+			n.noise[k] = denoiseNoiseSmoothing*n.noise[k] + (1-denoiseNoiseSmoothing)*p
+		default:
+			// let the estimate follow a slowly rising noise floor during long speech.
+			n.noise[k] *= denoiseNoiseDrift
+		}
+	}
+
+	strength := math.Max(0, math.Min(1, n.Strength))
+	minGain := math.Pow(10, -strength*denoiseMaxAttenuation/20)
+
+	for k := 0; k < bins; k++ {
+		noise := n.noise[k]
+		if noise <= 0 {
+			noise = 1e-9
+		}
+
+		post := sqrAbs(n.spectrum[k]) / noise
+		prio := denoiseDecisionWeight*n.prevGain[k]*n.prevGain[k]*n.prevPost[k] + (1-denoiseDecisionWeight)*math.Max(post-1, 0)
+		gain := math.Max(prio/(1+prio), minGain)
+
+		n.prevGain[k] = gain
+		n.prevPost[k] = post
+
+		n.spectrum[k] *= complex(gain, 0)
+		if k > 0 && k < n.size/2 {
+			n.spectrum[n.size-k] = cmplx.Conj(n.spectrum[k])
+		}
+	}
+}
+
+func sqrAbs(c complex128) float64 {
+	return real(c)*real(c) + imag(c)*imag(c)
+}
+
+// fft is an in-place iterative radix-2 FFT, len(x) must be a power of two.
+// The inverse transform is scaled by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+
+	for length := 2; length <= n; length <<= 1 {
+		angle := sign * 2 * math.Pi / float64(length)
+		step := complex(math.Cos(angle), math.Sin(angle))
+		for i := 0; i < n; i += length {
+			w := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				u := x[i+k]
+				v := x[i+k+length/2] * w
+				x[i+k] = u + v
+				x[i+k+length/2] = u - v
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
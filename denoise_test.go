@@ -0,0 +1,95 @@
+package goEagi
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// noisyTone returns a tone of the given peak amplitude starting after the leading samples, and white noise over all of it,
+// together with the clean tone.
+func noisyTone(r *rand.Rand, n, leading int, frequency, amplitude, noise float64) ([]int16, []int16) {
+	clean := make([]int16, n)
+	noisy := make([]int16, n)
+	for i := range clean {
+		if i >= leading {
+			clean[i] = int16(amplitude * math.Sin(2*math.Pi*frequency*float64(i)/audioSampleRate))
+		}
+		noisy[i] = clean[i] + int16(noise*(2*r.Float64()-1))
+	}
+	return clean, noisy
+}
+
+// snr returns the ratio in dB of the power of clean over the power of the difference of processed delayed by delay samples.
+func snr(clean, processed []int16, delay, from int) float64 {
+	var signal, noise float64
+	for i := from; i+delay < len(processed); i++ {
+		s := float64(clean[i])
+		d := float64(processed[i+delay]) - s
+		signal += s * s
+		noise += d * d
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+// rmsLevel returns the RMS level in dBFS of samples.
+func rmsLevel(samples []int16) float64 {
+	return AmplitudeToDb(Frame(samples).RMS())
+}
+
+func denoise(n *NoiseSuppressor, input []int16) []int16 {
+	var output, buf []int16
+	for start := 0; start < len(input); start += 160 {
+		end := start + 160
+		if end > len(input) {
+			end = len(input)
+		}
+		buf = n.Process(buf, input[start:end])
+		if len(buf) != end-start {
+			panic("NoiseSuppressor.Process changed the number of samples")
+		}
+		output = append(output, buf...)
+	}
+	return output
+}
+
+func TestNoiseSuppressorImprovesSNR(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	// 1 s of noise to learn from, then 2 s of a tone at about 11 dB SNR.
+	clean, noisy := noisyTone(r, 3*audioSampleRate, audioSampleRate, 440, 12000, 4000)
+
+	n := NewNoiseSuppressor(audioSampleRate, 0)
+	output := denoise(n, noisy)
+	delay := int(n.Latency().Seconds() * audioSampleRate)
+
+	from := audioSampleRate + audioSampleRate/2
+	before := snr(clean, noisy, 0, from)
+	after := snr(clean, output, delay, from)
+	if after-before < 6 {
+		t.Fatalf("SNR %.1f dB before, %.1f dB after, want an improvement of at least 6 dB", before, after)
+	}
+
+	// the noise alone is attenuated by most of the maximum attenuation, 21 dB at the default strength.
+	noiseIn := rmsLevel(noisy[audioSampleRate/2 : audioSampleRate])
+	noiseOut := rmsLevel(output[audioSampleRate/2+delay : audioSampleRate])
+	if noiseIn-noiseOut < 15 {
+		t.Fatalf("noise at %.1f dBFS attenuated to %.1f dBFS, want at least 15 dB", noiseIn, noiseOut)
+	}
+}
+
+func TestNoiseSuppressorStrength(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	_, noisy := noisyTone(r, audioSampleRate, audioSampleRate, 440, 0, 3000)
+
+	var levels []float64
+	for _, strength := range []float64{0.2, 0.5, 1} {
+		output := denoise(NewNoiseSuppressor(audioSampleRate, strength), noisy)
+		levels = append(levels, rmsLevel(output[audioSampleRate/2:]))
+	}
+
+	for i := 1; i < len(levels); i++ {
+		if levels[i] >= levels[i-1]-3 {
+			t.Fatalf("noise levels %v dBFS with strengths 0.2, 0.5 and 1, want each stronger setting to attenuate more", levels)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Package goEagi of dtmf.go provides in-band DTMF detection on the
+// EAGI audio stream, so that keypresses can be received while the
+// audio is streamed to a speech recognizer.
+
+package goEagi
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	// dtmfBlockSize of 102 samples at 8 kHz gives bins that fit the DTMF frequency grid.
+	dtmfBlockSize = 102
+
+	defaultDTMFMinDuration  = 40 * time.Millisecond
+	defaultDTMFNormalTwist  = 8.0
+	defaultDTMFReverseTwist = 4.0
+	defaultDTMFMinLevel     = -35.0
+	defaultDTMFMinPurity    = 0.7
+	dtmfRelativePeak        = 6.0
+
+	// dtmfRowHarmonic and dtmfColumnHarmonic are how far (dB) the second harmonic of a tone must stay below it.
+	// Speech and music carry harmonics, a key pad does not. The row harmonics lie less than a bin away
+	// from some of the column tones, whose leakage reads about 6 dB below the row tone at the worst twist,
+	// so only a harmonic close to the row tone itself can be rejected there.
+	dtmfRowHarmonic    = 3.0
+	dtmfColumnHarmonic = 15.0
+)
+
+var (
+	dtmfRowFrequencies    = [4]float64{697, 770, 852, 941}
+	dtmfColumnFrequencies = [4]float64{1209, 1336, 1477, 1633}
+	dtmfKeys              = [4][4]rune{
+		{'1', '2', '3', 'A'},
+		{'4', '5', '6', 'B'},
+		{'7', '8', '9', 'C'},
+		{'*', '0', '#', 'D'},
+	}
+)
+
+// DTMFResult is a digit detected in the audio stream.
+// Offset is the position of the start of the digit relative to the start of the stream,
+// and Time is when the start of the digit was captured.
+type DTMFResult struct {
+	Error    error
+	Digit    rune
+	Offset   time.Duration
+	Duration time.Duration
+	Time     time.Time
+}
+
+// DTMFDetector is a Goertzel based DTMF detector working on 8 kHz 16-bit mono audio.
+// A DTMFDetector is not safe for concurrent use.
+type DTMFDetector struct {
+	// MinDuration is how long a tone pair must be present before the digit is reported.
+	MinDuration time.Duration
+	// NormalTwist is how much weaker (dB) the column tone may be than the row tone.
+	NormalTwist float64
+	// ReverseTwist is how much weaker (dB) the row tone may be than the column tone.
+	ReverseTwist float64
+	// MinLevel is the minimum level (dBFS) of each of the two tones.
+	MinLevel float64
+	// MinPurity is the minimum share of the block energy carried by the two tones.
+	MinPurity float64
+
+	rowCoefficients            [4]float64
+	columnCoefficients         [4]float64
+	rowHarmonicCoefficients    [4]float64
+	columnHarmonicCoefficients [4]float64
+
+	block  [dtmfBlockSize]float64
+	filled int
+
+	samples   int64
+	candidate rune
+	hits      int
+	misses    int
+	start     int64
+	reported  bool
+}
+
+// NewDTMFDetector creates a DTMFDetector with the default thresholds.
+func NewDTMFDetector() *DTMFDetector {
+	d := DTMFDetector{
+		MinDuration:  defaultDTMFMinDuration,
+		NormalTwist:  defaultDTMFNormalTwist,
+		ReverseTwist: defaultDTMFReverseTwist,
+		MinLevel:     defaultDTMFMinLevel,
+		MinPurity:    defaultDTMFMinPurity,
+	}
+
+	for i := range dtmfRowFrequencies {
+		d.rowCoefficients[i] = goertzelCoefficient(dtmfRowFrequencies[i], audioSampleRate)
+		d.columnCoefficients[i] = goertzelCoefficient(dtmfColumnFrequencies[i], audioSampleRate)
+		d.rowHarmonicCoefficients[i] = goertzelCoefficient(2*dtmfRowFrequencies[i], audioSampleRate)
+		d.columnHarmonicCoefficients[i] = goertzelCoefficient(2*dtmfColumnFrequencies[i], audioSampleRate)
+	}
+
+	return &d
+}
+
+// Reset clears the detector state, including the stream position.
+func (d *DTMFDetector) Reset() {
+	d.filled = 0
+	d.samples = 0
+	d.start = 0
+	d.candidate = 0
+	d.hits = 0
+	d.misses = 0
+	d.reported = false
+}
+
+// Process analyzes a frame of 16-bit little-endian audio and returns the digits
+// which reached MinDuration within the frame. Each keypress is reported once.
+// The frame is taken as captured right now, see ProcessAt.
+func (d *DTMFDetector) Process(frame []byte) []DTMFResult {
+	return d.ProcessAt(frame, time.Now())
+}
+
+// ProcessAt is Process for a frame captured at the given time, from which the Time of the digits is derived.
+func (d *DTMFDetector) ProcessAt(frame []byte, captured time.Time) []DTMFResult {
+	var results []DTMFResult
+	frameStart := d.samples
+
+	for i := 0; i < len(frame)/audioBytesPerSample; i++ {
+		d.block[d.filled] = float64(sampleAt(frame, i)) / fullScale
+		d.filled++
+		d.samples++
+
+		if d.filled < dtmfBlockSize {
+			continue
+		}
+		d.filled = 0
+
+		if r, ok := d.analyzeBlock(); ok {
+			r.Time = captured.Add(samplesDuration(d.start - frameStart))
+			results = append(results, r)
+		}
+	}
+
+	return results
+}
+
+// Detect launches a new goroutine that detects digits in a stream of audio frames.
+func (d *DTMFDetector) Detect(ctx context.Context, stream <-chan []byte) <-chan DTMFResult {
+	dtmfResultStream := make(chan DTMFResult)
+
+	go func() {
+		defer close(dtmfResultStream)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case buf, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				for _, r := range d.Process(buf) {
+					select {
+					case dtmfResultStream <- r:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return dtmfResultStream
+}
+
+// analyzeBlock classifies the current block and tracks the digit across blocks.
+func (d *DTMFDetector) analyzeBlock() (DTMFResult, bool) {
+	digit := d.classifyBlock()
+
+	if digit == 0 || digit != d.candidate {
+		// a single dropout block is tolerated within a digit.
+		if d.candidate != 0 && digit == 0 && d.misses == 0 {
+			d.misses++
+			return DTMFResult{}, false
+		}
+
+		d.candidate = digit
+		d.hits = 0
+		d.misses = 0
+		d.reported = false
+		d.start = d.samples - dtmfBlockSize
+		if digit == 0 {
+			return DTMFResult{}, false
+		}
+	}
+
+	d.misses = 0
+	d.hits++
+
+	duration := samplesDuration(int64(d.hits * dtmfBlockSize))
+	if d.reported || duration < d.MinDuration {
+		return DTMFResult{}, false
+	}
+	d.reported = true
+
+	return DTMFResult{
+		Digit:    digit,
+		Offset:   samplesDuration(d.start),
+		Duration: duration,
+	}, true
+}
+
+// classifyBlock returns the digit present in the block, or 0 if the block fails
+// the energy, relative peak, twist, purity or second harmonic checks.
+func (d *DTMFDetector) classifyBlock() rune {
+	var blockPower float64
+	for _, s := range d.block {
+		blockPower += s * s
+	}
+	blockPower /= dtmfBlockSize
+	if blockPower == 0 {
+		return 0
+	}
+
+	var rows, columns [4]float64
+	for i := range rows {
+		rows[i] = goertzelPower(d.block[:], d.rowCoefficients[i])
+		columns[i] = goertzelPower(d.block[:], d.columnCoefficients[i])
+	}
+
+	row, rowPower, ok := dominantTone(rows)
+	if !ok {
+		return 0
+	}
+	column, columnPower, ok := dominantTone(columns)
+	if !ok {
+		return 0
+	}
+
+	minPower := dbToPower(d.MinLevel)
+	if rowPower < minPower || columnPower < minPower {
+		return 0
+	}
+
+	twist := powerToDb(rowPower / columnPower)
+	if twist > d.NormalTwist || -twist > d.ReverseTwist {
+		return 0
+	}
+
+	if (rowPower+columnPower)/blockPower < d.MinPurity {
+		return 0
+	}
+
+	if goertzelPower(d.block[:], d.rowHarmonicCoefficients[row]) > rowPower/dbToPower(dtmfRowHarmonic) {
+		return 0
+	}
+	if goertzelPower(d.block[:], d.columnHarmonicCoefficients[column]) > columnPower/dbToPower(dtmfColumnHarmonic) {
+		return 0
+	}
+
+	return dtmfKeys[row][column]
+}
+
+// dominantTone returns the strongest of the tones, if it exceeds all the others by dtmfRelativePeak.
+func dominantTone(powers [4]float64) (int, float64, bool) {
+	best := 0
+	for i, p := range powers {
+		if p > powers[best] {
+			best = i
+		}
+	}
+
+	limit := powers[best] / dbToPower(dtmfRelativePeak)
+	for i, p := range powers {
+		if i != best && p > limit {
+			return 0, 0, false
+		}
+	}
+	return best, powers[best], true
+}
+
+// goertzelCoefficient returns the Goertzel coefficient of a frequency.
+func goertzelCoefficient(frequency float64, rate int) float64 {
+	return 2 * math.Cos(2*math.Pi*frequency/float64(rate))
+}
+
+// goertzelPower returns the power of a sinusoid at the frequency of coefficient in block,
+// normalized so that a full scale sine gives 0.5, the same scale as the mean square of the block.
+func goertzelPower(block []float64, coefficient float64) float64 {
+	var s1, s2 float64
+	for _, x := range block {
+		s0 := x + coefficient*s1 - s2
+		s2 = s1
+		s1 = s0
+	}
+
+	magnitude := s1*s1 + s2*s2 - coefficient*s1*s2
+	n := float64(len(block))
+	return 2 * magnitude / (n * n)
+}
+
+// samplesDuration returns the duration of n samples at audioSampleRate.
+func samplesDuration(n int64) time.Duration {
+	return time.Duration(n) * time.Second / audioSampleRate
+}
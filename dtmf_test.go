@@ -0,0 +1,234 @@
+package goEagi
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// dtmfTone is a component of a test signal: a frequency and its level in dBFS.
+type dtmfTone struct {
+	frequency float64
+	level     float64
+}
+
+// mixAudio returns 16-bit little-endian audio holding the sum of the tones, or silence when there are none.
+func mixAudio(duration time.Duration, tones ...dtmfTone) []byte {
+	samples := make(Frame, int(duration.Seconds()*audioSampleRate))
+	for i := range samples {
+		var v float64
+		for _, tone := range tones {
+			// the level is the RMS level, sqrt(2) times below the peak.
+			amplitude := math.Sqrt2 * math.Pow(10, tone.level/20) * fullScale
+			v += amplitude * math.Sin(2*math.Pi*tone.frequency*float64(i)/audioSampleRate)
+		}
+		samples[i] = int16(v)
+	}
+	return samples.Bytes(nil)
+}
+
+// digitAudio returns the tone pair of a digit, with the row tone at rowLevel and the column tone at columnLevel.
+func digitAudio(digit rune, duration time.Duration, rowLevel, columnLevel float64) []byte {
+	for r, keys := range dtmfKeys {
+		for c, key := range keys {
+			if key == digit {
+				return mixAudio(duration,
+					dtmfTone{dtmfRowFrequencies[r], rowLevel},
+					dtmfTone{dtmfColumnFrequencies[c], columnLevel})
+			}
+		}
+	}
+	panic("not a DTMF digit")
+}
+
+// detectDigits feeds the audio to a DTMFDetector in 20 ms frames and returns the reported digits.
+func detectDigits(audio ...[]byte) []DTMFResult {
+	d := NewDTMFDetector()
+
+	var results []DTMFResult
+	for _, a := range audio {
+		for start := 0; start < len(a); start += 320 {
+			end := start + 320
+			if end > len(a) {
+				end = len(a)
+			}
+			results = append(results, d.Process(a[start:end])...)
+		}
+	}
+	return results
+}
+
+func digits(results []DTMFResult) string {
+	var s []rune
+	for _, r := range results {
+		s = append(s, r.Digit)
+	}
+	return string(s)
+}
+
+func TestDTMFDetectorDigits(t *testing.T) {
+	for _, keys := range dtmfKeys {
+		for _, digit := range keys {
+			silence := mixAudio(100 * time.Millisecond)
+			results := detectDigits(silence, digitAudio(digit, 80*time.Millisecond, -10, -10), silence)
+
+			if got := digits(results); got != string(digit) {
+				t.Errorf("%c: detected %q", digit, got)
+				continue
+			}
+			// the digit starts with the first block fully inside the tone.
+			if offset := results[0].Offset; offset < 100*time.Millisecond || offset > 100*time.Millisecond+samplesDuration(dtmfBlockSize) {
+				t.Errorf("%c: offset %v, want within a block after 100ms", digit, offset)
+			}
+		}
+	}
+}
+
+func TestDTMFDetectorSequence(t *testing.T) {
+	var audio [][]byte
+	for _, digit := range "1234567890*#ABCD" {
+		audio = append(audio, digitAudio(digit, 60*time.Millisecond, -15, -13), mixAudio(60*time.Millisecond))
+	}
+
+	if got := digits(detectDigits(audio...)); got != "1234567890*#ABCD" {
+		t.Fatalf("detected %q", got)
+	}
+}
+
+func TestDTMFDetectorTwist(t *testing.T) {
+	tests := []struct {
+		name          string
+		row, column   float64
+		wantDetection bool
+	}{
+		{"normal twist within limit", -10, -17, true},
+		{"normal twist beyond limit", -10, -20, false},
+		{"reverse twist within limit", -13, -10, true},
+		{"reverse twist beyond limit", -15, -10, false},
+	}
+
+	for _, tt := range tests {
+		got := digits(detectDigits(digitAudio('5', 80*time.Millisecond, tt.row, tt.column)))
+		if (got == "5") != tt.wantDetection {
+			t.Errorf("%s: detected %q", tt.name, got)
+		}
+	}
+}
+
+func TestDTMFDetectorMinimumLevel(t *testing.T) {
+	if got := digits(detectDigits(digitAudio('8', 80*time.Millisecond, -40, -40))); got != "" {
+		t.Fatalf("tones below MinLevel detected as %q", got)
+	}
+}
+
+func TestDTMFDetectorMinimumDuration(t *testing.T) {
+	silence := mixAudio(50 * time.Millisecond)
+
+	if got := digits(detectDigits(silence, digitAudio('3', 25*time.Millisecond, -10, -10), silence)); got != "" {
+		t.Fatalf("25ms tone detected as %q", got)
+	}
+	if got := digits(detectDigits(silence, digitAudio('3', 70*time.Millisecond, -10, -10), silence)); got != "3" {
+		t.Fatalf("70ms tone detected as %q", got)
+	}
+}
+
+func TestDTMFDetectorDropout(t *testing.T) {
+	// one block of silence, as a short dropout on the line, is tolerated within a digit.
+	tone := Frame(DecodeFrame(nil, digitAudio('9', 60*time.Millisecond, -10, -10))[:4*dtmfBlockSize]).Bytes(nil)
+	gap := make([]byte, dtmfBlockSize*audioBytesPerSample)
+
+	if got := digits(detectDigits(tone, gap, tone)); got != "9" {
+		t.Fatalf("digit with a one block dropout detected as %q", got)
+	}
+
+	// a longer gap separates two keypresses.
+	if got := digits(detectDigits(tone, gap, gap, tone)); got != "99" {
+		t.Fatalf("digit pressed twice detected as %q", got)
+	}
+}
+
+func TestDTMFDetectorRejectsHarmonics(t *testing.T) {
+	// tone pairs whose column tone has a second harmonic 10 dB below it, as a voiced sound could produce.
+	// Every other check accepts them.
+	for r, keys := range dtmfKeys {
+		for c, digit := range keys {
+			audio := mixAudio(100*time.Millisecond,
+				dtmfTone{dtmfRowFrequencies[r], -10},
+				dtmfTone{dtmfColumnFrequencies[c], -10},
+				dtmfTone{2 * dtmfColumnFrequencies[c], -20})
+
+			if got := digits(detectDigits(audio)); got != "" {
+				t.Errorf("%c with a second harmonic detected as %q", digit, got)
+			}
+		}
+	}
+}
+
+func TestDTMFDetectorSpeechAndMusic(t *testing.T) {
+	// a vowel-like sound, harmonics of a gliding pitch shaped by two formants.
+	speech := make(Frame, 2*audioSampleRate)
+	var phase float64
+	for i := range speech {
+		pitch := 110 + 40*math.Sin(2*math.Pi*float64(i)/audioSampleRate)
+		phase += 2 * math.Pi * pitch / audioSampleRate
+
+		var v float64
+		for k := 1; float64(k)*pitch < 3800; k++ {
+			f := float64(k) * pitch
+			gain := 1/(1+math.Pow((f-700)/150, 2)) + 0.7/(1+math.Pow((f-1200)/200, 2))
+			v += gain * math.Sin(float64(k)*phase)
+		}
+		speech[i] = int16(4000 * v)
+	}
+
+	// a sequence of chords of notes rich in harmonics.
+	var music []byte
+	for _, chord := range [][]float64{{261.6, 329.6, 392}, {349.2, 440, 523.3}, {392, 493.9, 587.3}, {698.5, 880, 1318.5}} {
+		var tones []dtmfTone
+		for _, note := range chord {
+			for k := 1; float64(k)*note < 3800; k++ {
+				tones = append(tones, dtmfTone{float64(k) * note, -18 - 6*float64(k-1)})
+			}
+		}
+		music = append(music, mixAudio(250*time.Millisecond, tones...)...)
+	}
+
+	if got := digits(detectDigits(speech.Bytes(nil))); got != "" {
+		t.Errorf("speech detected as %q", got)
+	}
+	if got := digits(detectDigits(music)); got != "" {
+		t.Errorf("music detected as %q", got)
+	}
+}
+
+func TestDTMFDetectorCaptureTime(t *testing.T) {
+	audio := append(mixAudio(100*time.Millisecond), digitAudio('7', 80*time.Millisecond, -10, -10)...)
+	captured := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d := NewDTMFDetector()
+	var results []DTMFResult
+	for start := 0; start < len(audio); start += 320 {
+		results = append(results, d.ProcessAt(audio[start:start+320], captured.Add(frameDuration(start)))...)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("detected %q", digits(results))
+	}
+	// the digit is reported frames after it started, its Time is still its start.
+	if want := captured.Add(results[0].Offset); !results[0].Time.Equal(want) {
+		t.Fatalf("time %v, want %v", results[0].Time, want)
+	}
+}
+
+func TestDTMFDetectorReset(t *testing.T) {
+	d := NewDTMFDetector()
+	// a keypress cut by the reset, after a second of the previous stream.
+	d.Process(append(mixAudio(time.Second), digitAudio('5', 20*time.Millisecond, -10, -10)...))
+	d.Reset()
+
+	captured := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	results := d.ProcessAt(digitAudio('5', 80*time.Millisecond, -10, -10), captured)
+	if len(results) != 1 || results[0].Offset != 0 || !results[0].Time.Equal(captured) {
+		t.Fatalf("after Reset detected %+v, want '5' at the start of the new stream", results)
+	}
+}
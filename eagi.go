@@ -12,6 +12,11 @@ import (
 
 type Eagi struct {
 	*agi.Session
+
+	// Sensitive masks recordings, recognizers and DTMF results during sensitive sections of the call.
+	Sensitive *SensitiveGuard
+	// SensitiveGetData makes every GetData a sensitive section.
+	SensitiveGetData bool
 }
 
 func New() (*Eagi, error) {
@@ -22,6 +27,15 @@ func New() (*Eagi, error) {
 
 	e := Eagi{}
 	e.Session = newSession
+	e.Sensitive = NewSensitiveGuard()
 
 	return &e, nil
 }
+
+// GetData prompts for DTMF like agi.Session.GetData, inside a sensitive section when SensitiveGetData is set.
+func (e *Eagi) GetData(file string, params ...int) (agi.Reply, error) {
+	if e.SensitiveGetData && e.Sensitive != nil {
+		defer e.Sensitive.Begin("GetData " + file)()
+	}
+	return e.Session.GetData(file, params...)
+}
@@ -0,0 +1,582 @@
+// Package goEagi of encrypt.go provides envelope encryption of recordings
+// at rest. Every file is sealed in AES-256-GCM chunks under its own data
+// key, which is wrapped by a KeyProvider and stored in the file header.
+// Chunk nonces carry the chunk index and a last-chunk flag, so that
+// reordered, tampered or truncated files are detected when decrypting.
+
+package goEagi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	encryptionMagic           = "EAGIENC1"
+	encryptionChunkSize       = 32 * 1024
+	encryptionMaxChunkSize    = 16 * 1024 * 1024
+	encryptionNoncePrefixSize = 7
+	dataKeySize               = 32
+)
+
+var (
+	// ErrTampered is returned when an encrypted file fails authentication, or ends before its last chunk.
+	ErrTampered = errors.New("encrypted audio is tampered or truncated")
+
+	// ErrIncomplete is returned by RecoverFile when the file ends before its last chunk, as a recording
+	// does when it crashes before being closed. It wraps ErrTampered.
+	ErrIncomplete = fmt.Errorf("%w: last chunk is missing", ErrTampered)
+
+	// ErrNotEncrypted is returned when decrypting a file which was not written by an EncryptWriter.
+	ErrNotEncrypted = errors.New("audio is not encrypted")
+)
+
+// KeyProvider wraps the data key of every encrypted file with a key encryption key it holds.
+type KeyProvider interface {
+	// WrapKey encrypts a data key, returning the ID of the key encryption key used.
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+	// UnwrapKey decrypts a data key wrapped by the key keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KeyfileProvider wraps data keys with AES-256-GCM under a key read from a local file.
+type KeyfileProvider struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKeyfileProvider reads a 32-byte key from path, stored either raw or hex-encoded.
+func NewKeyfileProvider(path string) (*KeyfileProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+
+	key := content
+	if len(content) != dataKeySize {
+		key, err = hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil || len(key) != dataKeySize {
+			return nil, fmt.Errorf("keyfile %s must hold 32 raw or 64 hex-encoded bytes", path)
+		}
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(key)
+	return &KeyfileProvider{id: "keyfile:" + hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// GenerateKeyfile writes a new random hex-encoded key to path, readable by its owner only.
+// An existing file is never overwritten.
+func GenerateKeyfile(path string) error {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create keyfile: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	return file.Close()
+}
+
+// KeyID identifies the key of the keyfile without revealing it.
+func (k *KeyfileProvider) KeyID() string {
+	return k.id
+}
+
+// WrapKey seals dataKey under the key of the keyfile.
+func (k *KeyfileProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.id, k.aead.Seal(nonce, nonce, dataKey, []byte(k.id)), nil
+}
+
+// UnwrapKey opens a data key sealed by WrapKey.
+func (k *KeyfileProvider) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != k.id {
+		return nil, fmt.Errorf("data key is wrapped by unknown key %s", keyID)
+	}
+
+	nonceSize := k.aead.NonceSize()
+	if len(wrapped) < nonceSize+k.aead.Overhead() {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", ErrTampered)
+	}
+
+	dataKey, err := k.aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], []byte(k.id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", ErrTampered)
+	}
+	return dataKey, nil
+}
+
+// EncryptWriter encrypts a stream in chunks as it is written. The header, holding the wrapped
+// data key, is written by NewEncryptWriter, and every chunk is written once full.
+// Close seals the last chunk, without which the stream is detected as truncated: the file of a
+// recording which crashed before Close is refused by DecryptFile, and only RecoverFile reads it.
+type EncryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	sealed  []byte
+	counter uint32
+	closed  bool
+	err     error
+}
+
+// NewEncryptWriter generates a data key, wraps it with provider and writes the header to w.
+func NewEncryptWriter(w io.Writer, provider KeyProvider) (*EncryptWriter, error) {
+	dataKey := make([]byte, dataKeySize)
+	prefix := make([]byte, encryptionNoncePrefixSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	keyID, wrapped, err := provider.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	if len(keyID) > 0xFF || len(wrapped) > 0xFFFF {
+		return nil, errors.New("wrapped data key is too large")
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	be := binary.BigEndian
+	header := make([]byte, 0, len(encryptionMagic)+1+len(keyID)+2+len(wrapped)+4+len(prefix))
+	header = append(header, encryptionMagic...)
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = append(header, 0, 0)
+	be.PutUint16(header[len(header)-2:], uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	header = append(header, 0, 0, 0, 0)
+	be.PutUint32(header[len(header)-4:], encryptionChunkSize)
+	header = append(header, prefix...)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+
+	return &EncryptWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+// Write buffers p, sealing and writing every chunk that fills up.
+func (e *EncryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("encrypt writer is closed")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if e.err != nil {
+			return written, e.err
+		}
+
+		// a full chunk is only sealed once more data arrives, as it may be the last one.
+		if len(e.buf) == encryptionChunkSize {
+			e.seal(false)
+			continue
+		}
+
+		n := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Close seals and writes the last chunk. The underlying writer is not closed.
+func (e *EncryptWriter) Close() error {
+	if e.closed {
+		return e.err
+	}
+	e.closed = true
+
+	if e.err == nil {
+		e.seal(true)
+	}
+	return e.err
+}
+
+func (e *EncryptWriter) seal(last bool) {
+	if e.counter == ^uint32(0) {
+		e.err = errors.New("encrypted stream is too long")
+		return
+	}
+
+	e.sealed = e.aead.Seal(e.sealed[:0], chunkNonce(e.prefix, e.counter, last), e.buf, e.header)
+	if _, err := e.w.Write(e.sealed); err != nil {
+		e.err = fmt.Errorf("failed to write encrypted chunk: %w", err)
+		return
+	}
+
+	e.counter++
+	e.buf = e.buf[:0]
+}
+
+// DecryptReader decrypts a stream written by an EncryptWriter. Only authenticated data is returned:
+// a chunk failing authentication, or a stream ending before its last chunk, reports ErrTampered.
+type DecryptReader struct {
+	r         *bufio.Reader
+	aead      cipher.AEAD
+	header    []byte
+	prefix    []byte
+	chunkSize int
+	counter   uint32
+	sealed    []byte
+	plain     []byte
+	done      bool
+	recovery  bool
+	err       error
+}
+
+// NewDecryptReader reads the header of an encrypted stream and unwraps its data key with provider.
+func NewDecryptReader(r io.Reader, provider KeyProvider) (*DecryptReader, error) {
+	var header bytes.Buffer
+	br := bufio.NewReader(r)
+	tee := io.TeeReader(br, &header)
+
+	readField := func(size int) ([]byte, error) {
+		field := make([]byte, size)
+		if _, err := io.ReadFull(tee, field); err != nil {
+			return nil, fmt.Errorf("failed to read encryption header: %w", err)
+		}
+		return field, nil
+	}
+
+	magic, err := readField(len(encryptionMagic))
+	if err != nil || string(magic) != encryptionMagic {
+		return nil, ErrNotEncrypted
+	}
+
+	size, err := readField(1)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := readField(int(size[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if size, err = readField(2); err != nil {
+		return nil, err
+	}
+	wrapped, err := readField(int(binary.BigEndian.Uint16(size)))
+	if err != nil {
+		return nil, err
+	}
+
+	if size, err = readField(4); err != nil {
+		return nil, err
+	}
+	chunkSize := int(binary.BigEndian.Uint32(size))
+	if chunkSize <= 0 || chunkSize > encryptionMaxChunkSize {
+		return nil, fmt.Errorf("invalid encrypted chunk size %d", chunkSize)
+	}
+
+	prefix, err := readField(encryptionNoncePrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := provider.UnwrapKey(string(keyID), wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataKey) != dataKeySize {
+		return nil, fmt.Errorf("invalid data key size %d", len(dataKey))
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecryptReader{
+		r:         br,
+		aead:      aead,
+		header:    header.Bytes(),
+		prefix:    prefix,
+		chunkSize: chunkSize,
+		sealed:    make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// Read returns decrypted data, and io.EOF once the last chunk has been read.
+func (d *DecryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads, authenticates and decrypts the next chunk.
+func (d *DecryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+
+	last := false
+	switch {
+	case err == io.EOF && d.recovery:
+		return ErrIncomplete
+	case err == io.EOF:
+		return fmt.Errorf("missing last chunk: %w", ErrTampered)
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("failed to read encrypted chunk: %w", err)
+	default:
+		// a full chunk is the last one when nothing follows it.
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return fmt.Errorf("failed to read encrypted chunk: %w", err)
+		}
+	}
+
+	var plain []byte
+	if last && n == len(d.sealed) {
+		// a full chunk ending the stream is the last one, or the stream was cut after it.
+		// A failed Open clears its output, so the chunk is kept to be opened again.
+		plain, err = d.aead.Open(nil, chunkNonce(d.prefix, d.counter, true), d.sealed, d.header)
+		if err != nil {
+			last = false
+			plain, err = d.aead.Open(d.sealed[:0], chunkNonce(d.prefix, d.counter, false), d.sealed, d.header)
+		}
+	} else {
+		plain, err = d.aead.Open(d.sealed[:0], chunkNonce(d.prefix, d.counter, last), d.sealed[:n], d.header)
+	}
+	if err != nil && last && d.recovery {
+		// the stream was cut in the middle of a chunk.
+		return ErrIncomplete
+	}
+	if err != nil {
+		return fmt.Errorf("chunk %d failed authentication: %w", d.counter, ErrTampered)
+	}
+
+	d.plain = plain
+	d.counter++
+	d.done = last
+	return nil
+}
+
+// EncryptedStorage encrypts the objects of Storage, sidecars included, with data keys wrapped by Provider.
+// Its writers are append-only, so a Recorder leaves the data size of the wav header unknown,
+// and Sync persists sealed chunks only, the last 2 seconds of 8 kHz audio at most being buffered.
+type EncryptedStorage struct {
+	Storage  Storage
+	Provider KeyProvider
+}
+
+// NewEncryptedStorage creates an EncryptedStorage over storage.
+func NewEncryptedStorage(storage Storage, provider KeyProvider) *EncryptedStorage {
+	return &EncryptedStorage{Storage: storage, Provider: provider}
+}
+
+// Create starts a new encrypted object.
+func (s *EncryptedStorage) Create(ctx context.Context, key string) (StorageWriter, error) {
+	w, err := s.Storage.Create(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := NewEncryptWriter(w, s.Provider)
+	if err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return &encryptedWriter{StorageWriter: w, enc: enc}, nil
+}
+
+// Open decrypts an object.
+func (s *EncryptedStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := s.Storage.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := NewDecryptReader(rc, s.Provider)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{dec, rc}, nil
+}
+
+// Delete removes an object.
+func (s *EncryptedStorage) Delete(ctx context.Context, key string) error {
+	return s.Storage.Delete(ctx, key)
+}
+
+type encryptedWriter struct {
+	StorageWriter
+	enc *EncryptWriter
+}
+
+func (w *encryptedWriter) Write(p []byte) (int, error) {
+	return w.enc.Write(p)
+}
+
+// WriteAt can not rewrite sealed chunks.
+func (w *encryptedWriter) WriteAt([]byte, int64) (int, error) {
+	return 0, ErrAppendOnly
+}
+
+// Close seals the last chunk and commits the object.
+func (w *encryptedWriter) Close() error {
+	if err := w.enc.Close(); err != nil {
+		w.StorageWriter.Abort()
+		return err
+	}
+	return w.StorageWriter.Close()
+}
+
+// GenerateEncryptedAudio is GenerateEncodedAudio writing the audio file encrypted with a data key wrapped by provider.
+// Decrypt it with DecryptFile before playing it back with Asterisk.
+func GenerateEncryptedAudio(sample []byte, codec Codec, provider KeyProvider, audioDirectory string, audioName string) (string, error) {
+	content, err := encodeAudioFile(sample, codec, audioName)
+	if err != nil {
+		return "", err
+	}
+
+	audioPath := filepath.Join(audioDirectory, audioName)
+
+	w, err := NewEncryptedStorage(&LocalStorage{}, provider).Create(context.Background(), audioPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create audio path: %w", err)
+	}
+
+	if _, err := w.Write(content); err != nil {
+		w.Abort()
+		return "", fmt.Errorf("failed to generate audio: %w", err)
+	}
+
+	return audioPath, w.Close()
+}
+
+// DecryptFile decrypts inputPath into outputPath, such as a temporary file for playback.
+// Nothing is left at outputPath when the input fails authentication, including the file
+// of a recording which crashed before being closed: use RecoverFile to read it.
+func DecryptFile(inputPath string, outputPath string, provider KeyProvider) error {
+	return decryptFile(inputPath, outputPath, provider, false)
+}
+
+// RecoverFile decrypts the authenticated prefix of inputPath into outputPath, such as the file of
+// a recording which crashed before its last chunk was sealed. The prefix is written even when an
+// error is returned: ErrIncomplete when the file ends before its last chunk, ErrTampered when a
+// chunk fails authentication. Up to the last chunk size of audio buffered at the crash is lost,
+// and a tampered end of file is indistinguishable from a crash.
+func RecoverFile(inputPath string, outputPath string, provider KeyProvider) error {
+	return decryptFile(inputPath, outputPath, provider, true)
+}
+
+func decryptFile(inputPath string, outputPath string, provider KeyProvider, recovery bool) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dec, err := NewDecryptReader(in, provider)
+	if err != nil {
+		return err
+	}
+	dec.recovery = recovery
+
+	tmp := outputPath + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, decryptErr := io.Copy(out, dec)
+	if decryptErr != nil && !recovery {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to decrypt %s: %w", inputPath, decryptErr)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, outputPath); err != nil {
+		return err
+	}
+	if decryptErr != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", inputPath, decryptErr)
+	}
+	return nil
+}
+
+// newGCM returns AES-256-GCM keyed by key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce of a chunk: the random prefix of the stream, the chunk index and the last-chunk flag.
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, encryptionNoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,254 @@
+package goEagi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const sealedChunkSize = encryptionChunkSize + 16
+
+// testKeyProvider returns a KeyfileProvider over a new keyfile.
+func testKeyProvider(t *testing.T) *KeyfileProvider {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := GenerateKeyfile(path); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := NewKeyfileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// encrypt returns data encrypted in writes of odd sizes, and the size of the header.
+// The last chunk is only sealed when closed is true, as when a recording crashed otherwise.
+func encrypt(t *testing.T, provider KeyProvider, data []byte, closed bool) ([]byte, int) {
+	t.Helper()
+
+	var out bytes.Buffer
+	enc, err := NewEncryptWriter(&out, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := out.Len()
+
+	for rest := data; len(rest) > 0; {
+		n := len(rest)
+		if n > 7777 {
+			n = 7777
+		}
+		if _, err := enc.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+
+	if closed {
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.Bytes(), header
+}
+
+// decrypt returns the data read from the encrypted stream until an error.
+func decrypt(provider KeyProvider, encrypted []byte) ([]byte, error) {
+	dec, err := NewDecryptReader(bytes.NewReader(encrypted), provider)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dec)
+}
+
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	provider := testKeyProvider(t)
+
+	for _, size := range []int{0, 1, encryptionChunkSize, 3 * encryptionChunkSize, 5*encryptionChunkSize/2 + 3} {
+		data := randomData(size)
+		encrypted, header := encrypt(t, provider, data, true)
+
+		chunks := (size + encryptionChunkSize - 1) / encryptionChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		if want := header + size + chunks*16; len(encrypted) != want {
+			t.Errorf("%d bytes: encrypted into %d bytes, want %d", size, len(encrypted), want)
+		}
+
+		decrypted, err := decrypt(provider, encrypted)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Fatalf("%d bytes: decrypted data differs", size)
+		}
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	provider := testKeyProvider(t)
+	data := randomData(5*encryptionChunkSize/2 + 3)
+	encrypted, header := encrypt(t, provider, data, true)
+
+	tests := []struct {
+		name   string
+		size   int
+		chunks int
+	}{
+		{"header only", header, 0},
+		{"after the first chunk", header + sealedChunkSize, 1},
+		{"after the second chunk", header + 2*sealedChunkSize, 2},
+		{"within a chunk", header + sealedChunkSize + 100, 1},
+		{"within the last chunk", len(encrypted) - 1, 2},
+	}
+
+	for _, tt := range tests {
+		decrypted, err := decrypt(provider, encrypted[:tt.size])
+		if !errors.Is(err, ErrTampered) {
+			t.Errorf("%s: got %v, want ErrTampered", tt.name, err)
+		}
+		// only authenticated chunks are returned.
+		if !bytes.Equal(decrypted, data[:tt.chunks*encryptionChunkSize]) {
+			t.Errorf("%s: returned %d bytes, want the %d authenticated chunks", tt.name, len(decrypted), tt.chunks)
+		}
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	provider := testKeyProvider(t)
+	data := randomData(3 * encryptionChunkSize)
+	encrypted, header := encrypt(t, provider, data, true)
+
+	tamper := func(offset int) []byte {
+		tampered := append([]byte(nil), encrypted...)
+		tampered[offset] ^= 1
+		return tampered
+	}
+	reorder := func(i, j int) []byte {
+		reordered := append([]byte(nil), encrypted...)
+		chunk := func(k int) []byte { return reordered[header+k*sealedChunkSize : header+(k+1)*sealedChunkSize] }
+		a := append([]byte(nil), chunk(i)...)
+		copy(chunk(i), chunk(j))
+		copy(chunk(j), a)
+		return reordered
+	}
+
+	tests := []struct {
+		name      string
+		encrypted []byte
+		chunks    int
+	}{
+		// the chunk size field and the nonce prefix are only checked as authenticated data of the chunks.
+		{"chunk size in header", tamper(header - encryptionNoncePrefixSize - 1), 0},
+		{"nonce prefix in header", tamper(header - 1), 0},
+		{"wrapped key in header", tamper(header - encryptionNoncePrefixSize - 4 - 1), 0},
+		{"first chunk", tamper(header + 10), 0},
+		{"second chunk tag", tamper(header + 2*sealedChunkSize - 1), 1},
+		{"last chunk", tamper(len(encrypted) - 100), 2},
+		{"first chunks swapped", reorder(0, 1), 0},
+		{"last chunks swapped", reorder(1, 2), 1},
+		{"chunk duplicated", append(append([]byte(nil), encrypted[:header+sealedChunkSize]...), encrypted[header:]...), 1},
+	}
+
+	for _, tt := range tests {
+		decrypted, err := decrypt(provider, tt.encrypted)
+		if !errors.Is(err, ErrTampered) {
+			t.Errorf("%s: got %v, want ErrTampered", tt.name, err)
+		}
+		if !bytes.Equal(decrypted, data[:tt.chunks*encryptionChunkSize]) {
+			t.Errorf("%s: returned %d bytes, want the %d authenticated chunks", tt.name, len(decrypted), tt.chunks)
+		}
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	provider := testKeyProvider(t)
+	encrypted, _ := encrypt(t, provider, randomData(1000), true)
+
+	if _, err := decrypt(testKeyProvider(t), encrypted); err == nil || errors.Is(err, ErrTampered) {
+		t.Fatalf("another keyfile: got %v, want an unknown key error", err)
+	}
+
+	// a different key under the same ID, as a keyfile replaced in place.
+	other := testKeyProvider(t)
+	other.id = provider.id
+	if _, err := decrypt(other, encrypted); !errors.Is(err, ErrTampered) {
+		t.Fatalf("different key with the same ID: got %v, want ErrTampered", err)
+	}
+
+	if _, err := decrypt(provider, []byte("RIFF\x24\x00\x00\x00WAVEfmt ")); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("plain wav: got %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestDecryptFile(t *testing.T) {
+	provider := testKeyProvider(t)
+	dir := t.TempDir()
+	data := randomData(5*encryptionChunkSize/2 + 3)
+
+	complete, _ := encrypt(t, provider, data, true)
+	crashed, _ := encrypt(t, provider, data, false)
+	// a crash in the middle of writing the next chunk.
+	torn := append(append([]byte(nil), crashed...), complete[len(complete)-100:]...)
+
+	write := func(name string, content []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	output := filepath.Join(dir, "out.wav")
+	if err := DecryptFile(write("complete.enc", complete), output, provider); err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, _ := os.ReadFile(output); !bytes.Equal(decrypted, data) {
+		t.Fatal("decrypted file differs")
+	}
+	os.Remove(output)
+
+	for name, content := range map[string][]byte{"crashed.enc": crashed, "torn.enc": torn} {
+		input := write(name, content)
+
+		if err := DecryptFile(input, output, provider); !errors.Is(err, ErrTampered) {
+			t.Fatalf("%s: DecryptFile returned %v, want ErrTampered", name, err)
+		}
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Fatalf("%s: DecryptFile left an output", name)
+		}
+
+		err := RecoverFile(input, output, provider)
+		if !errors.Is(err, ErrIncomplete) || !errors.Is(err, ErrTampered) {
+			t.Fatalf("%s: RecoverFile returned %v, want ErrIncomplete", name, err)
+		}
+		// the half chunk still buffered at the crash is lost.
+		if recovered, _ := os.ReadFile(output); !bytes.Equal(recovered, data[:2*encryptionChunkSize]) {
+			t.Fatalf("%s: recovered %d bytes, want the 2 sealed chunks", name, len(recovered))
+		}
+		os.Remove(output)
+	}
+
+	if err := RecoverFile(filepath.Join(dir, "complete.enc"), output, provider); err != nil {
+		t.Fatalf("RecoverFile of a complete file returned %v", err)
+	}
+	if recovered, _ := os.ReadFile(output); !bytes.Equal(recovered, data) {
+		t.Fatal("recovered complete file differs")
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) != 0 {
+		t.Fatalf("temporary files left: %v", matches)
+	}
+}
@@ -0,0 +1,63 @@
+// Package goEagi of formats.go provides the generation of a sound file
+// in several Asterisk-native formats side by side, so that Asterisk plays
+// the one matching the channel without transcoding.
+
+package goEagi
+
+import (
+	"fmt"
+)
+
+// DefaultAudioFormats returns the formats written by GenerateAudioFormats when none is given,
+// named like the file extensions Asterisk looks for.
+func DefaultAudioFormats() []string {
+	return []string{"sln", "sln16", "ulaw", "alaw", "g722", "wav"}
+}
+
+// GenerateAudioFormats writes sample, 16-bit linear bytes at rate (8 kHz if zero), into one file per format named baseName.<format>,
+// resampled to the rate of each format. The formats are those of NewCodec and "wav", an 8 kHz linear wav file.
+// It returns the path without extension, which StreamFile accepts and resolves to the best available format.
+func GenerateAudioFormats(sample []byte, rate int, audioDirectory string, baseName string, formats ...string) (string, error) {
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+	if len(formats) == 0 {
+		formats = DefaultAudioFormats()
+	}
+
+	samples := DecodeFrame(nil, sample)
+	resampled := map[int][]byte{}
+
+	var audioPath string
+	for _, format := range formats {
+		var codec Codec
+		var extension string
+
+		if format == "wav" {
+			codec, extension = LinearCodec{}, ".wav"
+		} else {
+			c, err := NewCodec(format)
+			if err != nil {
+				return "", err
+			}
+			codec, extension = c, "."+c.Name()
+		}
+
+		target := codec.SampleRate()
+		if _, ok := resampled[target]; !ok {
+			out, err := ResampleSamples(samples, rate, target, ResampleQualityHigh)
+			if err != nil {
+				return "", fmt.Errorf("failed to resample to %d Hz: %w", target, err)
+			}
+			resampled[target] = Frame(out).Bytes(nil)
+		}
+
+		path, err := GenerateEncodedAudio(resampled[target], codec, audioDirectory, baseName+extension)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate %s: %w", format, err)
+		}
+		audioPath = path[:len(path)-len(extension)]
+	}
+
+	return audioPath, nil
+}
@@ -0,0 +1,86 @@
+package goEagi
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateAudioFormats(t *testing.T) {
+	for _, rate := range []int{8000, 16000} {
+		dir := t.TempDir()
+		sample := Frame(sine(rate, 1000, rate, 10000)).Bytes(nil)
+
+		base, err := GenerateAudioFormats(sample, rate, dir, "prompt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dir, "prompt"); base != want {
+			t.Fatalf("%d Hz: returned %s, want %s", rate, base, want)
+		}
+
+		tests := []struct {
+			format string
+			size   int
+			rate   int
+		}{
+			{"sln", 16000, 8000},
+			{"sln16", 32000, 16000},
+			{"ulaw", 8000, 8000},
+			{"alaw", 8000, 8000},
+			{"g722", 8000, 16000},
+		}
+
+		for _, tc := range tests {
+			content, err := os.ReadFile(base + "." + tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(content) != tc.size {
+				t.Errorf("%d Hz: %s is %d bytes, want %d", rate, tc.format, len(content), tc.size)
+				continue
+			}
+
+			codec, err := NewCodec(tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := codec.Decode(nil, content)
+
+			if gain := AmplitudeToDb(toneAmplitude(decoded, 1000, tc.rate, tc.rate/10) / 10000); math.Abs(gain) > 0.5 {
+				t.Errorf("%d Hz: %s holds the tone at %.2f dB, want 0 ± 0.5", rate, tc.format, gain)
+			}
+		}
+
+		// the wav file holds the 8 kHz 16-bit samples behind a canonical header.
+		if samples := len(recordedData(t, base+".wav")) / audioBytesPerSample; samples != 8000 {
+			t.Errorf("%d Hz: wav holds %d samples, want 8000", rate, samples)
+		}
+	}
+}
+
+func TestGenerateAudioFormatsSelection(t *testing.T) {
+	dir := t.TempDir()
+	sample := Frame(sine(800, 440, 8000, 8000)).Bytes(nil)
+
+	if _, err := GenerateAudioFormats(sample, 0, dir, "menu", "ulaw", "g722"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "menu.g722" || names[1] != "menu.ulaw" {
+		t.Fatalf("files %v, want menu.g722 and menu.ulaw", names)
+	}
+
+	if _, err := GenerateAudioFormats(sample, 0, dir, "menu", "gsm"); err == nil {
+		t.Fatal("unsupported format accepted")
+	}
+}
@@ -0,0 +1,114 @@
+// Package goEagi of frame.go provides a Frame type, the typed form of
+// the little-endian 16-bit mono audio passed around as bytes, together with
+// allocation-free conversions and the level measurements built on it.
+
+package goEagi
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// fullScale is the magnitude of a full scale 16-bit sample, the reference of dBFS.
+const fullScale = 1 << (audioBitsPerSample - 1)
+
+// Frame is a block of 16-bit signed mono samples.
+type Frame []int16
+
+// DecodeFrame decodes little-endian 16-bit PCM into dst, reusing its capacity.
+// A trailing odd byte is ignored.
+func DecodeFrame(dst Frame, b []byte) Frame {
+	n := len(b) / audioBytesPerSample
+	if cap(dst) < n {
+		dst = make(Frame, n)
+	}
+	dst = dst[:n]
+
+	for i := range dst {
+		dst[i] = sampleAt(b, i)
+	}
+	return dst
+}
+
+// Bytes encodes the frame as little-endian 16-bit PCM into dst, reusing its capacity.
+func (f Frame) Bytes(dst []byte) []byte {
+	n := len(f) * audioBytesPerSample
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:n]
+
+	for i, s := range f {
+		binary.LittleEndian.PutUint16(dst[i*audioBytesPerSample:], uint16(s))
+	}
+	return dst
+}
+
+// Duration returns the duration of the frame at 8 kHz.
+func (f Frame) Duration() time.Duration {
+	return samplesDuration(int64(len(f)))
+}
+
+// RMS returns the root mean square of the frame relative to full scale, between 0 and 1.
+func (f Frame) RMS() float64 {
+	if len(f) == 0 {
+		return 0
+	}
+
+	var sumSquares float64
+	for _, s := range f {
+		sumSquares += float64(s) * float64(s)
+	}
+	return math.Sqrt(sumSquares/float64(len(f))) / fullScale
+}
+
+// Peak returns the largest sample magnitude of the frame relative to full scale, between 0 and 1.
+func (f Frame) Peak() float64 {
+	var peak int
+	for _, s := range f {
+		v := int(s)
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+	}
+	return float64(peak) / fullScale
+}
+
+// Level returns the RMS level of the frame in dBFS, -Inf for silence.
+func (f Frame) Level() float64 {
+	return AmplitudeToDb(f.RMS())
+}
+
+// PeakLevel returns the peak level of the frame in dBFS, -Inf for silence.
+func (f Frame) PeakLevel() float64 {
+	return AmplitudeToDb(f.Peak())
+}
+
+// AmplitudeToDb converts an amplitude relative to full scale to dBFS.
+func AmplitudeToDb(amplitude float64) float64 {
+	return 20 * math.Log10(amplitude)
+}
+
+// bytesRMS is Frame.RMS computed directly on little-endian 16-bit PCM.
+func bytesRMS(b []byte) float64 {
+	n := len(b) / audioBytesPerSample
+	if n == 0 {
+		return 0
+	}
+
+	var sumSquares float64
+	for i := 0; i < n; i++ {
+		s := float64(sampleAt(b, i))
+		sumSquares += s * s
+	}
+	return math.Sqrt(sumSquares/float64(n)) / fullScale
+}
+
+// sampleAt returns sample i of little-endian 16-bit PCM.
+func sampleAt(b []byte, i int) int16 {
+	return int16(binary.LittleEndian.Uint16(b[i*audioBytesPerSample:]))
+}
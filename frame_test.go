@@ -0,0 +1,119 @@
+package goEagi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// legacyComputeAmplitude is ComputeAmplitude as it was before Frame, reading every sample through binary.Read.
+func legacyComputeAmplitude(sample []byte) (float64, error) {
+	var samples []float64
+	for i := 0; i < len(sample); i += audioBytesPerSample {
+		var payload int16
+		if err := binary.Read(bytes.NewReader(sample[i:i+audioBytesPerSample]), binary.LittleEndian, &payload); err != nil {
+			return 0, err
+		}
+		samples = append(samples, float64(payload)/float64(math.MaxInt16))
+	}
+
+	var sumSquare float64
+	for _, s := range samples {
+		sumSquare += s * s
+	}
+	rms := math.Sqrt(sumSquare / float64(len(samples)/audioBytesPerSample))
+
+	return 20*math.Log10(rms/32768) + 90, nil
+}
+
+func randomFrame(n int, amplitude float64) []byte {
+	r := rand.New(rand.NewSource(int64(n)))
+	f := make(Frame, n)
+	for i := range f {
+		f[i] = int16(amplitude * (2*r.Float64() - 1))
+	}
+	return f.Bytes(nil)
+}
+
+func TestComputeAmplitudeKeepsItsUnit(t *testing.T) {
+	for _, amplitude := range []float64{10, 300, 3000, 32767} {
+		frame := randomFrame(512, amplitude)
+
+		want, err := legacyComputeAmplitude(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ComputeAmplitude(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("amplitude %v: ComputeAmplitude = %v, want %v as before", amplitude, got, want)
+		}
+
+		if level := ComputeLevel(frame); math.Abs(got-level-amplitudeOffset) > 1e-9 || math.Abs(level-DecodeFrame(nil, frame).Level()) > 1e-9 {
+			t.Errorf("amplitude %v: ComputeLevel = %v, Frame.Level = %v, ComputeAmplitude = %v", amplitude, level, DecodeFrame(nil, frame).Level(), got)
+		}
+	}
+
+	if got, _ := ComputeAmplitude(make([]byte, 320)); !math.IsInf(got, -1) {
+		t.Errorf("ComputeAmplitude of silence = %v, want -Inf", got)
+	}
+}
+
+func TestFrameLevels(t *testing.T) {
+	full := Frame{fullScale - 1, -fullScale, fullScale - 1, -fullScale}
+	if level := full.Level(); math.Abs(level) > 0.001 {
+		t.Errorf("full scale square wave level = %v dBFS, want 0", level)
+	}
+
+	sine := make(Frame, 8000)
+	for i := range sine {
+		sine[i] = int16(fullScale / 2 * math.Sin(2*math.Pi*1000*float64(i)/audioSampleRate))
+	}
+	if level := sine.Level(); math.Abs(level-(-9.03)) > 0.01 {
+		t.Errorf("half scale sine level = %v dBFS, want -9.03", level)
+	}
+	if peak := sine.PeakLevel(); math.Abs(peak-(-6.02)) > 0.01 {
+		t.Errorf("half scale sine peak level = %v dBFS, want -6.02", peak)
+	}
+}
+
+func TestDecodeFrameRoundTrip(t *testing.T) {
+	b := randomFrame(160, 32767)
+	f := DecodeFrame(make(Frame, 0, 160), b)
+	if len(f) != 160 || !bytes.Equal(f.Bytes(nil), b) {
+		t.Fatal("frame does not encode back to its bytes")
+	}
+	if got := DecodeFrame(nil, b[:3]); len(got) != 1 {
+		t.Fatalf("odd trailing byte decoded into %d samples", len(got))
+	}
+}
+
+func BenchmarkLegacyComputeAmplitude(b *testing.B) {
+	frame := randomFrame(512, 3000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyComputeAmplitude(frame)
+	}
+}
+
+func BenchmarkComputeAmplitude(b *testing.B) {
+	frame := randomFrame(512, 3000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ComputeAmplitude(frame)
+	}
+}
+
+func BenchmarkDecodeFrameLevel(b *testing.B) {
+	frame := randomFrame(512, 3000)
+	f := make(Frame, 0, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f = DecodeFrame(f, frame)
+		f.Level()
+	}
+}
@@ -0,0 +1,203 @@
+// Package goEagi of g711.go provides table driven
+// G.711 μ-law and A-law companding of 16-bit linear audio.
+
+package goEagi
+
+const (
+	ulawBias = 0x84
+	ulawClip = 8159
+)
+
+var (
+	ulawDecodeTable [256]int16
+	alawDecodeTable [256]int16
+
+	// the encode tables are indexed by the 14 (μ-law) and 13 (A-law) most significant bits of a sample.
+	ulawEncodeTable [1 << 14]byte
+	alawEncodeTable [1 << 13]byte
+
+	ulawSegmentEnd = [8]int{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+	alawSegmentEnd = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+)
+
+func init() {
+	for i := range ulawDecodeTable {
+		ulawDecodeTable[i] = ulawToLinear(byte(i))
+		alawDecodeTable[i] = alawToLinear(byte(i))
+	}
+
+	for i := range ulawEncodeTable {
+		ulawEncodeTable[i] = linearToUlaw(int16(i<<2) >> 2)
+	}
+	for i := range alawEncodeTable {
+		alawEncodeTable[i] = linearToAlaw(int16(i<<3) >> 3)
+	}
+}
+
+// ULawCodec is the G.711 μ-law codec, it is stateless and safe for concurrent use.
+type ULawCodec struct{}
+
+// Name returns the Asterisk format name of the codec.
+func (ULawCodec) Name() string {
+	return "ulaw"
+}
+
+// SampleRate returns the sample rate of the codec.
+func (ULawCodec) SampleRate() int {
+	return audioSampleRate
+}
+
+// Encode compands samples into dst[:0], one byte per sample.
+func (ULawCodec) Encode(dst []byte, samples []int16) []byte {
+	dst = dst[:0]
+	for _, s := range samples {
+		dst = append(dst, EncodeULaw(s))
+	}
+	return dst
+}
+
+// Decode expands payload into dst[:0], one sample per byte.
+func (ULawCodec) Decode(dst []int16, payload []byte) []int16 {
+	dst = dst[:0]
+	for _, b := range payload {
+		dst = append(dst, ulawDecodeTable[b])
+	}
+	return dst
+}
+
+// ALawCodec is the G.711 A-law codec, it is stateless and safe for concurrent use.
+type ALawCodec struct{}
+
+// Name returns the Asterisk format name of the codec.
+func (ALawCodec) Name() string {
+	return "alaw"
+}
+
+// SampleRate returns the sample rate of the codec.
+func (ALawCodec) SampleRate() int {
+	return audioSampleRate
+}
+
+// Encode compands samples into dst[:0], one byte per sample.
+func (ALawCodec) Encode(dst []byte, samples []int16) []byte {
+	dst = dst[:0]
+	for _, s := range samples {
+		dst = append(dst, EncodeALaw(s))
+	}
+	return dst
+}
+
+// Decode expands payload into dst[:0], one sample per byte.
+func (ALawCodec) Decode(dst []int16, payload []byte) []int16 {
+	dst = dst[:0]
+	for _, b := range payload {
+		dst = append(dst, alawDecodeTable[b])
+	}
+	return dst
+}
+
+// EncodeULaw compands a single 16-bit sample to μ-law.
+func EncodeULaw(sample int16) byte {
+	return ulawEncodeTable[uint16(sample)>>2]
+}
+
+// DecodeULaw expands a single μ-law byte to a 16-bit sample.
+func DecodeULaw(b byte) int16 {
+	return ulawDecodeTable[b]
+}
+
+// EncodeALaw compands a single 16-bit sample to A-law.
+func EncodeALaw(sample int16) byte {
+	return alawEncodeTable[uint16(sample)>>3]
+}
+
+// DecodeALaw expands a single A-law byte to a 16-bit sample.
+func DecodeALaw(b byte) int16 {
+	return alawDecodeTable[b]
+}
+
+// linearToUlaw is the reference μ-law encoder of a 14-bit sample, used to build ulawEncodeTable.
+func linearToUlaw(pcm int16) byte {
+	v := int(pcm)
+	mask := 0xFF
+	if v < 0 {
+		v = -v
+		mask = 0x7F
+	}
+	if v > ulawClip {
+		v = ulawClip
+	}
+	v += ulawBias >> 2
+
+	segment := searchSegment(v, ulawSegmentEnd)
+	if segment >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	return byte(((segment << 4) | ((v >> (segment + 1)) & 0x0F)) ^ mask)
+}
+
+// ulawToLinear is the reference μ-law decoder, used to build ulawDecodeTable.
+func ulawToLinear(u byte) int16 {
+	v := int(^u)
+	t := ((v & 0x0F) << 3) + ulawBias
+	t <<= (v & 0x70) >> 4
+
+	if v&0x80 != 0 {
+		return int16(ulawBias - t)
+	}
+	return int16(t - ulawBias)
+}
+
+// linearToAlaw is the reference A-law encoder of a 13-bit sample, used to build alawEncodeTable.
+func linearToAlaw(pcm int16) byte {
+	v := int(pcm)
+	mask := 0xD5
+	if v < 0 {
+		mask = 0x55
+		v = -v - 1
+	}
+
+	segment := searchSegment(v, alawSegmentEnd)
+	if segment >= 8 {
+		return byte(0x7F ^ mask)
+	}
+
+	a := segment << 4
+	if segment < 2 {
+		a |= (v >> 1) & 0x0F
+	} else {
+		a |= (v >> segment) & 0x0F
+	}
+	return byte(a ^ mask)
+}
+
+// alawToLinear is the reference A-law decoder, used to build alawDecodeTable.
+func alawToLinear(a byte) int16 {
+	v := int(a ^ 0x55)
+	t := (v & 0x0F) << 4
+	segment := (v & 0x70) >> 4
+
+	switch segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= segment - 1
+	}
+
+	if v&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
+
+func searchSegment(v int, segmentEnd [8]int) int {
+	for i, end := range segmentEnd {
+		if v <= end {
+			return i
+		}
+	}
+	return len(segmentEnd)
+}
@@ -0,0 +1,142 @@
+package goEagi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Reference values of the ITU-T G.711 tables, as produced by the Sun g711.c reference implementation.
+func TestG711ReferenceValues(t *testing.T) {
+	ulawDecode := []struct {
+		code byte
+		want int16
+	}{
+		{0x00, -32124}, {0x0F, -16764}, {0x10, -15996}, {0x7E, -8}, {0x7F, 0},
+		{0x80, 32124}, {0x8F, 16764}, {0xF0, 120}, {0xFE, 8}, {0xFF, 0},
+	}
+	for _, tc := range ulawDecode {
+		if got := DecodeULaw(tc.code); got != tc.want {
+			t.Errorf("DecodeULaw(%#02x) = %d, want %d", tc.code, got, tc.want)
+		}
+	}
+
+	alawDecode := []struct {
+		code byte
+		want int16
+	}{
+		{0x55, -8}, {0xD5, 8}, {0x2A, -32256}, {0xAA, 32256},
+		{0x54, -24}, {0xD4, 24}, {0x45, -264}, {0xC5, 264},
+	}
+	for _, tc := range alawDecode {
+		if got := DecodeALaw(tc.code); got != tc.want {
+			t.Errorf("DecodeALaw(%#02x) = %d, want %d", tc.code, got, tc.want)
+		}
+	}
+
+	encode := []struct {
+		sample     int16
+		ulaw, alaw byte
+	}{
+		{0, 0xFF, 0xD5},
+		{-1, 0x7E, 0x55},
+		{8, 0xFE, 0xD5},
+		{32767, 0x80, 0xAA},
+		{-32768, 0x00, 0x2A},
+		{1000, 0xCE, 0xFA},
+		{-1000, 0x4E, 0x7A},
+	}
+	for _, tc := range encode {
+		if got := EncodeULaw(tc.sample); got != tc.ulaw {
+			t.Errorf("EncodeULaw(%d) = %#02x, want %#02x", tc.sample, got, tc.ulaw)
+		}
+		if got := EncodeALaw(tc.sample); got != tc.alaw {
+			t.Errorf("EncodeALaw(%d) = %#02x, want %#02x", tc.sample, got, tc.alaw)
+		}
+	}
+}
+
+func TestG711RoundTripAllCodes(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		code := byte(i)
+
+		// 0x7F is the negative zero of μ-law, which encodes back to the positive one.
+		want := code
+		if code == 0x7F {
+			want = 0xFF
+		}
+		if got := EncodeULaw(DecodeULaw(code)); got != want {
+			t.Errorf("μ-law %#02x decodes to %d which encodes to %#02x", code, DecodeULaw(code), got)
+		}
+
+		if got := EncodeALaw(DecodeALaw(code)); got != code {
+			t.Errorf("A-law %#02x decodes to %d which encodes to %#02x", code, DecodeALaw(code), got)
+		}
+	}
+}
+
+func TestG711EncodeMonotonic(t *testing.T) {
+	prevU, prevA := DecodeULaw(EncodeULaw(-32768)), DecodeALaw(EncodeALaw(-32768))
+	for s := -32767; s <= 32767; s++ {
+		u, a := DecodeULaw(EncodeULaw(int16(s))), DecodeALaw(EncodeALaw(int16(s)))
+		if u < prevU || a < prevA {
+			t.Fatalf("companding of %d is not monotonic: μ-law %d after %d, A-law %d after %d", s, u, prevU, a, prevA)
+		}
+		prevU, prevA = u, a
+	}
+}
+
+func TestG711CodecsMatchSampleFunctions(t *testing.T) {
+	samples := []int16{0, 1, -1, 100, -100, 12345, -12345, 32767, -32768}
+
+	ulaw := ULawCodec{}.Encode(nil, samples)
+	alaw := ALawCodec{}.Encode(nil, samples)
+	for i, s := range samples {
+		if ulaw[i] != EncodeULaw(s) || alaw[i] != EncodeALaw(s) {
+			t.Fatalf("codec encoding of %d differs from the sample functions", s)
+		}
+	}
+
+	decoded := ULawCodec{}.Decode(nil, ulaw)
+	for i, b := range ulaw {
+		if decoded[i] != DecodeULaw(b) {
+			t.Fatalf("μ-law codec decoding of %#02x differs from DecodeULaw", b)
+		}
+	}
+	decoded = ALawCodec{}.Decode(nil, alaw)
+	for i, b := range alaw {
+		if decoded[i] != DecodeALaw(b) {
+			t.Fatalf("A-law codec decoding of %#02x differs from DecodeALaw", b)
+		}
+	}
+}
+
+// The digests are of the full encoding of every 16-bit sample and the decoding of every code,
+// as produced by the Sun reference implementation (CPython's audioop module).
+func TestG711MatchesReferenceImplementation(t *testing.T) {
+	var samples []int16
+	for s := -32768; s <= 32767; s++ {
+		samples = append(samples, int16(s))
+	}
+	codes := make([]byte, 256)
+	for i := range codes {
+		codes[i] = byte(i)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    string
+	}{
+		{"μ-law encoding", ULawCodec{}.Encode(nil, samples), "81d633c9e6972a18c74a58720b96cb8ca0bdd096d4060b646dd708c3b846019a"},
+		{"A-law encoding", ALawCodec{}.Encode(nil, samples), "38488f6fd710f4686360edc4d38639f96c491595ef93f8eb8d62d5e07ca6ce7b"},
+		{"μ-law decoding", Frame(ULawCodec{}.Decode(nil, codes)).Bytes(nil), "3dab54339e520bb2c924826e3b72a917a2b612e9fd12fc867500f1d983a75827"},
+		{"A-law decoding", Frame(ALawCodec{}.Decode(nil, codes)).Bytes(nil), "e04788d110e58ff8c70c93b8480190d973e3b67876b6119abbaec766cc75c174"},
+	}
+	for _, tc := range tests {
+		sum := sha256.Sum256(tc.content)
+		if got := hex.EncodeToString(sum[:]); got != tc.want {
+			t.Errorf("%s digest = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+// Package goEagi of g722.go provides a G.722 (64 kbit/s) encoder and decoder,
+// a sub-band ADPCM codec for 16 kHz wideband audio which Asterisk supports natively.
+// The implementation follows the ITU-T G.722 reference algorithm.
+
+package goEagi
+
+const (
+	g722SampleRate = 16000
+)
+
+var (
+	g722Q6 = [32]int{
+		0, 35, 72, 110, 150, 190, 233, 276, 323, 370, 422, 473, 530, 587, 650, 714,
+		786, 858, 940, 1023, 1121, 1219, 1339, 1458, 1612, 1765, 1980, 2195, 2557, 2919, 0, 0,
+	}
+	g722ILN = [32]int{
+		0, 63, 62, 31, 30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19,
+		18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 0,
+	}
+	g722ILP = [32]int{
+		0, 61, 60, 59, 58, 57, 56, 55, 54, 53, 52, 51, 50, 49, 48, 47,
+		46, 45, 44, 43, 42, 41, 40, 39, 38, 37, 36, 35, 34, 33, 32, 0,
+	}
+	g722WL   = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL42 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB  = [32]int{
+		2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383, 2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834,
+		2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371, 3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008,
+	}
+	g722QM4 = [16]int{
+		0, -20456, -12896, -8968, -6288, -4240, -2584, -1200,
+		20456, 12896, 8968, 6288, 4240, 2584, 1200, 0,
+	}
+	g722QM6 = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136, -432, -136,
+	}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722IHN = [3]int{0, 1, 0}
+	g722IHP = [3]int{0, 3, 2}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+
+	g722QMFCoefficients = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+)
+
+// G722Codec encodes 16 kHz audio to 64 kbit/s G.722 and back.
+// The encoder and the decoder keep their own adaptive state, so a G722Codec
+// must be used for a single stream per direction and is not safe for concurrent use.
+type G722Codec struct {
+	encoder g722State
+	decoder g722State
+}
+
+// NewG722Codec creates a G722Codec with initialized encoder and decoder states.
+func NewG722Codec() *G722Codec {
+	c := G722Codec{}
+	c.encoder.reset()
+	c.decoder.reset()
+	return &c
+}
+
+// Name returns the Asterisk format name of the codec.
+func (c *G722Codec) Name() string {
+	return "g722"
+}
+
+// SampleRate returns the sample rate of the codec.
+func (c *G722Codec) SampleRate() int {
+	return g722SampleRate
+}
+
+// Encode encodes 16 kHz samples into dst[:0], one byte per two samples.
+// A trailing odd sample is held back until the next call.
+func (c *G722Codec) Encode(dst []byte, samples []int16) []byte {
+	dst = dst[:0]
+	s := &c.encoder
+
+	for _, sample := range samples {
+		if !s.pending {
+			s.held = int(sample)
+			s.pending = true
+			continue
+		}
+		s.pending = false
+		dst = append(dst, s.encodePair(s.held, int(sample)))
+	}
+
+	return dst
+}
+
+// Decode decodes payload into dst[:0], two 16 kHz samples per byte.
+func (c *G722Codec) Decode(dst []int16, payload []byte) []int16 {
+	dst = dst[:0]
+	s := &c.decoder
+
+	for _, code := range payload {
+		first, second := s.decodeByte(code)
+		dst = append(dst, first, second)
+	}
+
+	return dst
+}
+
+// g722Band is the adaptive predictor state of a sub-band.
+type g722Band struct {
+	s   int
+	sp  int
+	sz  int
+	r   [3]int
+	a   [3]int
+	ap  [3]int
+	p   [3]int
+	d   [7]int
+	b   [7]int
+	bp  [7]int
+	sg  [7]int
+	nb  int
+	det int
+}
+
+// g722State is the state of one direction of a G.722 stream.
+type g722State struct {
+	x    [24]int
+	band [2]g722Band
+
+	held    int
+	pending bool
+}
+
+func (s *g722State) reset() {
+	*s = g722State{}
+	s.band[0].det = 32
+	s.band[1].det = 8
+}
+
+// encodePair runs the transmit QMF on two input samples and quantizes both sub-bands into one byte.
+func (s *g722State) encodePair(first, second int) byte {
+	copy(s.x[:22], s.x[2:])
+	s.x[22] = first
+	s.x[23] = second
+
+	sumEven, sumOdd := 0, 0
+	for i := 0; i < 12; i++ {
+		sumOdd += s.x[2*i] * g722QMFCoefficients[i]
+		sumEven += s.x[2*i+1] * g722QMFCoefficients[11-i]
+	}
+	xLow := (sumEven + sumOdd) >> 14
+	xHigh := (sumEven - sumOdd) >> 14
+
+	// low band: SUBTRA, QUANTL, INVQAL, LOGSCL, SCALEL
+	low := &s.band[0]
+	el := saturate16(xLow - low.s)
+	wd := el
+	if el < 0 {
+		wd = -(el + 1)
+	}
+
+	i := 1
+	for ; i < 30; i++ {
+		if wd < (g722Q6[i]*low.det)>>12 {
+			break
+		}
+	}
+	iLow := g722ILP[i]
+	if el < 0 {
+		iLow = g722ILN[i]
+	}
+
+	ril := iLow >> 2
+	dLow := (low.det * g722QM4[ril]) >> 15
+	low.scaleLow(g722RL42[ril])
+	low.block4(dLow)
+
+	// high band: SUBTRA, QUANTH, INVQAH, LOGSCH, SCALEH
+	high := &s.band[1]
+	eh := saturate16(xHigh - high.s)
+	wd = eh
+	if eh < 0 {
+		wd = -(eh + 1)
+	}
+
+	mih := 1
+	if wd >= (564*high.det)>>12 {
+		mih = 2
+	}
+	iHigh := g722IHP[mih]
+	if eh < 0 {
+		iHigh = g722IHN[mih]
+	}
+
+	dHigh := (high.det * g722QM2[iHigh]) >> 15
+	high.scaleHigh(g722RH2[iHigh])
+	high.block4(dHigh)
+
+	return byte((iHigh << 6) | iLow)
+}
+
+// decodeByte reconstructs both sub-bands of a code and runs the receive QMF to produce two samples.
+func (s *g722State) decodeByte(code byte) (int16, int16) {
+	wd1 := int(code) & 0x3F
+	iHigh := (int(code) >> 6) & 0x03
+
+	// low band: INVQBL, RECONS, LIMIT, INVQAL, LOGSCL, SCALEL
+	low := &s.band[0]
+	rLow := low.s + (low.det*g722QM6[wd1])>>15
+	rLow = limit(rLow, -16384, 16383)
+
+	ril := wd1 >> 2
+	dLow := (low.det * g722QM4[ril]) >> 15
+	low.scaleLow(g722RL42[ril])
+	low.block4(dLow)
+
+	// high band: INVQAH, RECONS, LIMIT, LOGSCH, SCALEH
+	high := &s.band[1]
+	dHigh := (high.det * g722QM2[iHigh]) >> 15
+	rHigh := limit(dHigh+high.s, -16384, 16383)
+	high.scaleHigh(g722RH2[iHigh])
+	high.block4(dHigh)
+
+	copy(s.x[:22], s.x[2:])
+	s.x[22] = rLow + rHigh
+	s.x[23] = rLow - rHigh
+
+	out1, out2 := 0, 0
+	for i := 0; i < 12; i++ {
+		out2 += s.x[2*i] * g722QMFCoefficients[i]
+		out1 += s.x[2*i+1] * g722QMFCoefficients[11-i]
+	}
+
+	return int16(saturate16(out1 >> 11)), int16(saturate16(out2 >> 11))
+}
+
+// scaleLow is the LOGSCL and SCALEL blocks of the low band.
+func (b *g722Band) scaleLow(il4 int) {
+	b.nb = limit((b.nb*127)>>7+g722WL[il4], 0, 18432)
+	b.det = b.scaleFactor(8)
+}
+
+// scaleHigh is the LOGSCH and SCALEH blocks of the high band.
+func (b *g722Band) scaleHigh(ih2 int) {
+	b.nb = limit((b.nb*127)>>7+g722WH[ih2], 0, 22528)
+	b.det = b.scaleFactor(10)
+}
+
+func (b *g722Band) scaleFactor(shift int) int {
+	wd1 := (b.nb >> 6) & 31
+	wd2 := shift - (b.nb >> 11)
+
+	var wd3 int
+	if wd2 < 0 {
+		wd3 = g722ILB[wd1] << -wd2
+	} else {
+		wd3 = g722ILB[wd1] >> wd2
+	}
+	return wd3 << 2
+}
+
+// block4 updates the pole and zero predictors of a band with the quantized difference d.
+func (b *g722Band) block4(d int) {
+	// RECONS, PARREC
+	b.d[0] = d
+	b.r[0] = saturate16(b.s + d)
+	b.p[0] = saturate16(b.sz + d)
+
+	// UPPOL2
+	for i := 0; i < 3; i++ {
+		b.sg[i] = b.p[i] >> 15
+	}
+	wd1 := saturate16(b.a[1] << 2)
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	if wd2 > 32767 {
+		wd2 = 32767
+	}
+	wd3 := wd2 >> 7
+	if b.sg[0] == b.sg[2] {
+		wd3 += 128
+	} else {
+		wd3 -= 128
+	}
+	wd3 += (b.a[2] * 32512) >> 15
+	b.ap[2] = limit(wd3, -12288, 12288)
+
+	// UPPOL1
+	b.sg[0] = b.p[0] >> 15
+	b.sg[1] = b.p[1] >> 15
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	wd2 = (b.a[1] * 32640) >> 15
+	b.ap[1] = saturate16(wd1 + wd2)
+	wd3 = saturate16(15360 - b.ap[2])
+	b.ap[1] = limit(b.ap[1], -wd3, wd3)
+
+	// UPZERO
+	wd1 = 128
+	if d == 0 {
+		wd1 = 0
+	}
+	b.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+		wd2 = -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+		wd3 = (b.b[i] * 32640) >> 15
+		b.bp[i] = saturate16(wd2 + wd3)
+	}
+
+	// DELAYA
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// FILTEP
+	wd1 = saturate16(b.r[1] + b.r[1])
+	wd1 = (b.a[1] * wd1) >> 15
+	wd2 = saturate16(b.r[2] + b.r[2])
+	wd2 = (b.a[2] * wd2) >> 15
+	b.sp = saturate16(wd1 + wd2)
+
+	// FILTEZ
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		wd1 = saturate16(b.d[i] + b.d[i])
+		b.sz += (b.b[i] * wd1) >> 15
+	}
+	b.sz = saturate16(b.sz)
+
+	// PREDIC
+	b.s = saturate16(b.sp + b.sz)
+}
+
+func saturate16(v int) int {
+	return limit(v, -32768, 32767)
+}
+
+func limit(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
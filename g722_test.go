@@ -0,0 +1,171 @@
+package goEagi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+// g722Tone returns n samples of a sine of frequency at 16 kHz.
+func g722Tone(n int, frequency, amplitude float64) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(2*math.Pi*frequency*float64(i)/g722SampleRate))
+	}
+	return samples
+}
+
+// g722Delay is the delay in samples of the encoder and decoder QMF filters of the reference algorithm.
+const g722Delay = 22
+
+// g722Chirp returns n samples of a sine sweeping from 100 Hz to 7 kHz at 16 kHz.
+func g722Chirp(n int, amplitude float64) []int16 {
+	samples := make([]int16, n)
+	duration := float64(n) / g722SampleRate
+	for i := range samples {
+		t := float64(i) / g722SampleRate
+		phase := 2 * math.Pi * (100*t + (7000-100)*t*t/(2*duration))
+		samples[i] = int16(amplitude * math.Sin(phase))
+	}
+	return samples
+}
+
+// g722RoundTripSNR returns the signal to noise ratio in dB of decoded against input delayed by the codec.
+func g722RoundTripSNR(input, decoded []int16) float64 {
+	var signal, noise float64
+	// the adaptive predictors settle during the first samples.
+	for i := 1000; i+g722Delay < len(decoded); i++ {
+		s := float64(input[i])
+		d := float64(decoded[i+g722Delay]) - s
+		signal += s * s
+		noise += d * d
+	}
+	return 10 * math.Log10(signal/noise)
+}
+
+func TestG722RoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  []int16
+		minSNR float64
+	}{
+		{"300 Hz", g722Tone(16000, 300, 8000), 50},
+		{"1 kHz", g722Tone(16000, 1000, 8000), 40},
+		{"3 kHz", g722Tone(16000, 3000, 8000), 35},
+		{"6 kHz", g722Tone(16000, 6000, 8000), 20},
+		{"chirp", g722Chirp(32000, 8000), 20},
+	}
+
+	for _, tc := range tests {
+		c := NewG722Codec()
+		payload := c.Encode(nil, tc.input)
+		if len(payload) != len(tc.input)/2 {
+			t.Fatalf("%s: %d bytes encoded from %d samples", tc.name, len(payload), len(tc.input))
+		}
+		decoded := c.Decode(nil, payload)
+
+		if snr := g722RoundTripSNR(tc.input, decoded); snr < tc.minSNR {
+			t.Errorf("%s: round trip SNR %.1f dB, want at least %v dB", tc.name, snr, tc.minSNR)
+		}
+	}
+}
+
+func TestG722SilenceStaysSilent(t *testing.T) {
+	c := NewG722Codec()
+	decoded := c.Decode(nil, c.Encode(nil, make([]int16, 1600)))
+
+	for i, s := range decoded {
+		if s > 8 || s < -8 {
+			t.Fatalf("sample %d of decoded silence is %d", i, s)
+		}
+	}
+}
+
+func TestG722EncodeInChunks(t *testing.T) {
+	input := g722Tone(3201, 440, 12000)
+
+	whole := NewG722Codec().Encode(nil, input)
+
+	c := NewG722Codec()
+	var chunked []byte
+	// odd chunk sizes hold a sample back until the next call.
+	for start := 0; start < len(input); start += 161 {
+		end := start + 161
+		if end > len(input) {
+			end = len(input)
+		}
+		chunked = append(chunked, c.Encode(nil, input[start:end])...)
+	}
+
+	if len(whole) != 1600 || string(whole) != string(chunked) {
+		t.Fatalf("encoding in chunks differs: %d bytes whole, %d bytes chunked", len(whole), len(chunked))
+	}
+}
+
+// g722VectorInput returns n samples of a triangle wave sweeping up in frequency with white noise added.
+// It uses integer arithmetic only, so the vectors do not depend on the floating point of the platform.
+func g722VectorInput(n int) []int16 {
+	seed := uint32(1)
+	var phase, step uint32 = 0, 1 << 20
+
+	samples := make([]int16, n)
+	for i := range samples {
+		phase += step
+		step += 1 << 11
+		triangle := int32(phase>>16) - 32768
+		if triangle < 0 {
+			triangle = -triangle
+		}
+		seed = seed*1664525 + 1013904223
+		samples[i] = int16((triangle-16384)/2 + int32(seed>>20) - 2048)
+	}
+	return samples
+}
+
+// g722VectorPayload returns n pseudo random G.722 codes, exercising every quantizer level of both bands.
+func g722VectorPayload(n int) []byte {
+	seed := uint32(2)
+	payload := make([]byte, n)
+	for i := range payload {
+		seed = seed*1664525 + 1013904223
+		payload[i] = byte(seed >> 24)
+	}
+	return payload
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// The vectors were generated with the G.722 codec of spandsp at 64 kbit/s. spandsp wraps the decoder
+// output around on overflow where this codec saturates it, so the expected output of the random payload,
+// the only vector overflowing, was generated with spandsp saturating as well.
+func TestG722ReferenceVectors(t *testing.T) {
+	input := g722VectorInput(16000)
+
+	encoded := NewG722Codec().Encode(nil, input)
+	if got, want := hex.EncodeToString(encoded[:32]), "962484208520a0a426abac2cacab2eb073b2efb33034b0f3b131b5373775b436"; got != want {
+		t.Fatalf("first encoded bytes = %s, want %s", got, want)
+	}
+	if got, want := sha256Hex(encoded), "8786946933af1d8dcd5a8190a49468986bef4a753475fb2c3358dca95af88e91"; got != want {
+		t.Fatalf("sha256 of encoded vector = %s, want %s", got, want)
+	}
+
+	decoded := NewG722Codec().Decode(nil, encoded)
+	want := []int16{-7295, -6784, -7429, -5817, -8916, -7769, -7960, -6478, -9109, -7071, -6330, -6757, -6281, -5709, -8301, -7272}
+	for i, s := range want {
+		if decoded[1000+i] != s {
+			t.Fatalf("decoded sample %d = %d, want %d", 1000+i, decoded[1000+i], s)
+		}
+	}
+	if got, want := sha256Hex(Frame(decoded).Bytes(nil)), "7e60f0d865e0c1253c4ebab82ec3d75b9cdd206a7e615b558c00e1589f360fbf"; got != want {
+		t.Fatalf("sha256 of decoded vector = %s, want %s", got, want)
+	}
+
+	decoded = NewG722Codec().Decode(nil, g722VectorPayload(8000))
+	if got, want := sha256Hex(Frame(decoded).Bytes(nil)), "80cf1dbd7989615591058086fc1edf257cdd99f55a6a394de77ac39aaca06032"; got != want {
+		t.Fatalf("sha256 of decoded random payload = %s, want %s", got, want)
+	}
+}
@@ -6,7 +6,6 @@ require (
 	cloud.google.com/go/speech v1.19.1
 	cloud.google.com/go/texttospeech v1.7.4
 	github.com/Microsoft/cognitive-services-speech-sdk-go v1.33.0
-	github.com/cryptix/wav v0.0.0-20180415113528-8bdace674401
 	github.com/gorilla/websocket v1.5.0
 	github.com/zaf/agi v0.0.0-20220109201550-cdecf9a1b285
 	google.golang.org/genproto v0.0.0-20231016165738-49dd2c1f3d0b
@@ -37,6 +37,7 @@ type GoogleService struct {
 	languageCode   string
 	privateKeyPath string
 	enhancedMode   bool
+	wordTimes      bool
 	speechContext  []string
 	client         speechpb.Speech_StreamingRecognizeClient
 
@@ -48,6 +49,16 @@ type GoogleService struct {
 // a languageCode, example ["en-GB", "en-US", "ch", ...], see (https://cloud.google.com/speech-to-text/docs/languages),
 // and a speech context, see (https://cloud.google.com/speech-to-text/docs/speech-adaptation).
 func NewGoogleService(privateKeyPath string, languageCode string, speechContext []string) (*GoogleService, error) {
+	return newGoogleService(privateKeyPath, languageCode, speechContext, false)
+}
+
+// NewGoogleServiceWithWordTimes is NewGoogleService requesting the time offsets of every word,
+// which a Transcript needs to cut captions on words.
+func NewGoogleServiceWithWordTimes(privateKeyPath string, languageCode string, speechContext []string) (*GoogleService, error) {
+	return newGoogleService(privateKeyPath, languageCode, speechContext, true)
+}
+
+func newGoogleService(privateKeyPath string, languageCode string, speechContext []string, wordTimes bool) (*GoogleService, error) {
 	if len(strings.TrimSpace(privateKeyPath)) == 0 {
 		return nil, errors.New("private key path is empty")
 	}
@@ -61,6 +72,7 @@ func NewGoogleService(privateKeyPath string, languageCode string, speechContext
 		languageCode:   languageCode,
 		privateKeyPath: privateKeyPath,
 		enhancedMode:   false,
+		wordTimes:      wordTimes,
 		speechContext:  speechContext,
 	}
 
@@ -89,12 +101,13 @@ func NewGoogleService(privateKeyPath string, languageCode string, speechContext
 		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
 			StreamingConfig: &speechpb.StreamingRecognitionConfig{
 				Config: &speechpb.RecognitionConfig{
-					Encoding:        speechpb.RecognitionConfig_LINEAR16,
-					SampleRateHertz: sampleRate,
-					LanguageCode:    g.languageCode,
-					Model:           domainModel,
-					UseEnhanced:     g.enhancedMode,
-					SpeechContexts:  []*speechpb.SpeechContext{sc},
+					Encoding:              speechpb.RecognitionConfig_LINEAR16,
+					SampleRateHertz:       sampleRate,
+					LanguageCode:          g.languageCode,
+					Model:                 domainModel,
+					UseEnhanced:           g.enhancedMode,
+					SpeechContexts:        []*speechpb.SpeechContext{sc},
+					EnableWordTimeOffsets: g.wordTimes,
 				},
 				InterimResults: true,
 			},
@@ -232,6 +245,7 @@ func (g *GoogleService) ReinitializeClient() error {
 					UseEnhanced:                g.enhancedMode,
 					SpeechContexts:             []*speechpb.SpeechContext{sc},
 					EnableAutomaticPunctuation: true,
+					EnableWordTimeOffsets:      g.wordTimes,
 				},
 				InterimResults: true,
 			},
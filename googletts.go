@@ -1,13 +1,12 @@
 package goEagi
 
 import (
+	"bytes"
 	"context"
 	"fmt"
-	"hash/fnv"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 
 	texttospeech "cloud.google.com/go/texttospeech/apiv1"
 	"cloud.google.com/go/texttospeech/apiv1/texttospeechpb"
@@ -17,12 +16,31 @@ const (
 	audioExtension = ".wav"
 )
 
+// GoogleTTS synthesizes speech with Google's Text to Speech service, it implements Synthesizer.
+// The voice settings apply to every request, and are part of its CacheKey.
 type GoogleTTS struct {
 	AudioOutputDirectory string
 	LanguageCode         string
 	VoiceName            string
+
+	// SSMLGender is "MALE", "FEMALE" or "NEUTRAL", it picks the voice when VoiceName is empty.
+	SSMLGender string
+	// SpeakingRate is 0.25 to 4, 0 meaning the normal rate of 1.
+	SpeakingRate float64
+	// Pitch is -20 to 20 semitones.
+	Pitch float64
+	// VolumeGainDb is -96 to 16 dB.
+	VolumeGainDb float64
+	// EffectsProfileIDs optimize the speech for classes of devices, such as "telephony-class-application".
+	EffectsProfileIDs []string
+
+	cacheOnce sync.Once
+	cache     *TTSCache
 }
 
+// NewGoogleTTS creates a GoogleTTS, and its audio output directory if needed. It makes no request,
+// so that it works offline: use NewGoogleTTSValidated to check the voice when creating it,
+// or call Validate once the voice settings are set.
 func NewGoogleTTS(googleCred, audioOutputDir, languageCode, voiceName string) (*GoogleTTS, error) {
 	tts := GoogleTTS{
 		AudioOutputDirectory: audioOutputDir,
@@ -43,63 +61,183 @@ func NewGoogleTTS(googleCred, audioOutputDir, languageCode, voiceName string) (*
 	return &tts, nil
 }
 
-// GenerateAudio generates audio file from content.
+// NewGoogleTTSValidated is NewGoogleTTS checking with Validate that voiceName exists for languageCode.
+func NewGoogleTTSValidated(ctx context.Context, googleCred, audioOutputDir, languageCode, voiceName string) (*GoogleTTS, error) {
+	tts, err := NewGoogleTTS(googleCred, audioOutputDir, languageCode, voiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tts.Validate(ctx); err != nil {
+		return nil, err
+	}
+	return tts, nil
+}
+
+// ListVoices returns the voices supporting languageCode, or all voices when it is empty.
+func (tts *GoogleTTS) ListVoices(ctx context.Context, languageCode string) ([]*texttospeechpb.Voice, error) {
+	client, err := texttospeech.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	resp, err := client.ListVoices(ctx, &texttospeechpb.ListVoicesRequest{LanguageCode: languageCode})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list voices: %w", err)
+	}
+	return resp.Voices, nil
+}
+
+// Validate checks the voice settings, and with ListVoices that the voice exists for LanguageCode and SSMLGender.
+// It needs credentials and access to the service, and is not called by Synthesize.
+func (tts *GoogleTTS) Validate(ctx context.Context) error {
+	if tts.SpeakingRate != 0 && (tts.SpeakingRate < 0.25 || tts.SpeakingRate > 4) {
+		return fmt.Errorf("speaking rate %v is out of 0.25 to 4", tts.SpeakingRate)
+	}
+	if tts.Pitch < -20 || tts.Pitch > 20 {
+		return fmt.Errorf("pitch %v is out of -20 to 20", tts.Pitch)
+	}
+	if tts.VolumeGainDb < -96 || tts.VolumeGainDb > 16 {
+		return fmt.Errorf("volume gain %v dB is out of -96 to 16", tts.VolumeGainDb)
+	}
+
+	gender, err := tts.gender()
+	if err != nil {
+		return err
+	}
+
+	voices, err := tts.ListVoices(ctx, tts.LanguageCode)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range voices {
+		if tts.VoiceName != "" && v.Name != tts.VoiceName {
+			continue
+		}
+		if gender != texttospeechpb.SsmlVoiceGender_SSML_VOICE_GENDER_UNSPECIFIED && v.SsmlGender != gender {
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no voice %q of gender %q for language %s", tts.VoiceName, tts.SSMLGender, tts.LanguageCode)
+}
+
+// CacheKey identifies the speech of req with the voice settings of tts.
+func (tts *GoogleTTS) CacheKey(req SynthesisRequest) string {
+	if req.Language == "" {
+		req.Language = tts.LanguageCode
+	}
+	if req.Voice == "" {
+		req.Voice = tts.VoiceName
+	}
+
+	settings := fmt.Sprintf("google\x00%s\x00%v\x00%v\x00%v\x00%s",
+		strings.ToUpper(tts.SSMLGender), tts.SpeakingRate, tts.Pitch, tts.VolumeGainDb, strings.Join(tts.EffectsProfileIDs, ","))
+	req.Voice += "\x00" + settings
+
+	return req.key()
+}
+
+// GenerateAudio generates audio file from content into a TTSCache in AudioOutputDirectory,
+// unless it was generated before with the same settings.
 // It returns audio file path without extension for playback, and error if any.
 func (tts *GoogleTTS) GenerateAudio(content string) (string, error) {
-	audioName := generateHash(strings.ToLower(content))
-	audioFilepathWithoutWavExtension := filepath.Join(tts.AudioOutputDirectory, audioName)
-	audioFilepathWithWavExtension := filepath.Join(tts.AudioOutputDirectory, audioName+audioExtension)
+	tts.cacheOnce.Do(func() {
+		tts.cache = &TTSCache{Directory: tts.AudioOutputDirectory, synthesizer: tts}
+	})
 
-	if _, err := os.Stat(audioFilepathWithWavExtension); os.IsExist(err) {
-		return audioFilepathWithoutWavExtension, nil
+	return tts.cache.File(context.Background(), SynthesisRequest{Text: content}, audioExtension)
+}
+
+// Synthesize implements Synthesizer, LanguageCode and VoiceName being the defaults of req.
+func (tts *GoogleTTS) Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+	rate := req.SampleRate
+	if rate <= 0 {
+		rate = audioSampleRate
 	}
 
-	file, err := os.OpenFile(audioFilepathWithWavExtension, os.O_APPEND|os.O_WRONLY|os.O_CREATE, os.ModePerm)
+	voice := &texttospeechpb.VoiceSelectionParams{
+		LanguageCode: tts.LanguageCode,
+		Name:         tts.VoiceName,
+	}
+	if req.Language != "" {
+		voice.LanguageCode = req.Language
+	}
+	if req.Voice != "" {
+		voice.Name = req.Voice
+	}
+
+	gender, err := tts.gender()
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
+		return nil, err
+	}
+	voice.SsmlGender = gender
+
+	input := &texttospeechpb.SynthesisInput{
+		InputSource: &texttospeechpb.SynthesisInput_Text{Text: req.Text},
+	}
+	if req.SSML {
+		input.InputSource = &texttospeechpb.SynthesisInput_Ssml{Ssml: req.Text}
 	}
-	defer file.Close()
 
-	ctx := context.Background()
 	client, err := texttospeech.NewClient(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to create client: %w", err)
+		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 	defer client.Close()
 
-	req := &texttospeechpb.SynthesizeSpeechRequest{
-		Input: &texttospeechpb.SynthesisInput{
-			InputSource: &texttospeechpb.SynthesisInput_Text{
-				Text: content,
-			},
-		},
-		Voice: &texttospeechpb.VoiceSelectionParams{
-			LanguageCode: tts.LanguageCode,
-			Name:         tts.VoiceName,
-		},
+	resp, err := client.SynthesizeSpeech(ctx, &texttospeechpb.SynthesizeSpeechRequest{
+		Input: input,
+		Voice: voice,
 		AudioConfig: &texttospeechpb.AudioConfig{
-			AudioEncoding:   texttospeechpb.AudioEncoding_LINEAR16,
-			SampleRateHertz: 8000,
+			AudioEncoding:    texttospeechpb.AudioEncoding_LINEAR16,
+			SampleRateHertz:  int32(rate),
+			SpeakingRate:     tts.SpeakingRate,
+			Pitch:            tts.Pitch,
+			VolumeGainDb:     tts.VolumeGainDb,
+			EffectsProfileId: tts.EffectsProfileIDs,
 		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+
+	return decodeSynthesizedWav(resp.AudioContent, rate)
+}
+
+// decodeSynthesizedWav returns the samples of a synthesized wav file as slin at rate.
+func decodeSynthesizedWav(content []byte, rate int) ([]byte, error) {
+	reader, err := NewWavReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read synthesized audio: %w", err)
 	}
 
-	resp, err := client.SynthesizeSpeech(ctx, req)
+	samples, err := reader.ReadAll()
 	if err != nil {
-		return "", fmt.Errorf("failed to synthesize speech: %w", err)
+		return nil, fmt.Errorf("failed to read synthesized audio: %w", err)
 	}
 
-	if _, err := file.Write(resp.AudioContent); err != nil {
-		return "", fmt.Errorf("failed to write file: %w", err)
+	if inRate := reader.Info().SampleRate; inRate != rate {
+		if samples, err = ResampleSamples(samples, inRate, rate, ResampleQualityHigh); err != nil {
+			return nil, err
+		}
 	}
-	return audioFilepathWithoutWavExtension, nil
+
+	return Frame(samples).Bytes(nil), nil
 }
 
-// generateHash generates hash from input string.
-func generateHash(input string) string {
-	hasher := fnv.New32a()
-	hasher.Write([]byte(input))
-	hashValue := hasher.Sum32()
-	hashString := strconv.FormatUint(uint64(hashValue), 10)
+// gender returns the SSML gender of SSMLGender.
+func (tts *GoogleTTS) gender() (texttospeechpb.SsmlVoiceGender, error) {
+	if tts.SSMLGender == "" {
+		return texttospeechpb.SsmlVoiceGender_SSML_VOICE_GENDER_UNSPECIFIED, nil
+	}
 
-	return hashString
+	gender, ok := texttospeechpb.SsmlVoiceGender_value[strings.ToUpper(tts.SSMLGender)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported SSML gender: %s", tts.SSMLGender)
+	}
+	return texttospeechpb.SsmlVoiceGender(gender), nil
 }
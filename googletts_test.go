@@ -0,0 +1,73 @@
+package goEagi
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewGoogleTTSOffline(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	dir := filepath.Join(t.TempDir(), "tts")
+
+	tts, err := NewGoogleTTS("", dir, "en-US", "en-US-Neural2-F")
+	if err != nil {
+		t.Fatalf("NewGoogleTTS without network or credentials: %v", err)
+	}
+	if tts.LanguageCode != "en-US" || tts.VoiceName != "en-US-Neural2-F" {
+		t.Errorf("tts = %+v", tts)
+	}
+}
+
+func TestGoogleTTSValidateSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		tts  *GoogleTTS
+		want string
+	}{
+		{"speaking rate", &GoogleTTS{SpeakingRate: 5}, "speaking rate"},
+		{"pitch", &GoogleTTS{Pitch: -21}, "pitch"},
+		{"volume", &GoogleTTS{VolumeGainDb: 17}, "volume gain"},
+		{"gender", &GoogleTTS{SSMLGender: "robot"}, "unsupported SSML gender"},
+	}
+
+	for _, tc := range tests {
+		// the settings are checked before any request.
+		err := tc.tts.Validate(context.Background())
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: Validate error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestGoogleTTSCacheKey(t *testing.T) {
+	tts := GoogleTTS{LanguageCode: "en-US", VoiceName: "en-US-Neural2-F"}
+	req := SynthesisRequest{Text: "Hello"}
+	key := tts.CacheKey(req)
+
+	if len(key) != 32 || strings.Trim(key, "0123456789abcdef") != "" {
+		t.Fatalf("cache key %q is not 32 hex digits", key)
+	}
+	if explicit := tts.CacheKey(SynthesisRequest{Text: "Hello", Language: "en-US", Voice: "en-US-Neural2-F"}); explicit != key {
+		t.Error("default language and voice change the cache key")
+	}
+
+	tts.SpeakingRate = 1.2
+	if tts.CacheKey(req) == key {
+		t.Error("speaking rate does not change the cache key")
+	}
+}
+
+func TestNewGoogleTTSValidated(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+
+	// the voice can not be checked without credentials, the constructor fails rather than returning an unchecked voice.
+	tts, err := NewGoogleTTSValidated(context.Background(), "", filepath.Join(t.TempDir(), "tts"), "en-US", "en-US-Neural2-F")
+	if err == nil || tts != nil {
+		t.Fatalf("NewGoogleTTSValidated without credentials returned %v, %v", tts, err)
+	}
+	if !strings.Contains(err.Error(), "failed to create client") {
+		t.Fatalf("error = %v, want the client error", err)
+	}
+}
@@ -0,0 +1,411 @@
+// Package goEagi of localtts.go provides a CommandSynthesizer type, which
+// synthesizes speech offline with a local engine run as a subprocess,
+// such as Piper with an ONNX voice model or espeak-ng, for when cloud
+// services are unreachable or forbidden.
+
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultPiperSampleRate = 22050
+	defaultCommandPoolSize = 2
+	commandStderrLimit     = 512
+)
+
+// ErrSynthesizerClosed is returned by the Synthesize method of a closed CommandSynthesizer.
+var ErrSynthesizerClosed = errors.New("synthesizer is closed")
+
+// CommandSynthesizer is a Synthesizer running Path with Args for every request, writing the text
+// followed by a line break to its standard input, and reading the speech from its standard output:
+// raw 16-bit little-endian mono PCM at SampleRate, or a wav file when WAV is set.
+// The speech is resampled to the rate of the request.
+//
+// Loading a voice model takes a while, so up to PoolSize processes are started ahead of requests
+// and wait for their text. Every process serves a single request, a new one being started in its place.
+// The voice is chosen by Args, Language and Voice of the requests are ignored, and SSML requests
+// fail unless SSMLArgs, replacing Args for them, are set.
+// A CommandSynthesizer is safe for concurrent use, Close stops its waiting processes.
+type CommandSynthesizer struct {
+	Path       string
+	Args       []string
+	SSMLArgs   []string
+	SampleRate int
+	WAV        bool
+	PoolSize   int
+
+	mu        sync.Mutex
+	warm      []*commandProcess
+	starting  int
+	refilling bool
+	refillErr error
+	closed    bool
+}
+
+// commandProcess is a started engine waiting for its text.
+type commandProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *bytes.Buffer
+}
+
+// NewCommandSynthesizer creates a CommandSynthesizer of path reading raw PCM at sampleRate.
+func NewCommandSynthesizer(path string, sampleRate int, args ...string) (*CommandSynthesizer, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find synthesizer command: %w", err)
+	}
+
+	return &CommandSynthesizer{
+		Path:       resolved,
+		Args:       args,
+		SampleRate: sampleRate,
+		PoolSize:   defaultCommandPoolSize,
+	}, nil
+}
+
+// NewPiperSynthesizer creates a CommandSynthesizer of the piper executable at path speaking with the ONNX
+// voice model at modelPath, whose rate is read from the model configuration next to it, modelPath followed by .json.
+func NewPiperSynthesizer(path, modelPath string) (*CommandSynthesizer, error) {
+	if _, err := os.Stat(modelPath); err != nil {
+		return nil, fmt.Errorf("failed to find voice model: %w", err)
+	}
+
+	rate, err := piperSampleRate(modelPath + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCommandSynthesizer(path, rate, "--model", modelPath, "--output-raw")
+}
+
+// NewEspeakSynthesizer creates a CommandSynthesizer of the espeak-ng executable at path speaking with voice,
+// such as "en-us", the espeak-ng default being used when it is empty. SSML requests are supported.
+func NewEspeakSynthesizer(path, voice string) (*CommandSynthesizer, error) {
+	args := []string{"--stdout"}
+	if voice != "" {
+		args = append(args, "-v", voice)
+	}
+
+	s, err := NewCommandSynthesizer(path, 0, append(args[:len(args):len(args)], "--stdin")...)
+	if err != nil {
+		return nil, err
+	}
+	s.WAV = true
+	s.SSMLArgs = append(args[:len(args):len(args)], "-m", "--stdin")
+	return s, nil
+}
+
+// NewLocalSynthesizer returns a Synthesizer speaking with Piper and the model at modelPath,
+// falling back to espeak-ng with voice when Piper fails. Either engine may be missing, but not both.
+func NewLocalSynthesizer(piperPath, modelPath, espeakPath, voice string) (Synthesizer, error) {
+	var synthesizers FallbackSynthesizer
+	var failures []string
+
+	if piper, err := NewPiperSynthesizer(piperPath, modelPath); err == nil {
+		synthesizers = append(synthesizers, piper)
+	} else {
+		failures = append(failures, "piper: "+err.Error())
+	}
+
+	if espeak, err := NewEspeakSynthesizer(espeakPath, voice); err == nil {
+		synthesizers = append(synthesizers, espeak)
+	} else {
+		failures = append(failures, "espeak-ng: "+err.Error())
+	}
+
+	if len(synthesizers) == 0 {
+		return nil, fmt.Errorf("no local synthesizer available: %s", strings.Join(failures, "; "))
+	}
+	if len(synthesizers) == 1 {
+		return synthesizers[0], nil
+	}
+	return synthesizers, nil
+}
+
+// CacheKey identifies the speech of req by the command and its arguments.
+func (s *CommandSynthesizer) CacheKey(req SynthesisRequest) string {
+	req.Language = ""
+	req.Voice = fmt.Sprintf("command\x00%s\x00%s\x00%s", s.Path, strings.Join(s.Args, "\x00"), strings.Join(s.SSMLArgs, "\x00"))
+	return req.key()
+}
+
+// Synthesize implements Synthesizer, with a waiting process when there is one.
+func (s *CommandSynthesizer) Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+	rate := req.SampleRate
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+
+	args := s.Args
+	if req.SSML {
+		if len(s.SSMLArgs) == 0 {
+			return nil, fmt.Errorf("%s does not support SSML", s.Path)
+		}
+		args = s.SSMLArgs
+	}
+
+	p, warm, err := s.take(args)
+	if err != nil {
+		return nil, err
+	}
+	if !req.SSML {
+		// the pool fills, or a replacement loads, while this request runs.
+		s.refill()
+	}
+
+	output, err := p.run(ctx, req.Text)
+	if err != nil && warm && ctx.Err() == nil {
+		// a process may have died while waiting, a fresh one tells whether the engine fails.
+		if p, err = s.start(args); err == nil {
+			output, err = p.run(ctx, req.Text)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", s.Path, err)
+	}
+
+	if s.WAV {
+		return decodeSynthesizedWav(output, rate)
+	}
+
+	if len(output)%2 != 0 {
+		output = output[:len(output)-1]
+	}
+	if s.SampleRate == rate {
+		return output, nil
+	}
+
+	samples, err := ResampleSamples(DecodeFrame(nil, output), s.SampleRate, rate, ResampleQualityHigh)
+	if err != nil {
+		return nil, err
+	}
+	return Frame(samples).Bytes(nil), nil
+}
+
+// Warm starts processes until PoolSize of them are waiting or starting. Synthesize refills the pool in the background,
+// calling Warm beforehand takes the model loading out of the first requests. When a background refill fails,
+// the pool is left empty until a request or Warm starts the engine again, and Warm returns that failure if it fails too.
+func (s *CommandSynthesizer) Warm() error {
+	for {
+		s.mu.Lock()
+		if s.closed || len(s.warm)+s.starting >= s.PoolSize {
+			s.mu.Unlock()
+			return nil
+		}
+		s.starting++
+		s.mu.Unlock()
+
+		// loading a model takes a while, requests go on meanwhile.
+		p, err := s.start(s.Args)
+
+		s.mu.Lock()
+		s.starting--
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.refillErr = nil
+		if s.closed {
+			s.mu.Unlock()
+			p.kill()
+			return nil
+		}
+		s.warm = append(s.warm, p)
+		s.mu.Unlock()
+	}
+}
+
+// Close kills the waiting processes, later requests fail with ErrSynthesizerClosed.
+func (s *CommandSynthesizer) Close() error {
+	s.mu.Lock()
+	warm := s.warm
+	s.warm = nil
+	s.closed = true
+	s.mu.Unlock()
+
+	for _, p := range warm {
+		p.kill()
+	}
+	return nil
+}
+
+// refill warms the pool in the background, unless it is full, being refilled, or the last refill failed.
+func (s *CommandSynthesizer) refill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.refilling || s.refillErr != nil || len(s.warm)+s.starting >= s.PoolSize {
+		return
+	}
+	s.refilling = true
+
+	go func() {
+		err := s.Warm()
+
+		s.mu.Lock()
+		s.refilling = false
+		if err != nil {
+			// a broken engine is not restarted for every request, they report the failure starting it themselves.
+			s.refillErr = err
+		}
+		s.mu.Unlock()
+	}()
+}
+
+// take returns a waiting process started with args, or a new one when none is waiting,
+// and whether it was waiting.
+func (s *CommandSynthesizer) take(args []string) (*commandProcess, bool, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, false, ErrSynthesizerClosed
+	}
+	if len(s.warm) > 0 && sameArgs(args, s.Args) {
+		p := s.warm[0]
+		s.warm = s.warm[1:]
+		s.mu.Unlock()
+		return p, true, nil
+	}
+	s.mu.Unlock()
+
+	p, err := s.start(args)
+	if err == nil && sameArgs(args, s.Args) {
+		s.mu.Lock()
+		s.refillErr = nil
+		s.mu.Unlock()
+	}
+	return p, false, err
+}
+
+// start starts the command with args, waiting for its text on the standard input.
+func (s *CommandSynthesizer) start(args []string) (*commandProcess, error) {
+	cmd := exec.Command(s.Path, args...)
+	p := &commandProcess{cmd: cmd, stderr: &bytes.Buffer{}}
+	cmd.Stderr = &limitedBuffer{buffer: p.stderr, limit: commandStderrLimit}
+
+	var err error
+	if p.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", s.Path, err)
+	}
+	if p.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", s.Path, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", s.Path, err)
+	}
+	return p, nil
+}
+
+// run writes text to the process and returns its whole output, killing it when ctx is done.
+func (p *commandProcess) run(ctx context.Context, text string) ([]byte, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	// a process dying early breaks the pipe, its exit status tells why.
+	go func() {
+		io.WriteString(p.stdin, strings.ReplaceAll(text, "\n", " ")+"\n")
+		p.stdin.Close()
+	}()
+
+	output, readErr := io.ReadAll(p.stdout)
+	waitErr := p.cmd.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if waitErr != nil {
+		if stderr := strings.TrimSpace(p.stderr.String()); stderr != "" {
+			return nil, fmt.Errorf("%w: %s", waitErr, stderr)
+		}
+		return nil, waitErr
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if len(output) == 0 {
+		return nil, errors.New("no audio output")
+	}
+	return output, nil
+}
+
+// kill stops a waiting process and reaps it.
+func (p *commandProcess) kill() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
+
+// sameArgs reports whether a and b are the same arguments.
+func sameArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// limitedBuffer keeps the first limit bytes written to it, discarding the rest.
+type limitedBuffer struct {
+	buffer *bytes.Buffer
+	limit  int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buffer.Len(); room > 0 {
+		if len(p) > room {
+			b.buffer.Write(p[:room])
+		} else {
+			b.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// piperSampleRate reads the sample rate of a Piper voice model configuration, 22050 Hz when it has none.
+func piperSampleRate(configPath string) (int, error) {
+	content, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return defaultPiperSampleRate, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read voice model configuration: %w", err)
+	}
+
+	var config struct {
+		Audio struct {
+			SampleRate int `json:"sample_rate"`
+		} `json:"audio"`
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return 0, fmt.Errorf("failed to parse voice model configuration: %w", err)
+	}
+
+	if config.Audio.SampleRate <= 0 {
+		return defaultPiperSampleRate, nil
+	}
+	return config.Audio.SampleRate, nil
+}
@@ -0,0 +1,176 @@
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEngine writes a shell script standing for a synthesis engine, and returns a CommandSynthesizer running it.
+func fakeEngine(t *testing.T, rate int, script string) *CommandSynthesizer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "engine")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewCommandSynthesizer(path, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestCommandSynthesizerRawOutput(t *testing.T) {
+	// the engine speaks its text back as samples.
+	s := fakeEngine(t, audioSampleRate, `read line; printf '%s' "$line"`)
+
+	for _, text := range []string{"abcd", "one\ntwo", "odd"} {
+		audio, err := s.Synthesize(context.Background(), SynthesisRequest{Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.ReplaceAll(text, "\n", " ")
+		want = want[:len(want)/2*2]
+		if string(audio) != want {
+			t.Errorf("Synthesize(%q) = %q, want %q", text, audio, want)
+		}
+	}
+}
+
+func TestCommandSynthesizerResamples(t *testing.T) {
+	// a second of silence at 16 kHz.
+	s := fakeEngine(t, 16000, `read line; head -c 32000 /dev/zero`)
+
+	audio, err := s.Synthesize(context.Background(), SynthesisRequest{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(audio) / 2; n < 7900 || n > 8100 {
+		t.Errorf("got %d samples at 8 kHz, want about 8000", n)
+	}
+}
+
+func TestCommandSynthesizerWavOutput(t *testing.T) {
+	tone := toneAudio(440, 200*time.Millisecond)
+	file := filepath.Join(t.TempDir(), "speech.wav")
+	h := wavHeader{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: audioSampleRate, BitsPerSample: 16}
+	if err := os.WriteFile(file, wavFile(h, tone), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := fakeEngine(t, 0, `read line; cat '`+file+`'`)
+	s.WAV = true
+
+	audio, err := s.Synthesize(context.Background(), SynthesisRequest{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(audio) != string(tone) {
+		t.Errorf("got %d bytes, want the %d bytes of the wav data", len(audio), len(tone))
+	}
+}
+
+func TestCommandSynthesizerExitError(t *testing.T) {
+	s := fakeEngine(t, audioSampleRate, `read line; echo "voice model not found" >&2; exit 3`)
+
+	_, err := s.Synthesize(context.Background(), SynthesisRequest{Text: "hello"})
+	if err == nil {
+		t.Fatal("Synthesize succeeded with a failing engine")
+	}
+	for _, want := range []string{"exit status 3", "voice model not found"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestCommandSynthesizerRetriesDeadWarmProcess(t *testing.T) {
+	s := fakeEngine(t, audioSampleRate, `read line; printf '%s' "$line"`)
+	s.PoolSize = 1
+	if err := s.Warm(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	dead := s.warm[0]
+	s.mu.Unlock()
+	dead.cmd.Process.Kill()
+
+	audio, err := s.Synthesize(context.Background(), SynthesisRequest{Text: "live"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(audio) != "live" {
+		t.Errorf("got %q, want %q", audio, "live")
+	}
+}
+
+func TestCommandSynthesizerPoolStaysWithinSize(t *testing.T) {
+	s := fakeEngine(t, audioSampleRate, `read line; printf '%s' "$line"`)
+	s.PoolSize = 2
+
+	for i := 0; i < 20; i++ {
+		if _, err := s.Synthesize(context.Background(), SynthesisRequest{Text: "ab"}); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		n := len(s.warm) + s.starting
+		s.mu.Unlock()
+		if n > s.PoolSize {
+			t.Fatalf("%d processes waiting or starting, want at most %d", n, s.PoolSize)
+		}
+	}
+}
+
+func TestCommandSynthesizerClose(t *testing.T) {
+	s := fakeEngine(t, audioSampleRate, `read line; printf '%s' "$line"`)
+	s.PoolSize = 2
+	if err := s.Warm(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	warm := append([]*commandProcess(nil), s.warm...)
+	s.mu.Unlock()
+	if len(warm) != 2 {
+		t.Fatalf("%d processes waiting, want 2", len(warm))
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range warm {
+		if p.cmd.ProcessState == nil {
+			t.Error("a waiting process was not stopped")
+		}
+	}
+
+	if _, err := s.Synthesize(context.Background(), SynthesisRequest{Text: "ab"}); !errors.Is(err, ErrSynthesizerClosed) {
+		t.Errorf("got %v, want ErrSynthesizerClosed", err)
+	}
+	if err := s.Warm(); err != nil {
+		t.Errorf("Warm after Close: %v", err)
+	}
+}
+
+func TestCommandSynthesizerKilledOnCancel(t *testing.T) {
+	s := fakeEngine(t, audioSampleRate, `read line; exec sleep 30`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := s.Synthesize(ctx, SynthesisRequest{Text: "hello"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Synthesize returned after %v, the engine was not killed", elapsed)
+	}
+}
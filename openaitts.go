@@ -0,0 +1,228 @@
+// Package goEagi of openaitts.go provides an OpenAITTS type, which
+// synthesizes speech with the OpenAI speech endpoint, /v1/audio/speech,
+// also exposed by many hosted and self-hosted text to speech servers.
+
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOpenAIModel      = "tts-1"
+	defaultOpenAIVoice      = "alloy"
+	defaultOpenAIFormat     = "pcm"
+	defaultOpenAISampleRate = 24000
+	openAIErrorLimit        = 4096
+	defaultOpenAITimeout    = time.Minute
+)
+
+// openAIHTTPClient sends the requests of an OpenAITTS without HTTPClient. Its timeout keeps a stalled server
+// from blocking a caller whose ctx has no deadline, such as a TTSCache fill.
+var openAIHTTPClient = &http.Client{Timeout: defaultOpenAITimeout}
+
+// OpenAITTS synthesizes speech with an OpenAI-compatible speech endpoint, it implements Synthesizer.
+// The request Voice replaces Voice, and its Language is left to the model, as the endpoint has no such parameter.
+type OpenAITTS struct {
+	// BaseURL is the base URL of the API, such as https://api.openai.com/v1, which the endpoint path audio/speech follows.
+	BaseURL string
+	// APIKey is sent as a bearer token when set.
+	APIKey string
+	Model  string
+	Voice  string
+	// ResponseFormat is "pcm", raw 16-bit little-endian mono at SampleRate, or "wav".
+	// A wav response is recognized whatever the format asked for.
+	ResponseFormat string
+	// SampleRate is the rate of pcm responses, 24 kHz for OpenAI.
+	SampleRate int
+	// Speed is 0.25 to 4, 0 leaving the server default.
+	Speed float64
+	// HTTPClient sends the requests, by default a client with a timeout of 1 minute per request.
+	HTTPClient *http.Client
+}
+
+// openAISpeechRequest is the body of a speech request.
+type openAISpeechRequest struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// openAIError is the error document returned by the API.
+type openAIError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// NewOpenAITTS creates an OpenAITTS of the API at baseURL, requesting pcm at 24 kHz
+// with the tts-1 model and the alloy voice.
+func NewOpenAITTS(baseURL, apiKey string) (*OpenAITTS, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid speech API base URL: %q", baseURL)
+	}
+
+	return &OpenAITTS{
+		BaseURL:        baseURL,
+		APIKey:         apiKey,
+		Model:          defaultOpenAIModel,
+		Voice:          defaultOpenAIVoice,
+		ResponseFormat: defaultOpenAIFormat,
+		SampleRate:     defaultOpenAISampleRate,
+	}, nil
+}
+
+// CacheKey identifies the speech of req with the endpoint and settings of tts, the response format and
+// its sample rate included, as they change the audio resampled to req.SampleRate.
+func (tts *OpenAITTS) CacheKey(req SynthesisRequest) string {
+	if req.Voice == "" {
+		req.Voice = tts.Voice
+	}
+	format := tts.ResponseFormat
+	if format == "" {
+		format = defaultOpenAIFormat
+	}
+	rate := tts.SampleRate
+	if rate <= 0 {
+		rate = defaultOpenAISampleRate
+	}
+	req.Language = ""
+	req.Voice += fmt.Sprintf("\x00openai\x00%s\x00%s\x00%v\x00%s\x00%d", strings.TrimRight(tts.BaseURL, "/"), tts.Model, tts.Speed, format, rate)
+	return req.key()
+}
+
+// Synthesize implements Synthesizer. The whole response is read before being resampled to req.SampleRate.
+func (tts *OpenAITTS) Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+	if req.SSML {
+		return nil, errors.New("speech API does not support SSML")
+	}
+	if tts.Speed != 0 && (tts.Speed < 0.25 || tts.Speed > 4) {
+		return nil, fmt.Errorf("speed %v is out of 0.25 to 4", tts.Speed)
+	}
+
+	rate := req.SampleRate
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+
+	format := tts.ResponseFormat
+	if format == "" {
+		format = defaultOpenAIFormat
+	}
+	if format != "pcm" && format != "wav" {
+		return nil, fmt.Errorf("unsupported response format: %s", format)
+	}
+
+	body := openAISpeechRequest{
+		Model:          tts.Model,
+		Input:          req.Text,
+		Voice:          tts.Voice,
+		ResponseFormat: format,
+		Speed:          tts.Speed,
+	}
+	if body.Model == "" {
+		body.Model = defaultOpenAIModel
+	}
+	if req.Voice != "" {
+		body.Voice = req.Voice
+	}
+	if body.Voice == "" {
+		body.Voice = defaultOpenAIVoice
+	}
+
+	content, err := tts.post(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(content, []byte("RIFF")) {
+		return decodeSynthesizedWav(content, rate)
+	}
+	if format == "wav" {
+		return nil, errors.New("failed to read synthesized audio: response is not a wav file")
+	}
+
+	inRate := tts.SampleRate
+	if inRate <= 0 {
+		inRate = defaultOpenAISampleRate
+	}
+	if len(content)%2 != 0 {
+		content = content[:len(content)-1]
+	}
+	if inRate == rate {
+		return content, nil
+	}
+
+	samples, err := ResampleSamples(DecodeFrame(nil, content), inRate, rate, ResampleQualityHigh)
+	if err != nil {
+		return nil, err
+	}
+	return Frame(samples).Bytes(nil), nil
+}
+
+// post sends a speech request and returns the audio of the response.
+func (tts *OpenAITTS) post(ctx context.Context, body openAISpeechRequest) ([]byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimRight(tts.BaseURL, "/") + "/audio/speech"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("invalid speech API base URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if tts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+tts.APIKey)
+	}
+
+	client := tts.HTTPClient
+	if client == nil {
+		client = openAIHTTPClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		payload, _ := io.ReadAll(io.LimitReader(resp.Body, openAIErrorLimit))
+		return nil, openAIResponseError(resp.StatusCode, payload)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read synthesized audio: %w", err)
+	}
+	if len(content) == 0 {
+		return nil, errors.New("failed to read synthesized audio: empty response")
+	}
+	return content, nil
+}
+
+func openAIResponseError(status int, payload []byte) error {
+	var e openAIError
+	if json.Unmarshal(payload, &e) == nil && e.Error.Message != "" {
+		return fmt.Errorf("speech API: %d %s: %s", status, http.StatusText(status), e.Error.Message)
+	}
+	if text := strings.TrimSpace(string(payload)); text != "" && len(text) < 200 {
+		return fmt.Errorf("speech API: %d %s: %s", status, http.StatusText(status), text)
+	}
+	return fmt.Errorf("speech API: %d %s", status, http.StatusText(status))
+}
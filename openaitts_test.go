@@ -0,0 +1,237 @@
+package goEagi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSpeechAPI serves /v1/audio/speech with handler, recording the last request body.
+func fakeSpeechAPI(t *testing.T, handler func(w http.ResponseWriter, body openAISpeechRequest)) (*OpenAITTS, *http.Request) {
+	t.Helper()
+	var last http.Request
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/audio/speech" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var body openAISpeechRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		last = *r
+		handler(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	tts, err := NewOpenAITTS(server.URL+"/v1/", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tts, &last
+}
+
+func TestOpenAITTSRequest(t *testing.T) {
+	var got openAISpeechRequest
+	tts, last := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		got = body
+		w.Write(make([]byte, 480))
+	})
+	tts.SampleRate = audioSampleRate
+	tts.Speed = 1.5
+
+	audio, err := tts.Synthesize(context.Background(), SynthesisRequest{Text: "hello", Voice: "nova"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audio) != 480 {
+		t.Errorf("got %d bytes, want the 480 of the response", len(audio))
+	}
+
+	if auth := last.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+	}
+	if ct := last.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	want := openAISpeechRequest{Model: "tts-1", Input: "hello", Voice: "nova", ResponseFormat: "pcm", Speed: 1.5}
+	if got != want {
+		t.Errorf("body = %+v, want %+v", got, want)
+	}
+}
+
+func TestOpenAITTSResamplesPCM(t *testing.T) {
+	// a second of a tone at 24 kHz.
+	samples, err := ResampleSamples(DecodeFrame(nil, toneAudio(440, time.Second)), audioSampleRate, 24000, ResampleQualityHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tts, _ := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		w.Write(Frame(samples).Bytes(nil))
+	})
+
+	audio, err := tts.Synthesize(context.Background(), SynthesisRequest{Text: "hello", SampleRate: audioSampleRate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(audio) / 2; n < 7900 || n > 8100 {
+		t.Errorf("got %d samples at 8 kHz, want about 8000", n)
+	}
+	if level, want := ComputeLevel(audio), ComputeLevel(toneAudio(440, time.Second)); level < want-1 || level > want+1 {
+		t.Errorf("level %.1f dBFS, want the %.1f dBFS of the tone", level, want)
+	}
+}
+
+func TestOpenAITTSWavResponse(t *testing.T) {
+	tone := toneAudio(440, 200*time.Millisecond)
+	h := wavHeader{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: audioSampleRate, BitsPerSample: 16}
+	var format string
+	tts, _ := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		format = body.ResponseFormat
+		w.Write(wavFile(h, tone))
+	})
+	tts.ResponseFormat = "wav"
+
+	audio, err := tts.Synthesize(context.Background(), SynthesisRequest{Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "wav" {
+		t.Errorf("response_format = %q, want wav", format)
+	}
+	if string(audio) != string(tone) {
+		t.Errorf("got %d bytes, want the %d bytes of the wav data", len(audio), len(tone))
+	}
+}
+
+func TestOpenAITTSErrorResponse(t *testing.T) {
+	tts, _ := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": {"message": "Incorrect API key provided", "type": "invalid_request_error"}}`))
+	})
+
+	_, err := tts.Synthesize(context.Background(), SynthesisRequest{Text: "hello"})
+	if err == nil || err.Error() != "speech API: 401 Unauthorized: Incorrect API key provided" {
+		t.Errorf("got %v, want the decoded API error", err)
+	}
+}
+
+func TestOpenAIResponseError(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`{"error": {"message": "Rate limit reached"}}`, "speech API: 429 Too Many Requests: Rate limit reached"},
+		{"upstream busy\n", "speech API: 429 Too Many Requests: upstream busy"},
+		{"", "speech API: 429 Too Many Requests"},
+		{strings.Repeat("x", 300), "speech API: 429 Too Many Requests"},
+	}
+
+	for _, tt := range tests {
+		if got := openAIResponseError(http.StatusTooManyRequests, []byte(tt.payload)).Error(); got != tt.want {
+			t.Errorf("openAIResponseError(%.20q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAITTSCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	tts, _ := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		// the headers and the start of the audio go out, the rest never comes.
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		<-release
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := tts.Synthesize(ctx, SynthesisRequest{Text: "hello"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Synthesize returned after %v", elapsed)
+	}
+}
+
+func TestOpenAITTSDefaultClientTimeout(t *testing.T) {
+	if openAIHTTPClient == http.DefaultClient || openAIHTTPClient.Timeout != defaultOpenAITimeout {
+		t.Fatalf("default client timeout = %v, want %v", openAIHTTPClient.Timeout, defaultOpenAITimeout)
+	}
+
+	stalled := make(chan struct{})
+	defer close(stalled)
+	tts, _ := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		<-stalled
+	})
+
+	// the default client, with a shorter timeout.
+	defaultClient := openAIHTTPClient
+	openAIHTTPClient = &http.Client{Timeout: 100 * time.Millisecond}
+	defer func() { openAIHTTPClient = defaultClient }()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := tts.Synthesize(context.Background(), SynthesisRequest{Text: "hello"})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Synthesize succeeded against a stalled server")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Synthesize blocked on a stalled server")
+	}
+}
+
+func TestOpenAITTSCacheKey(t *testing.T) {
+	tts, err := NewOpenAITTS("https://api.openai.com/v1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := SynthesisRequest{Text: "Hello"}
+	key := tts.CacheKey(req)
+
+	if !isCacheEntryName(key + ".wav") {
+		t.Fatalf("cache key %q is not a cache entry name", key)
+	}
+	if explicit := tts.CacheKey(SynthesisRequest{Text: "Hello", Voice: defaultOpenAIVoice}); explicit != key {
+		t.Error("default voice changes the cache key")
+	}
+	if defaults := (&OpenAITTS{BaseURL: tts.BaseURL, Model: tts.Model, Voice: tts.Voice}).CacheKey(req); defaults != key {
+		t.Error("default response format and sample rate change the cache key")
+	}
+
+	tts.SampleRate = 16000
+	if tts.CacheKey(req) == key {
+		t.Error("sample rate does not change the cache key")
+	}
+	tts.SampleRate = defaultOpenAISampleRate
+	tts.ResponseFormat = "wav"
+	if tts.CacheKey(req) == key {
+		t.Error("response format does not change the cache key")
+	}
+}
+
+func TestOpenAITTSRejectsSSML(t *testing.T) {
+	tts, _ := fakeSpeechAPI(t, func(w http.ResponseWriter, body openAISpeechRequest) {
+		t.Error("SSML request sent")
+	})
+
+	if _, err := tts.Synthesize(context.Background(), SynthesisRequest{Text: "<speak>hi</speak>", SSML: true}); err == nil {
+		t.Error("Synthesize accepted SSML")
+	}
+}
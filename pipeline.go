@@ -0,0 +1,434 @@
+// Package goEagi of pipeline.go provides a Pipeline type, which runs
+// an audio source through processing stages into one or more sinks,
+// such as recognizers and recorders, with bounded queues, context
+// cancellation and error propagation.
+
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultPipelineQueueSize = 50
+
+// Stage processes a frame of 16-bit little-endian audio and returns the frame to pass on,
+// a nil or empty frame is dropped. A stage must not modify the frame it receives.
+type Stage interface {
+	Process(frame []byte) ([]byte, error)
+}
+
+// TimedStage is a Stage which also takes when the frame was captured, such as a detector stamping
+// its events, as frames may wait in the queues. The Pipeline calls ProcessAt instead of Process.
+type TimedStage interface {
+	Stage
+	ProcessAt(frame []byte, captured time.Time) ([]byte, error)
+}
+
+// StageFunc adapts a function to a Stage.
+type StageFunc func(frame []byte) ([]byte, error)
+
+// Process calls f(frame).
+func (f StageFunc) Process(frame []byte) ([]byte, error) {
+	return f(frame)
+}
+
+// Sink consumes the frames leaving the last stage until frames is closed or ctx is cancelled.
+// Frames are shared between sinks and must not be modified.
+type Sink interface {
+	Consume(ctx context.Context, frames <-chan []byte) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, frames <-chan []byte) error
+
+// Consume calls f(ctx, frames).
+func (f SinkFunc) Consume(ctx context.Context, frames <-chan []byte) error {
+	return f(ctx, frames)
+}
+
+// Pipeline reads a source, runs every frame through its stages in order and hands the result to all of its sinks.
+// Every stage and sink runs in its own goroutine behind a queue of QueueSize frames, a full queue blocks
+// the upstream side. The first error cancels the whole pipeline and is returned by Wait.
+// A sink which returns nil early stops receiving frames while the others carry on.
+type Pipeline struct {
+	QueueSize int
+
+	source <-chan AudioResult
+	stages []namedStage
+	sinks  []namedSink
+
+	started bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+type namedStage struct {
+	name  string
+	stage Stage
+}
+
+type namedSink struct {
+	name string
+	sink Sink
+}
+
+// pipelineFrame is a frame between the stages, along with the capture time of the source frame it comes from.
+type pipelineFrame struct {
+	data     []byte
+	captured time.Time
+}
+
+// NewPipeline creates a Pipeline reading source, such as the stream returned by StreamAudio.
+// The frames of source must not be reused by its producer once sent.
+func NewPipeline(source <-chan AudioResult) *Pipeline {
+	return &Pipeline{
+		QueueSize: defaultPipelineQueueSize,
+		source:    source,
+	}
+}
+
+// Stage appends a processing stage, name is used in errors.
+func (p *Pipeline) Stage(name string, s Stage) *Pipeline {
+	p.stages = append(p.stages, namedStage{name: name, stage: s})
+	return p
+}
+
+// Sink adds a sink, name is used in errors.
+func (p *Pipeline) Sink(name string, s Sink) *Pipeline {
+	p.sinks = append(p.sinks, namedSink{name: name, sink: s})
+	return p
+}
+
+// Start launches the goroutines of the pipeline, they stop when the source is closed,
+// when any of them fails or when ctx is cancelled.
+func (p *Pipeline) Start(ctx context.Context) error {
+	if p.started {
+		return errors.New("pipeline already started")
+	}
+	if p.source == nil {
+		return errors.New("pipeline has no source")
+	}
+	if len(p.sinks) == 0 {
+		return errors.New("pipeline has no sink")
+	}
+	p.started = true
+
+	queueSize := p.QueueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	ctx, p.cancel = context.WithCancel(ctx)
+
+	frames := make(chan pipelineFrame, queueSize)
+	p.run(func() error { return p.readSource(ctx, frames) })
+
+	var in <-chan pipelineFrame = frames
+	for _, s := range p.stages {
+		out := make(chan pipelineFrame, queueSize)
+		p.run(runStage(ctx, s, in, out))
+		in = out
+	}
+
+	queues := make([]sinkQueue, len(p.sinks))
+	for i, s := range p.sinks {
+		queues[i] = sinkQueue{frames: make(chan []byte, queueSize), done: make(chan struct{})}
+		s := s
+		queue := queues[i]
+		p.run(func() error {
+			defer close(queue.done)
+
+			if err := s.sink.Consume(ctx, queue.frames); err != nil {
+				return fmt.Errorf("sink %s: %w", s.name, err)
+			}
+			return nil
+		})
+	}
+	p.run(func() error { return fanOut(ctx, in, queues) })
+
+	return nil
+}
+
+// Wait blocks until every goroutine of the pipeline has stopped and returns the first error.
+// A cancelled ctx is reported as its error.
+func (p *Pipeline) Wait() error {
+	if !p.started {
+		return errors.New("pipeline not started")
+	}
+
+	p.wg.Wait()
+	p.cancel()
+	return p.err
+}
+
+// Run starts the pipeline and waits for it.
+func (p *Pipeline) Run(ctx context.Context) error {
+	if err := p.Start(ctx); err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// run launches fn, the first error is recorded and cancels the pipeline.
+func (p *Pipeline) run(fn func() error) {
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		if err := fn(); err != nil {
+			p.errOnce.Do(func() {
+				p.err = err
+				p.cancel()
+			})
+		}
+	}()
+}
+
+// readSource forwards the frames of the source until it is closed or reports an error.
+// Frames without a capture time are stamped with the time they are read.
+func (p *Pipeline) readSource(ctx context.Context, out chan<- pipelineFrame) error {
+	defer close(out)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case audio, ok := <-p.source:
+			if !ok {
+				return nil
+			}
+			if audio.Error != nil {
+				return fmt.Errorf("source: %w", audio.Error)
+			}
+
+			frame := pipelineFrame{data: audio.Stream, captured: audio.Captured}
+			if frame.captured.IsZero() {
+				frame.captured = time.Now()
+			}
+
+			select {
+			case out <- frame:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func runStage(ctx context.Context, s namedStage, in <-chan pipelineFrame, out chan<- pipelineFrame) func() error {
+	timed, isTimed := s.stage.(TimedStage)
+
+	return func() error {
+		defer close(out)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case frame, ok := <-in:
+				if !ok {
+					return nil
+				}
+
+				var processed []byte
+				var err error
+				if isTimed {
+					processed, err = timed.ProcessAt(frame.data, frame.captured)
+				} else {
+					processed, err = s.stage.Process(frame.data)
+				}
+				if err != nil {
+					return fmt.Errorf("stage %s: %w", s.name, err)
+				}
+				if len(processed) == 0 {
+					continue
+				}
+
+				select {
+				case out <- pipelineFrame{data: processed, captured: frame.captured}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// sinkQueue is the queue of a sink, done is closed once the sink has returned.
+type sinkQueue struct {
+	frames chan []byte
+	done   chan struct{}
+}
+
+// fanOut hands every frame to all sink queues, waiting for the slowest one.
+// A sink which returned without error is skipped, so that it does not block the others.
+func fanOut(ctx context.Context, in <-chan pipelineFrame, queues []sinkQueue) error {
+	defer func() {
+		for _, q := range queues {
+			close(q.frames)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case frame, ok := <-in:
+			if !ok {
+				return nil
+			}
+
+			for _, q := range queues {
+				select {
+				case q.frames <- frame.data:
+				case <-q.done:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// StreamingSink adapts the StartStreaming method of GoogleService, AzureService or VoskService to a Sink.
+// The recognizer reads from a channel which stays open until ctx is cancelled,
+// so that it is not fed closed-channel reads once the source ends.
+func StreamingSink(start func(ctx context.Context, stream <-chan []byte) <-chan error) Sink {
+	return SinkFunc(func(ctx context.Context, frames <-chan []byte) error {
+		bridgeStream := make(chan []byte)
+		errStream := start(ctx, bridgeStream)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case err, ok := <-errStream:
+				if !ok {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+
+			case frame, ok := <-frames:
+				if !ok {
+					return nil
+				}
+
+				select {
+				case bridgeStream <- frame:
+				case err := <-errStream:
+					return err
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	})
+}
+
+// ResampleStage converts the frames with r, the rate of the following stages becomes r.OutputRate().
+func ResampleStage(r *Resampler) Stage {
+	var in, out []int16
+
+	return StageFunc(func(frame []byte) ([]byte, error) {
+		in = DecodeFrame(in, frame)
+		out = r.Process(out, in)
+		return Frame(out).Bytes(nil), nil
+	})
+}
+
+// PreprocessStage runs the noise suppressor and AGC of p on the frames.
+func PreprocessStage(p *Preprocessor) Stage {
+	return StageFunc(func(frame []byte) ([]byte, error) {
+		return p.ProcessFrame(frame), nil
+	})
+}
+
+// VadStage passes the frames through unchanged and calls fn with the voice activity of each of them.
+func VadStage(v *Vad, fn func(VadResult)) Stage {
+	return StageFunc(func(frame []byte) ([]byte, error) {
+		result, err := v.Analyze(frame)
+		if err != nil {
+			return nil, err
+		}
+		fn(result)
+		return frame, nil
+	})
+}
+
+// DTMFStage passes the frames through unchanged and calls fn with every detected digit,
+// whose Time is the capture time of the start of the digit.
+func DTMFStage(d *DTMFDetector, fn func(DTMFResult)) Stage {
+	return dtmfStage{d: d, fn: fn}
+}
+
+type dtmfStage struct {
+	d  *DTMFDetector
+	fn func(DTMFResult)
+}
+
+func (s dtmfStage) Process(frame []byte) ([]byte, error) {
+	return s.ProcessAt(frame, time.Now())
+}
+
+func (s dtmfStage) ProcessAt(frame []byte, captured time.Time) ([]byte, error) {
+	for _, r := range s.d.ProcessAt(frame, captured) {
+		s.fn(r)
+	}
+	return frame, nil
+}
+
+// ToneStage passes the frames through unchanged and calls fn with every detected tone,
+// whose Time is the capture time of the start of the tone.
+func ToneStage(t *ToneDetector, fn func(ToneEvent)) Stage {
+	return toneStage{t: t, fn: fn}
+}
+
+type toneStage struct {
+	t  *ToneDetector
+	fn func(ToneEvent)
+}
+
+func (s toneStage) Process(frame []byte) ([]byte, error) {
+	return s.ProcessAt(frame, time.Now())
+}
+
+func (s toneStage) ProcessAt(frame []byte, captured time.Time) ([]byte, error) {
+	for _, e := range s.t.ProcessAt(frame, captured) {
+		s.fn(e)
+	}
+	return frame, nil
+}
+
+// QualityStage passes the frames through unchanged and accumulates their quality metrics in q,
+// the capture time of the frames being their arrival, wherever the stage sits in the pipeline.
+func QualityStage(q *QualityAnalyzer) Stage {
+	return qualityStage{q: q}
+}
+
+type qualityStage struct {
+	q *QualityAnalyzer
+}
+
+func (s qualityStage) Process(frame []byte) ([]byte, error) {
+	return s.ProcessAt(frame, time.Now())
+}
+
+func (s qualityStage) ProcessAt(frame []byte, captured time.Time) ([]byte, error) {
+	if err := s.q.ProcessAt(frame, captured); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
@@ -0,0 +1,206 @@
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pipelineSource returns a source holding n one byte frames, closed after the last one.
+func pipelineSource(n int) <-chan AudioResult {
+	source := make(chan AudioResult, n)
+	for i := 0; i < n; i++ {
+		source <- AudioResult{Stream: []byte{byte(i)}}
+	}
+	close(source)
+	return source
+}
+
+// collector is a Sink recording every frame it receives.
+type collector struct {
+	mu     sync.Mutex
+	frames []byte
+}
+
+func (c *collector) Consume(ctx context.Context, frames <-chan []byte) error {
+	for frame := range frames {
+		c.mu.Lock()
+		c.frames = append(c.frames, frame...)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// waitPipeline returns the error of p.Wait, failing the test if it does not return in time.
+func waitPipeline(t *testing.T, p *Pipeline) error {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return")
+		return nil
+	}
+}
+
+func TestPipelineStagesAndSinks(t *testing.T) {
+	var first, second collector
+	p := NewPipeline(pipelineSource(100)).
+		Stage("double", StageFunc(func(frame []byte) ([]byte, error) {
+			return []byte{frame[0] * 2}, nil
+		})).
+		Stage("odd", StageFunc(func(frame []byte) ([]byte, error) {
+			if frame[0]%4 == 0 {
+				return nil, nil
+			}
+			return frame, nil
+		})).
+		Sink("first", &first).
+		Sink("second", &second)
+	p.QueueSize = 1
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*collector{&first, &second} {
+		if len(c.frames) != 50 {
+			t.Fatalf("sink received %d frames, want 50", len(c.frames))
+		}
+		for i, b := range c.frames {
+			if want := byte(4*i + 2); b != want {
+				t.Fatalf("frame %d is %d, want %d", i, b, want)
+			}
+		}
+	}
+}
+
+func TestPipelineSinkStoppingEarly(t *testing.T) {
+	var all collector
+	p := NewPipeline(pipelineSource(100)).
+		Sink("early", SinkFunc(func(ctx context.Context, frames <-chan []byte) error {
+			for i := 0; i < 2; i++ {
+				<-frames
+			}
+			return nil
+		})).
+		Sink("all", &all)
+	p.QueueSize = 1
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.frames) != 100 {
+		t.Fatalf("remaining sink received %d frames, want 100", len(all.frames))
+	}
+}
+
+func TestPipelineStreamingSinkEndingEarly(t *testing.T) {
+	// a recognizer which closes its error stream after a few frames, as on the end of its session.
+	start := func(ctx context.Context, stream <-chan []byte) <-chan error {
+		errStream := make(chan error)
+		go func() {
+			defer close(errStream)
+			for i := 0; i < 3; i++ {
+				<-stream
+			}
+		}()
+		return errStream
+	}
+
+	var all collector
+	p := NewPipeline(pipelineSource(100)).
+		Sink("recognizer", StreamingSink(start)).
+		Sink("all", &all)
+	p.QueueSize = 2
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); err != nil {
+		t.Fatal(err)
+	}
+	if len(all.frames) != 100 {
+		t.Fatalf("remaining sink received %d frames, want 100", len(all.frames))
+	}
+}
+
+func TestPipelineSinkError(t *testing.T) {
+	errRecognizer := errors.New("recognizer failed")
+
+	// a source which never ends, the error has to stop the pipeline.
+	source := make(chan AudioResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case source <- AudioResult{Stream: []byte{0}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var all collector
+	p := NewPipeline(source).
+		Sink("recognizer", SinkFunc(func(ctx context.Context, frames <-chan []byte) error {
+			<-frames
+			return errRecognizer
+		})).
+		Sink("all", &all)
+	p.QueueSize = 1
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	err := waitPipeline(t, p)
+	if !errors.Is(err, errRecognizer) || err.Error() != "sink recognizer: recognizer failed" {
+		t.Fatalf("Wait returned %v, want the sink error", err)
+	}
+}
+
+func TestPipelineStageError(t *testing.T) {
+	errStage := errors.New("bad frame")
+
+	var all collector
+	p := NewPipeline(pipelineSource(10)).
+		Stage("check", StageFunc(func(frame []byte) ([]byte, error) {
+			if frame[0] == 5 {
+				return nil, errStage
+			}
+			return frame, nil
+		})).
+		Sink("all", &all)
+
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); !errors.Is(err, errStage) {
+		t.Fatalf("Wait returned %v, want the stage error", err)
+	}
+}
+
+func TestPipelineStartErrors(t *testing.T) {
+	if err := NewPipeline(nil).Sink("all", &collector{}).Start(context.Background()); err == nil {
+		t.Fatal("pipeline without a source started")
+	}
+	if err := NewPipeline(pipelineSource(1)).Start(context.Background()); err == nil {
+		t.Fatal("pipeline without a sink started")
+	}
+	if err := NewPipeline(pipelineSource(1)).Wait(); err == nil {
+		t.Fatal("Wait succeeded on a pipeline not started")
+	}
+}
@@ -0,0 +1,99 @@
+// Package goEagi of preprocess.go provides a preprocessing stage of noise
+// suppression and automatic gain control, which can be inserted between
+// StreamAudio and any recognizer or recorder.
+
+package goEagi
+
+import (
+	"context"
+	"time"
+)
+
+// Preprocessor runs the noise suppressor and then the AGC, either of which may be nil to disable it.
+// A Preprocessor is not safe for concurrent use.
+type Preprocessor struct {
+	NoiseSuppressor *NoiseSuppressor
+	AGC             *AGC
+
+	samples  []int16
+	denoised []int16
+	output   []int16
+}
+
+// NewPreprocessor creates a Preprocessor for 8 kHz audio with both stages enabled,
+// strength and targetLevel are passed to NewNoiseSuppressor and NewAGC.
+func NewPreprocessor(strength float64, targetLevel float64) *Preprocessor {
+	return &Preprocessor{
+		NoiseSuppressor: NewNoiseSuppressor(audioSampleRate, strength),
+		AGC:             NewAGC(audioSampleRate, targetLevel),
+	}
+}
+
+// Latency returns the delay the enabled stages add to the audio.
+func (p *Preprocessor) Latency() time.Duration {
+	var latency time.Duration
+	if p.NoiseSuppressor != nil {
+		latency += p.NoiseSuppressor.Latency()
+	}
+	if p.AGC != nil {
+		latency += p.AGC.Latency()
+	}
+	return latency
+}
+
+// Process runs the enabled stages on src, writing len(src) samples into dst[:0].
+func (p *Preprocessor) Process(dst []int16, src []int16) []int16 {
+	if p.NoiseSuppressor != nil {
+		p.denoised = p.NoiseSuppressor.Process(p.denoised, src)
+		src = p.denoised
+	}
+	if p.AGC != nil {
+		return p.AGC.Process(dst, src)
+	}
+	return append(dst[:0], src...)
+}
+
+// ProcessFrame runs the enabled stages on a frame of 16-bit little-endian audio and returns a new frame.
+func (p *Preprocessor) ProcessFrame(frame []byte) []byte {
+	p.samples = DecodeFrame(p.samples, frame)
+	p.output = p.Process(p.output, p.samples)
+	return Frame(p.output).Bytes(nil)
+}
+
+// Stream launches a new goroutine that preprocesses an audio stream, such as the one returned by StreamAudio.
+// Errors of the input stream are forwarded and end the preprocessing.
+func (p *Preprocessor) Stream(ctx context.Context, stream <-chan AudioResult) <-chan AudioResult {
+	processedStream := make(chan AudioResult)
+
+	go func() {
+		defer close(processedStream)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case audio, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				if audio.Error == nil {
+					audio = AudioResult{Stream: p.ProcessFrame(audio.Stream), Captured: audio.Captured}
+				}
+
+				select {
+				case processedStream <- audio:
+				case <-ctx.Done():
+					return
+				}
+
+				if audio.Error != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return processedStream
+}
@@ -0,0 +1,275 @@
+// Package goEagi of quality.go provides per-call audio quality metrics,
+// so that poor lines can be correlated with recognition failures.
+
+package goEagi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultClipLevel             = 32000
+	defaultZeroRunThreshold      = 40 * time.Millisecond
+	defaultGapThreshold          = 200 * time.Millisecond
+	defaultQualityVariablePrefix = "AUDIO_QUALITY"
+)
+
+// QualitySummary is the audio quality of a call.
+// Levels are in dBFS, ratios are between 0 and 1.
+type QualitySummary struct {
+	Duration        time.Duration
+	Frames          int
+	RMS             float64
+	Peak            float64
+	ClippingRatio   float64
+	NoiseFloor      float64
+	SpeechLevel     float64
+	SNR             float64
+	SpeechRatio     float64
+	SilenceRatio    float64
+	Dropouts        int
+	DropoutDuration time.Duration
+	LongestDropout  time.Duration
+}
+
+// QualityAnalyzer accumulates audio quality metrics of 8 kHz 16-bit mono audio.
+// A dropout is either a run of digital zeros of at least ZeroRunThreshold,
+// or a gap of at least GapThreshold between the arrival of audio and its duration.
+type QualityAnalyzer struct {
+	ClipLevel        int16
+	ZeroRunThreshold time.Duration
+	GapThreshold     time.Duration
+	Vad              *Vad
+
+	mu sync.Mutex
+
+	samples      int64
+	sumSquares   float64
+	peak         int
+	clipped      int64
+	frames       int
+	speech       time.Duration
+	speechPower  float64
+	speechFrames int
+
+	zeroRun         int64
+	dropouts        int
+	dropoutDuration time.Duration
+	longestDropout  time.Duration
+
+	firstArrival time.Time
+	lag          time.Duration
+}
+
+// NewQualityAnalyzer creates a QualityAnalyzer with default thresholds and an adaptive Vad.
+func NewQualityAnalyzer() *QualityAnalyzer {
+	return &QualityAnalyzer{
+		ClipLevel:        defaultClipLevel,
+		ZeroRunThreshold: defaultZeroRunThreshold,
+		GapThreshold:     defaultGapThreshold,
+		Vad:              NewAdaptiveVad(0, 0, 0),
+	}
+}
+
+// Process accumulates the metrics of a frame which arrived now.
+func (q *QualityAnalyzer) Process(frame []byte) error {
+	return q.ProcessAt(frame, time.Now())
+}
+
+// ProcessAt accumulates the metrics of a frame which arrived at the given time.
+func (q *QualityAnalyzer) ProcessAt(frame []byte, arrival time.Time) error {
+	vad, err := q.Vad.Analyze(frame)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.trackArrival(arrival)
+
+	d := frameDuration(len(frame))
+	q.frames++
+	if vad.Detected {
+		q.speech += d
+		q.speechPower += dbToPower(vad.Amplitude - amplitudeOffset)
+		q.speechFrames++
+	}
+
+	zeroRunSamples := int64(q.ZeroRunThreshold * audioSampleRate / time.Second)
+
+	for i := 0; i < len(frame)/audioBytesPerSample; i++ {
+		s := int(sampleAt(frame, i))
+		q.samples++
+		q.sumSquares += float64(s * s)
+
+		if s < 0 {
+			s = -s
+		}
+		if s > q.peak {
+			q.peak = s
+		}
+		if s >= int(q.ClipLevel) {
+			q.clipped++
+		}
+
+		if s == 0 {
+			q.zeroRun++
+			continue
+		}
+		if q.zeroRun >= zeroRunSamples && zeroRunSamples > 0 {
+			q.addDropout(samplesDuration(q.zeroRun))
+		}
+		q.zeroRun = 0
+	}
+
+	return nil
+}
+
+// trackArrival detects gaps in the delivery of the audio, the lag between
+// the wall clock and the audio duration jumps when audio is missing.
+// The lag is followed down as well, so that late frames which are caught up
+// with a burst do not hide a later gap.
+func (q *QualityAnalyzer) trackArrival(arrival time.Time) {
+	if q.firstArrival.IsZero() {
+		q.firstArrival = arrival
+		return
+	}
+
+	lag := arrival.Sub(q.firstArrival) - samplesDuration(q.samples)
+	if lag-q.lag >= q.GapThreshold {
+		q.addDropout(lag - q.lag)
+	}
+	q.lag = lag
+}
+
+func (q *QualityAnalyzer) addDropout(d time.Duration) {
+	q.dropouts++
+	q.dropoutDuration += d
+	if d > q.longestDropout {
+		q.longestDropout = d
+	}
+}
+
+// Monitor launches a new goroutine that analyzes an audio stream, such as the one returned by StreamAudio,
+// and passes every result through unchanged, so that it can be inserted in front of a recognizer or recorder.
+func (q *QualityAnalyzer) Monitor(ctx context.Context, stream <-chan AudioResult) <-chan AudioResult {
+	monitoredStream := make(chan AudioResult)
+
+	go func() {
+		defer close(monitoredStream)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case audio, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				if audio.Error == nil {
+					if err := q.Process(audio.Stream); err != nil {
+						audio = AudioResult{Error: err}
+					}
+				}
+
+				select {
+				case monitoredStream <- audio:
+				case <-ctx.Done():
+					return
+				}
+
+				if audio.Error != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return monitoredStream
+}
+
+// Summary returns the metrics accumulated so far, typically called at hangup.
+func (q *QualityAnalyzer) Summary() QualitySummary {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := QualitySummary{
+		Duration:        samplesDuration(q.samples),
+		Frames:          q.frames,
+		RMS:             math.Inf(-1),
+		Peak:            math.Inf(-1),
+		NoiseFloor:      q.Vad.NoiseFloor() - amplitudeOffset,
+		SpeechLevel:     math.Inf(-1),
+		Dropouts:        q.dropouts,
+		DropoutDuration: q.dropoutDuration,
+		LongestDropout:  q.longestDropout,
+	}
+
+	zeroRunSamples := int64(q.ZeroRunThreshold * audioSampleRate / time.Second)
+	if q.zeroRun >= zeroRunSamples && zeroRunSamples > 0 {
+		d := samplesDuration(q.zeroRun)
+		s.Dropouts++
+		s.DropoutDuration += d
+		if d > s.LongestDropout {
+			s.LongestDropout = d
+		}
+	}
+
+	if q.samples == 0 {
+		return s
+	}
+
+	s.RMS = AmplitudeToDb(math.Sqrt(q.sumSquares/float64(q.samples)) / fullScale)
+	s.Peak = AmplitudeToDb(float64(q.peak) / fullScale)
+	s.ClippingRatio = float64(q.clipped) / float64(q.samples)
+
+	if s.Duration > 0 {
+		s.SpeechRatio = float64(q.speech) / float64(s.Duration)
+		s.SilenceRatio = 1 - s.SpeechRatio
+	}
+
+	if q.speechFrames > 0 {
+		s.SpeechLevel = powerToDb(q.speechPower / float64(q.speechFrames))
+		s.SNR = s.SpeechLevel - s.NoiseFloor
+	}
+
+	return s
+}
+
+// SetChannelVariables writes the summary into channel variables named prefix_METRIC,
+// prefix defaults to AUDIO_QUALITY.
+func (s QualitySummary) SetChannelVariables(eagi *Eagi, prefix string) error {
+	if prefix == "" {
+		prefix = defaultQualityVariablePrefix
+	}
+
+	variables := []struct {
+		name  string
+		value string
+	}{
+		{"DURATION", fmt.Sprintf("%.2f", s.Duration.Seconds())},
+		{"RMS", fmt.Sprintf("%.1f", s.RMS)},
+		{"PEAK", fmt.Sprintf("%.1f", s.Peak)},
+		{"CLIPPING", fmt.Sprintf("%.4f", s.ClippingRatio)},
+		{"NOISE_FLOOR", fmt.Sprintf("%.1f", s.NoiseFloor)},
+		{"SNR", fmt.Sprintf("%.1f", s.SNR)},
+		{"SPEECH_RATIO", fmt.Sprintf("%.3f", s.SpeechRatio)},
+		{"DROPOUTS", fmt.Sprintf("%d", s.Dropouts)},
+		{"DROPOUT_DURATION", fmt.Sprintf("%.2f", s.DropoutDuration.Seconds())},
+	}
+
+	for _, v := range variables {
+		if _, err := eagi.SetVariable(prefix+"_"+v.name, v.value); err != nil {
+			return fmt.Errorf("failed to set %s_%s: %w", prefix, v.name, err)
+		}
+	}
+
+	return nil
+}
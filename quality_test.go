@@ -0,0 +1,174 @@
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestQualityAnalyzerClipping(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	q := NewQualityAnalyzer()
+
+	start := time.Now()
+	for i, f := range noiseFrames(r, 50, 10000) {
+		samples := DecodeFrame(nil, f)
+		// one sample in 16 is clipped on the first 25 frames.
+		if i < 25 {
+			for j := 0; j < len(samples); j += 16 {
+				samples[j] = 32767
+				if j%32 == 0 {
+					samples[j] = -32768
+				}
+			}
+		}
+		if err := q.ProcessAt(samples.Bytes(nil), start.Add(time.Duration(i)*20*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := q.Summary()
+	if want := 1.0 / 32; s.ClippingRatio < want*0.99 || s.ClippingRatio > want*1.01 {
+		t.Fatalf("clipping ratio = %.4f, want %.4f", s.ClippingRatio, want)
+	}
+	if s.Peak != 0 {
+		t.Fatalf("peak = %.2f dBFS, want 0", s.Peak)
+	}
+	if s.Duration != time.Second || s.Frames != 50 {
+		t.Fatalf("duration %v over %d frames, want 1s over 50", s.Duration, s.Frames)
+	}
+}
+
+func TestQualityAnalyzerZeroRuns(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	q := NewQualityAnalyzer()
+
+	zeros := func(d time.Duration) []byte {
+		return make([]byte, int(d.Seconds()*audioSampleRate)*audioBytesPerSample)
+	}
+
+	start := time.Now()
+	var audio [][]byte
+	audio = append(audio, noiseFrames(r, 10, 5000)...)
+	// too short to be a dropout.
+	audio = append(audio, zeros(20*time.Millisecond))
+	audio = append(audio, noiseFrames(r, 10, 5000)...)
+	audio = append(audio, zeros(60*time.Millisecond))
+	audio = append(audio, noiseFrames(r, 10, 5000)...)
+	audio = append(audio, zeros(100*time.Millisecond))
+	audio = append(audio, noiseFrames(r, 10, 5000)...)
+	// a run still going on when the call ends counts as well.
+	audio = append(audio, zeros(50*time.Millisecond))
+
+	var elapsed time.Duration
+	for _, f := range audio {
+		if err := q.ProcessAt(f, start.Add(elapsed)); err != nil {
+			t.Fatal(err)
+		}
+		elapsed += frameDuration(len(f))
+	}
+
+	s := q.Summary()
+	if s.Dropouts != 3 {
+		t.Fatalf("dropouts = %d, want 3", s.Dropouts)
+	}
+	if s.DropoutDuration != 210*time.Millisecond || s.LongestDropout != 100*time.Millisecond {
+		t.Fatalf("dropout duration %v, longest %v, want 210ms and 100ms", s.DropoutDuration, s.LongestDropout)
+	}
+}
+
+func TestQualityAnalyzerGaps(t *testing.T) {
+	r := rand.New(rand.NewSource(6))
+	q := NewQualityAnalyzer()
+
+	start := time.Now()
+	arrival := start
+	for i, f := range noiseFrames(r, 100, 5000) {
+		switch i {
+		case 30:
+			// jitter below GapThreshold.
+			arrival = arrival.Add(150 * time.Millisecond)
+		case 31:
+			// the late frames are caught up, which must not hide the next gap.
+			arrival = arrival.Add(-150 * time.Millisecond)
+		case 60:
+			arrival = arrival.Add(300 * time.Millisecond)
+		}
+
+		if err := q.ProcessAt(f, arrival); err != nil {
+			t.Fatal(err)
+		}
+		arrival = arrival.Add(20 * time.Millisecond)
+	}
+
+	s := q.Summary()
+	if s.Dropouts != 1 || s.DropoutDuration != 300*time.Millisecond {
+		t.Fatalf("got %d dropouts lasting %v, want 1 lasting 300ms", s.Dropouts, s.DropoutDuration)
+	}
+}
+
+func TestQualityStageCaptureTime(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	q := NewQualityAnalyzer()
+
+	// the frames queued at once, a gap of 300 ms in their capture.
+	captured := time.Now()
+	frames := noiseFrames(r, 50, 5000)
+	source := make(chan AudioResult, len(frames))
+	for i, f := range frames {
+		if i == 30 {
+			captured = captured.Add(300 * time.Millisecond)
+		}
+		source <- AudioResult{Stream: f, Captured: captured}
+		captured = captured.Add(20 * time.Millisecond)
+	}
+	close(source)
+
+	// a stage stalling on one frame, which must not be taken as a gap in the audio.
+	p := NewPipeline(source).
+		Stage("slow", StageFunc(func(frame []byte) ([]byte, error) {
+			if bytes.Equal(frame, frames[10]) {
+				time.Sleep(300 * time.Millisecond)
+			}
+			return frame, nil
+		})).
+		Stage("quality", QualityStage(q)).
+		Sink("all", &collector{})
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); err != nil {
+		t.Fatal(err)
+	}
+
+	s := q.Summary()
+	if s.Dropouts != 1 || s.DropoutDuration != 300*time.Millisecond {
+		t.Fatalf("got %d dropouts lasting %v, want the 300ms gap of the capture", s.Dropouts, s.DropoutDuration)
+	}
+}
+
+func TestQualityAnalyzerSpeech(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	q := NewQualityAnalyzer()
+
+	start := time.Now()
+	for i, f := range noiseFrames(r, 200, 300) {
+		// a loud word of 200 ms every second.
+		if i%50 >= 40 {
+			f = noiseFrames(r, 1, 12000)[0]
+		}
+		if err := q.ProcessAt(f, start.Add(time.Duration(i)*20*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := q.Summary()
+	if s.SpeechRatio < 0.15 || s.SpeechRatio > 0.25 {
+		t.Fatalf("speech ratio = %.2f, want about 0.2", s.SpeechRatio)
+	}
+	if s.SNR < 25 || s.SNR > 40 {
+		t.Fatalf("SNR = %.1f dB, want about 32 dB", s.SNR)
+	}
+}
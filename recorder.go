@@ -0,0 +1,342 @@
+// Package goEagi of recorder.go provides a Recorder type, which writes
+// 16-bit mono audio into a wav file frame by frame as it arrives,
+// keeping the header valid on disk so that a crash loses at most
+// the last SyncInterval of audio. Recordings go to the local
+// filesystem or to any Storage.
+
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRecorderSyncInterval = 2 * time.Second
+
+// ErrRecordingLimit is returned by Recorder.Write once MaxDuration or MaxSize is reached and Rotate is off.
+var ErrRecordingLimit = errors.New("recording limit reached")
+
+// Recorder writes a wav file incrementally. The header sizes are patched and the file is synced
+// every SyncInterval of audio and on Close. On an append-only storage, the header keeps
+// an unknown data size, which readers treat as lasting until the end of the file.
+//
+// When a file reaches MaxDuration or MaxSize of samples, either limit being zero for unlimited,
+// the recording continues in a new file if Rotate is set, named after the first one with
+// a -001, -002... suffix, and stops with ErrRecordingLimit otherwise.
+//
+// When Metadata is set, a JSON sidecar based on it is stored next to every file once the file is closed.
+// A Recorder is safe for concurrent use.
+type Recorder struct {
+	MaxDuration  time.Duration
+	MaxSize      int64
+	Rotate       bool
+	SyncInterval time.Duration
+	Metadata     *RecordingMetadata
+
+	mu       sync.Mutex
+	ctx      context.Context
+	storage  Storage
+	path     string
+	header   wavHeader
+	file     StorageWriter
+	opened   time.Time
+	files    []string
+	dataSize int64
+	unsynced int64
+	total    int64
+	partial  []byte
+	closed   bool
+	err      error
+}
+
+// NewRecorder creates the wav file at path, and its directory if needed,
+// for 16-bit mono audio at rate, a zero rate uses 8 kHz.
+func NewRecorder(path string, rate int) (*Recorder, error) {
+	return NewStorageRecorder(context.Background(), &LocalStorage{}, path, rate)
+}
+
+// NewStorageRecorder creates the wav object key in storage for 16-bit mono audio at rate, a zero rate uses 8 kHz.
+// ctx bounds the uploads until Close, so it should outlive the call rather than be cancelled at hangup.
+func NewStorageRecorder(ctx context.Context, storage Storage, key string, rate int) (*Recorder, error) {
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+
+	r := Recorder{
+		SyncInterval: defaultRecorderSyncInterval,
+		ctx:          ctx,
+		storage:      storage,
+		path:         key,
+		header: wavHeader{
+			AudioFormat:   wavFormatPCM,
+			Channels:      audioChannel,
+			SampleRate:    uint32(rate),
+			BitsPerSample: audioBitsPerSample,
+		},
+	}
+
+	if err := r.open(key); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// Write appends a frame of 16-bit little-endian audio, splitting it across files when it crosses a limit.
+// A trailing partial sample is held back until the next frame completes it, so that frames are only
+// ever split on sample boundaries. Once rotating to a new file fails, every later write fails as well.
+func (r *Recorder) Write(frame []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, errors.New("recorder is closed")
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	held := len(r.partial)
+	data := frame
+	if held > 0 {
+		data = append(append(make([]byte, 0, held+len(frame)), r.partial...), frame...)
+	}
+	whole := len(data) - len(data)%int(r.header.blockAlign())
+	r.partial = append(r.partial[:0], data[whole:]...)
+	data = data[:whole]
+
+	written, err := r.write(data)
+	if err != nil {
+		r.partial = r.partial[:0]
+		if written -= held; written < 0 {
+			written = 0
+		}
+		return written, err
+	}
+
+	return len(frame), nil
+}
+
+// write appends whole samples, rotating or stopping at the limits.
+func (r *Recorder) write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		n := len(data)
+		if limit := r.remaining(); limit >= 0 && int64(n) > limit {
+			n = int(limit)
+		}
+
+		if n == 0 {
+			if !r.Rotate {
+				return written, ErrRecordingLimit
+			}
+			if r.dataSize == 0 {
+				// a limit below one sample leaves no room in a new file either.
+				return written, fmt.Errorf("%w: the limits leave no room for a sample", ErrRecordingLimit)
+			}
+			if err := r.rotate(); err != nil {
+				// the current file is closed, or aborted, so nothing can be written anymore.
+				r.err = fmt.Errorf("recorder failed to rotate: %w", err)
+				return written, r.err
+			}
+			continue
+		}
+
+		if _, err := r.file.Write(data[:n]); err != nil {
+			return written, fmt.Errorf("failed to write recording: %w", err)
+		}
+		written += n
+		data = data[n:]
+
+		r.dataSize += int64(n)
+		r.unsynced += int64(n)
+		r.total += int64(n)
+
+		if r.unsynced >= r.bytesOf(r.SyncInterval) {
+			if err := r.sync(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Consume writes every frame until frames is closed or ctx is cancelled, so that a Recorder is a Pipeline Sink.
+// The recorder is not closed.
+func (r *Recorder) Consume(ctx context.Context, frames <-chan []byte) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case frame, ok := <-frames:
+			if !ok {
+				return nil
+			}
+			if _, err := r.Write(frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Record writes an audio stream, such as the one returned by StreamAudio, until it is closed,
+// reports an error or ctx is cancelled. The recorder is not closed.
+func (r *Recorder) Record(ctx context.Context, stream <-chan AudioResult) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case audio, ok := <-stream:
+			if !ok {
+				return nil
+			}
+			if audio.Error != nil {
+				return audio.Error
+			}
+			if _, err := r.Write(audio.Stream); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Duration returns the duration recorded over all files.
+func (r *Recorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return time.Duration(r.total/int64(r.header.blockAlign())) * time.Second / time.Duration(r.header.SampleRate)
+}
+
+// Files returns the paths, or storage keys, of the files written so far, in order.
+func (r *Recorder) Files() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.files...)
+}
+
+// Close patches the header of the current file, syncs and closes it.
+// A held back partial sample is dropped. After a failed rotation, there is no file left
+// to close and the rotation error is returned.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if r.err != nil {
+		return r.err
+	}
+	return r.closeFile()
+}
+
+// remaining returns how many bytes still fit into the current file, -1 when unlimited.
+func (r *Recorder) remaining() int64 {
+	limit := int64(-1)
+	if r.MaxSize > 0 {
+		limit = r.MaxSize
+	}
+	if r.MaxDuration > 0 {
+		if d := r.bytesOf(r.MaxDuration); limit < 0 || d < limit {
+			limit = d
+		}
+	}
+	if limit < 0 {
+		return -1
+	}
+
+	left := limit - r.dataSize
+	if left < 0 {
+		left = 0
+	}
+	// never split a sample.
+	return left - left%int64(r.header.blockAlign())
+}
+
+// bytesOf returns the number of bytes of audio lasting d.
+func (r *Recorder) bytesOf(d time.Duration) int64 {
+	samples := int64(d) * int64(r.header.SampleRate) / int64(time.Second)
+	return samples * int64(r.header.blockAlign())
+}
+
+func (r *Recorder) open(key string) error {
+	file, err := r.storage.Create(r.ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	// until the first sync, and for good with append-only storages, the data lasts until EOF.
+	if err := writeWavHeader(file, r.header, wavUnknownSize); err != nil {
+		file.Abort()
+		return fmt.Errorf("failed to write wav header: %w", err)
+	}
+
+	r.file = file
+	r.opened = time.Now()
+	r.files = append(r.files, key)
+	r.dataSize = 0
+	r.unsynced = 0
+	return nil
+}
+
+// rotate closes the current file and continues in the next one.
+func (r *Recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+
+	extension := filepath.Ext(r.path)
+	next := fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(r.path, extension), len(r.files), extension)
+	return r.open(next)
+}
+
+// sync patches the header with the current sizes and flushes the file to disk.
+func (r *Recorder) sync() error {
+	if _, err := r.file.WriteAt(r.header.encode(uint32(r.dataSize)), 0); err != nil && !errors.Is(err, ErrAppendOnly) {
+		return fmt.Errorf("failed to patch wav header: %w", err)
+	}
+	if err := r.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync recording: %w", err)
+	}
+
+	r.unsynced = 0
+	return nil
+}
+
+// closeFile commits the current file and stores its sidecar.
+func (r *Recorder) closeFile() error {
+	if err := r.sync(); err != nil {
+		r.file.Close()
+		return err
+	}
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close recording: %w", err)
+	}
+
+	if r.Metadata == nil {
+		return nil
+	}
+
+	meta := *r.Metadata
+	meta.Key = r.files[len(r.files)-1]
+	meta.Start = r.opened
+	meta.End = time.Now()
+	meta.Duration = (time.Duration(r.dataSize/int64(r.header.blockAlign())) * time.Second / time.Duration(r.header.SampleRate)).Seconds()
+	meta.Format = "wav"
+	meta.SampleRate = int(r.header.SampleRate)
+	meta.Size = int64(r.header.size()) + r.dataSize
+
+	return WriteMetadata(r.ctx, r.storage, meta.Key, meta)
+}
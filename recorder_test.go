@@ -0,0 +1,180 @@
+package goEagi
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.wav")
+	r, err := NewRecorder(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxDuration = 100 * time.Millisecond
+	r.Rotate = true
+
+	// 250 ms in 20 ms frames.
+	for i := 0; i < 12; i++ {
+		if _, err := r.Write(toneAudio(440, 20*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Write(toneAudio(440, 10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := r.Files()
+	if len(files) != 3 || r.Duration() != 250*time.Millisecond {
+		t.Fatalf("files = %v, duration = %v, want 3 files of 250ms", files, r.Duration())
+	}
+
+	wantFrames := []int{800, 800, 400}
+	for i, f := range files {
+		if frames := len(recordedData(t, f)) / audioBytesPerSample; frames != wantFrames[i] {
+			t.Errorf("%s has %d frames, want %d", f, frames, wantFrames[i])
+		}
+	}
+}
+
+func TestRecorderLimitWithoutRotation(t *testing.T) {
+	r, err := NewRecorder(filepath.Join(t.TempDir(), "call.wav"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.MaxSize = 1000
+
+	n, err := r.Write(make([]byte, 1500))
+	if !errors.Is(err, ErrRecordingLimit) || n != 1000 {
+		t.Fatalf("Write = %d, %v, want 1000, ErrRecordingLimit", n, err)
+	}
+}
+
+func TestRecorderLimitBelowOneSample(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxSize     int64
+		maxDuration time.Duration
+	}{
+		{"size", 1, 0},
+		{"duration", 0, 100 * time.Microsecond},
+	}
+
+	for _, tc := range tests {
+		dir := t.TempDir()
+		r, err := NewRecorder(filepath.Join(dir, "call.wav"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.MaxSize = tc.maxSize
+		r.MaxDuration = tc.maxDuration
+		r.Rotate = true
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := r.Write(make([]byte, 320))
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrRecordingLimit) {
+				t.Errorf("%s: Write error = %v, want ErrRecordingLimit", tc.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: Write keeps rotating", tc.name)
+		}
+		r.Close()
+
+		if entries, _ := os.ReadDir(dir); len(entries) > 1 {
+			t.Errorf("%s: %d files created", tc.name, len(entries))
+		}
+	}
+}
+
+func TestRecorderSplitsOddFramesOnSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.wav")
+	r, err := NewRecorder(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxSize = 1000
+	r.Rotate = true
+
+	// 9 frames of 321 bytes, each byte its position in the stream.
+	var stream []byte
+	for i := 0; i < 9; i++ {
+		frame := make([]byte, 321)
+		for j := range frame {
+			frame[j] = byte(len(stream) + j)
+		}
+		if n, err := r.Write(frame); err != nil || n != len(frame) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+		stream = append(stream, frame...)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded []byte
+	for _, f := range r.Files() {
+		recorded = append(recorded, recordedData(t, f)...)
+	}
+
+	// the last odd byte never got the other half of its sample.
+	if want := stream[:len(stream)-1]; !bytes.Equal(recorded, want) {
+		t.Fatalf("recorded %d bytes differing from the %d bytes written", len(recorded), len(want))
+	}
+}
+
+func TestRecorderRefusesWritesAfterFailedRotation(t *testing.T) {
+	dir := t.TempDir()
+	// a directory in the way of the second file makes the rotation fail.
+	if err := os.Mkdir(filepath.Join(dir, "call-001.wav"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecorder(filepath.Join(dir, "call.wav"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxSize = 1000
+	r.Rotate = true
+
+	n, err := r.Write(make([]byte, 1500))
+	if err == nil || n != 1000 {
+		t.Fatalf("Write across the failing rotation = %d, %v, want 1000 and an error", n, err)
+	}
+
+	if n, err := r.Write(make([]byte, 320)); err == nil || n != 0 {
+		t.Fatalf("Write after the failed rotation = %d, %v, want 0 and an error", n, err)
+	}
+	if err := r.Close(); err == nil {
+		t.Fatal("Close after the failed rotation succeeded")
+	}
+	if files := r.Files(); len(files) != 1 || len(recordedData(t, files[0])) != 1000 {
+		t.Fatalf("files = %v, want the first one holding 1000 bytes", files)
+	}
+}
+
+// recordedData returns the samples of a recorded file, after checking its header matches them.
+func recordedData(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := wavHeader{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 8000, BitsPerSample: 16}
+	if len(data) < header.size() || !bytes.Equal(data[:header.size()], header.encode(uint32(len(data)-header.size()))) {
+		t.Fatalf("%s has no header matching its %d bytes", path, len(data))
+	}
+	return data[header.size():]
+}
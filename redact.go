@@ -0,0 +1,553 @@
+// Package goEagi of redact.go provides a Redactor type, which finds
+// personal data, such as card or social security numbers, in the
+// word-timed transcript of a stored recording, and writes the recording
+// with those spans silenced or covered by a tone, a redaction log and
+// the redacted transcript.
+
+package goEagi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRedactionPadding = 250 * time.Millisecond
+
+	// RedactedWord replaces the words of a redacted span in the redacted transcript.
+	RedactedWord = "[REDACTED]"
+)
+
+var spokenDigits = map[string]string{
+	"zero": "0", "oh": "0", "o": "0",
+	"one": "1", "two": "2", "three": "3", "four": "4",
+	"five": "5", "six": "6", "seven": "7", "eight": "8", "nine": "9",
+}
+
+// PIIDetector finds spans of personal data in the words of a transcript segment.
+type PIIDetector interface {
+	// Name labels the spans of the detector in the redaction log.
+	Name() string
+	// Detect returns the spans found in words, each as the indexes of its first and last word.
+	Detect(words []string) [][2]int
+}
+
+// LuhnDetector finds card numbers: runs of MinDigits to MaxDigits digits passing the Luhn check,
+// written as numbers or spoken as digit words, such as "four one one one".
+type LuhnDetector struct {
+	MinDigits int
+	MaxDigits int
+}
+
+// NewLuhnDetector creates a LuhnDetector for card numbers of 13 to 19 digits.
+func NewLuhnDetector() *LuhnDetector {
+	return &LuhnDetector{MinDigits: 13, MaxDigits: 19}
+}
+
+// Name returns "card".
+func (d *LuhnDetector) Name() string {
+	return "card"
+}
+
+// Detect returns the Luhn-valid digit runs of words.
+func (d *LuhnDetector) Detect(words []string) [][2]int {
+	return detectDigitRuns(words, d.MinDigits, d.MaxDigits, luhnValid)
+}
+
+// SSNDetector finds US social security numbers with a valid area, group and serial: runs of exactly 9 digits,
+// or digits grouped 3-2-4, such as "123-45-6789", within a longer run. The first 9 digits of a phone number
+// are not one.
+type SSNDetector struct{}
+
+// Name returns "ssn".
+func (SSNDetector) Name() string {
+	return "ssn"
+}
+
+// Detect returns the social security numbers of words.
+func (SSNDetector) Detect(words []string) [][2]int {
+	var spans [][2]int
+
+	for _, run := range digitRuns(words) {
+		var digits string
+		// groups are the digits of the run split on separators, word is the index of the word of each.
+		var groups []string
+		var word []int
+		for i := run[0]; i <= run[1]; i++ {
+			for _, g := range digitGroups(words[i]) {
+				digits += g
+				groups = append(groups, g)
+				word = append(word, i)
+			}
+		}
+
+		if len(digits) == 9 {
+			if ssnValid(digits) {
+				spans = append(spans, run)
+			}
+			continue
+		}
+
+		for k := 0; k+2 < len(groups); k++ {
+			// the grouping must start and end at word boundaries.
+			if k > 0 && word[k-1] == word[k] || k+3 < len(groups) && word[k+3] == word[k+2] {
+				continue
+			}
+			if len(groups[k]) == 3 && len(groups[k+1]) == 2 && len(groups[k+2]) == 4 && ssnValid(groups[k]+groups[k+1]+groups[k+2]) {
+				spans = append(spans, [2]int{word[k], word[k+2]})
+				k += 2
+			}
+		}
+	}
+
+	return spans
+}
+
+// ssnValid reports whether 9 digits have a valid area, group and serial.
+func ssnValid(digits string) bool {
+	area, group, serial := digits[:3], digits[3:5], digits[5:]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
+
+// RegexDetector finds the words covered by the matches of Pattern in the words joined by single spaces.
+type RegexDetector struct {
+	Label   string
+	Pattern *regexp.Regexp
+}
+
+// NewRegexDetector compiles pattern into a RegexDetector named name.
+func NewRegexDetector(name string, pattern string) (*RegexDetector, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern of detector %s: %w", name, err)
+	}
+	return &RegexDetector{Label: name, Pattern: re}, nil
+}
+
+// Name returns Label.
+func (d *RegexDetector) Name() string {
+	return d.Label
+}
+
+// Detect returns the words covered by every match.
+func (d *RegexDetector) Detect(words []string) [][2]int {
+	text := strings.Join(words, " ")
+
+	// starts[i] is the offset of words[i] in text.
+	starts := make([]int, len(words))
+	offset := 0
+	for i, w := range words {
+		starts[i] = offset
+		offset += len(w) + 1
+	}
+
+	var spans [][2]int
+	for _, match := range d.Pattern.FindAllStringIndex(text, -1) {
+		if match[0] == match[1] {
+			continue
+		}
+		first := sort.Search(len(starts), func(i int) bool { return starts[i] > match[0] }) - 1
+		last := sort.Search(len(starts), func(i int) bool { return starts[i] >= match[1] }) - 1
+		spans = append(spans, [2]int{first, last})
+	}
+
+	return spans
+}
+
+// Redaction is an entry of the redaction log. It holds no redacted content, only where it was and what found it.
+type Redaction struct {
+	Detectors []string      `json:"detectors"`
+	Segment   int           `json:"segment"`
+	Start     time.Duration `json:"-"`
+	End       time.Duration `json:"-"`
+	Words     int           `json:"words"`
+}
+
+// MarshalJSON writes Start and End in seconds, as the transcript JSON does.
+func (r Redaction) MarshalJSON() ([]byte, error) {
+	type redaction Redaction
+	return json.Marshal(struct {
+		redaction
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+	}{redaction(r), r.Start.Seconds(), r.End.Seconds()})
+}
+
+// Redactor finds spans of personal data with Detectors and redacts them from recordings and transcripts.
+//
+// Mode is SensitiveSilence or SensitiveMarker, and every span of audio is widened by Padding on both sides,
+// as word timings are approximate. RecordingOffset is the transcript time at which the recording starts.
+type Redactor struct {
+	Detectors       []PIIDetector
+	Mode            SensitiveMode
+	Padding         time.Duration
+	RecordingOffset time.Duration
+}
+
+// NewRedactor creates a Redactor silencing card and social security numbers with a padding of 250 ms.
+func NewRedactor() *Redactor {
+	return &Redactor{
+		Detectors: []PIIDetector{NewLuhnDetector(), SSNDetector{}},
+		Mode:      SensitiveSilence,
+		Padding:   defaultRedactionPadding,
+	}
+}
+
+// Redact returns the redactions found in transcript and a copy of transcript whose redacted words are replaced
+// by RedactedWord, a span becoming a single word.
+func (r *Redactor) Redact(transcript *Transcript) (*Transcript, []Redaction) {
+	redacted := &Transcript{
+		Start:              transcript.Start,
+		MaxLineLength:      transcript.MaxLineLength,
+		MaxLines:           transcript.MaxLines,
+		MaxCaptionDuration: transcript.MaxCaptionDuration,
+		MinCaptionDuration: transcript.MinCaptionDuration,
+	}
+
+	var redactions []Redaction
+	for i, s := range transcript.Segments() {
+		words := s.Words
+		if len(words) == 0 {
+			words = spreadWords(s)
+		}
+
+		spans := r.detect(words)
+		if len(spans) == 0 {
+			redacted.Add(s)
+			continue
+		}
+
+		kept := make([]TranscriptWord, 0, len(words))
+		next := 0
+		for _, span := range spans {
+			kept = append(kept, words[next:span.first]...)
+			kept = append(kept, TranscriptWord{
+				Word:  RedactedWord,
+				Start: words[span.first].Start,
+				End:   words[span.last].End,
+			})
+			next = span.last + 1
+
+			redactions = append(redactions, Redaction{
+				Detectors: span.detectors,
+				Segment:   i,
+				Start:     words[span.first].Start,
+				End:       words[span.last].End,
+				Words:     span.last - span.first + 1,
+			})
+		}
+		kept = append(kept, words[next:]...)
+
+		text := make([]string, len(kept))
+		for j, w := range kept {
+			text[j] = w.Word
+		}
+
+		s.Text = strings.Join(text, " ")
+		if len(s.Words) > 0 {
+			s.Words = kept
+		}
+		redacted.Add(s)
+	}
+
+	return redacted, redactions
+}
+
+// RedactAudio silences, or covers with the marker tone, the span of every redaction in samples of a recording at rate.
+func (r *Redactor) RedactAudio(samples Frame, rate int, redactions []Redaction) error {
+	if r.Mode != SensitiveSilence && r.Mode != SensitiveMarker {
+		return errors.New("redaction mode must be SensitiveSilence or SensitiveMarker")
+	}
+
+	index := func(d time.Duration) int {
+		i := int(int64(d-r.RecordingOffset) * int64(rate) / int64(time.Second))
+		if i < 0 {
+			return 0
+		}
+		if i > len(samples) {
+			return len(samples)
+		}
+		return i
+	}
+
+	var phase float64
+	for _, redaction := range redactions {
+		span := samples[index(redaction.Start-r.Padding):index(redaction.End+r.Padding)]
+		if r.Mode == SensitiveMarker {
+			markerTone(span, rate, &phase)
+			continue
+		}
+		for i := range span {
+			span[i] = 0
+		}
+	}
+
+	return nil
+}
+
+// RedactFile redacts the wav recording inputPath, transcribed by transcript, into the 16-bit mono wav outputPath.
+// Next to it, named after it, the redaction log is written with a .redactions.json suffix
+// and the redacted transcript as JSON with a .transcript.json suffix.
+func (r *Redactor) RedactFile(inputPath string, outputPath string, transcript *Transcript) ([]Redaction, error) {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	reader, err := NewWavReader(in)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rate := reader.Info().SampleRate
+
+	redacted, redactions := r.Redact(transcript)
+	if err := r.RedactAudio(samples, rate, redactions); err != nil {
+		return nil, err
+	}
+
+	header := wavHeader{
+		AudioFormat:   wavFormatPCM,
+		Channels:      audioChannel,
+		SampleRate:    uint32(rate),
+		BitsPerSample: audioBitsPerSample,
+	}
+	payload := samples.Bytes(nil)
+
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+
+	err = writeFile(outputPath, func(w io.Writer) error {
+		if err := writeWavHeader(w, header, uint32(len(payload))); err != nil {
+			return err
+		}
+		_, err := w.Write(payload)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write redacted recording: %w", err)
+	}
+
+	err = writeFile(base+".redactions.json", func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Recording  string      `json:"recording"`
+			Redacted   time.Time   `json:"redacted"`
+			Redactions []Redaction `json:"redactions"`
+		}{filepath.Base(inputPath), time.Now().UTC(), append([]Redaction{}, redactions...)})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write redaction log: %w", err)
+	}
+
+	if err := writeFile(base+".transcript.json", redacted.WriteJSON); err != nil {
+		return nil, fmt.Errorf("failed to write redacted transcript: %w", err)
+	}
+
+	return redactions, nil
+}
+
+type piiSpan struct {
+	first     int
+	last      int
+	detectors []string
+}
+
+// detect runs every detector on words and merges the overlapping spans.
+func (r *Redactor) detect(words []TranscriptWord) []piiSpan {
+	text := make([]string, len(words))
+	for i, w := range words {
+		text[i] = w.Word
+	}
+
+	var spans []piiSpan
+	for _, d := range r.Detectors {
+		for _, s := range d.Detect(text) {
+			if s[0] < 0 || s[1] >= len(words) || s[0] > s[1] {
+				continue
+			}
+			spans = append(spans, piiSpan{first: s[0], last: s[1], detectors: []string{d.Name()}})
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].first < spans[j].first })
+
+	var merged []piiSpan
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.first <= merged[n-1].last {
+			m := &merged[n-1]
+			if s.last > m.last {
+				m.last = s.last
+			}
+			for _, name := range s.detectors {
+				if !containsString(m.detectors, name) {
+					m.detectors = append(m.detectors, name)
+				}
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+
+	return merged
+}
+
+// detectDigitRuns returns the longest spans of words holding minDigits to maxDigits digits accepted by valid,
+// starting and ending at word boundaries. Words such as "dash" may sit between the digits of a run.
+func detectDigitRuns(words []string, minDigits int, maxDigits int, valid func(digits string) bool) [][2]int {
+	var spans [][2]int
+
+	for first := 0; first < len(words); first++ {
+		digits, ok := wordDigits(words[first])
+		if !ok || digits == "" {
+			continue
+		}
+
+		match := -1
+		for last := first; last < len(words) && len(digits) <= maxDigits; {
+			if len(digits) >= minDigits && valid(digits) && isDigitWord(words[last]) {
+				match = last
+			}
+
+			last++
+			if last == len(words) {
+				break
+			}
+			more, ok := wordDigits(words[last])
+			if !ok {
+				break
+			}
+			digits += more
+		}
+
+		if match >= 0 {
+			spans = append(spans, [2]int{first, match})
+			first = match
+		}
+	}
+
+	return spans
+}
+
+// digitRuns returns the longest spans of words made of digits and separators which start and end with digits.
+func digitRuns(words []string) [][2]int {
+	var runs [][2]int
+
+	for first := 0; first < len(words); first++ {
+		if !isDigitWord(words[first]) {
+			continue
+		}
+
+		last := first
+		for next := first + 1; next < len(words); next++ {
+			digits, ok := wordDigits(words[next])
+			if !ok {
+				break
+			}
+			if digits != "" {
+				last = next
+			}
+		}
+
+		runs = append(runs, [2]int{first, last})
+		first = last
+	}
+
+	return runs
+}
+
+// digitGroups returns the digits of a word split on its separators, a spoken digit being a group of its own.
+func digitGroups(word string) []string {
+	digits, ok := wordDigits(word)
+	if !ok || digits == "" {
+		return nil
+	}
+	if len(digits) == 1 {
+		return []string{digits}
+	}
+
+	return strings.FieldsFunc(strings.ToLower(word), func(c rune) bool { return c < '0' || c > '9' })
+}
+
+// wordDigits returns the digits of a word made of digits and separators, or of a spoken digit.
+// Separator words have no digits.
+func wordDigits(word string) (string, bool) {
+	w := strings.ToLower(strings.Trim(word, ".,;:!?\"'()"))
+
+	if d, ok := spokenDigits[w]; ok {
+		return d, true
+	}
+	if w == "dash" || w == "hyphen" || w == "-" {
+		return "", true
+	}
+
+	var digits strings.Builder
+	for _, c := range w {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c == '-' || c == '.' || c == '/' || c == ' ':
+		default:
+			return "", false
+		}
+	}
+	return digits.String(), digits.Len() > 0
+}
+
+// isDigitWord reports whether word holds at least a digit.
+func isDigitWord(word string) bool {
+	digits, ok := wordDigits(word)
+	return ok && digits != ""
+}
+
+// luhnValid reports whether digits pass the Luhn check.
+func luhnValid(digits string) bool {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFile creates path and writes it with write, removing it on failure.
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
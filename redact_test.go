@@ -0,0 +1,212 @@
+package goEagi
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPIIDetectors(t *testing.T) {
+	email, err := NewRegexDetector("email", `\S+@\S+\.\w+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	birth, err := NewRegexDetector("birth", `\d{1,2} (january|february|march) \d{4}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		detector PIIDetector
+		text     string
+		want     [][2]int
+	}{
+		{"card as a number", NewLuhnDetector(), "my card is 4111111111111111 thanks", [][2]int{{3, 3}}},
+		{"card in groups", NewLuhnDetector(), "it is 4111 1111 1111 1111", [][2]int{{2, 5}}},
+		{"card with dashes", NewLuhnDetector(), "4111-1111-1111-1111.", [][2]int{{0, 0}}},
+		{"card spoken", NewLuhnDetector(), "four one one one one one one one one one one one one one one one", [][2]int{{0, 15}}},
+		{"card failing the Luhn check", NewLuhnDetector(), "4111 1111 1111 1112", nil},
+		{"card too short", NewLuhnDetector(), "411111111116", nil},
+		{"two cards", NewLuhnDetector(), "4111111111111111 or 5500 0000 0000 0004", [][2]int{{0, 0}, {2, 5}}},
+
+		{"ssn grouped", SSNDetector{}, "my ssn is 123-45-6789", [][2]int{{3, 3}}},
+		{"ssn in words", SSNDetector{}, "123 45 6789 ok", [][2]int{{0, 2}}},
+		{"ssn with dash words", SSNDetector{}, "123 dash 45 dash 6789", [][2]int{{0, 4}}},
+		{"ssn as a number", SSNDetector{}, "it is 123456789", [][2]int{{2, 2}}},
+		{"ssn spoken", SSNDetector{}, "one two three four five six seven eight nine", [][2]int{{0, 8}}},
+		{"ssn grouped within a longer run", SSNDetector{}, "123-45-6789 1", [][2]int{{0, 0}}},
+		{"phone number", SSNDetector{}, "call 555 123 4567", nil},
+		{"phone number as a number", SSNDetector{}, "call 5551234567", nil},
+		{"phone number spoken", SSNDetector{}, "call five five five one two three four five six seven", nil},
+		{"phone number with dashes", SSNDetector{}, "call 555-123-4567 now", nil},
+		{"ssn in a longer run", SSNDetector{}, "1 123456789", nil},
+		{"ssn grouped within a word", SSNDetector{}, "1-123-45-6789", nil},
+		{"ssn with invalid area", SSNDetector{}, "666-45-6789 900-45-6789 000-45-6789", nil},
+		{"ssn with invalid group or serial", SSNDetector{}, "123-00-6789 123-45-0000", nil},
+
+		{"regex within a word", email, "mail me at john@example.com today", [][2]int{{3, 3}}},
+		{"regex across words", birth, "born 3 march 1990 in", [][2]int{{1, 3}}},
+		{"regex without match", birth, "born in march", nil},
+	}
+
+	for _, tt := range tests {
+		got := tt.detector.Detect(strings.Fields(tt.text))
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %s detected %v in %q, want %v", tt.name, tt.detector.Name(), got, tt.text, tt.want)
+		}
+	}
+
+	if _, err := NewRegexDetector("bad", "("); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+// redactionTranscript returns a transcript of a card number read out from 2 s, its words 500 ms long.
+func redactionTranscript() *Transcript {
+	tr := NewTranscript()
+	words := timedWords("my card is 4111 1111 1111 1111 thank you", 500*time.Millisecond, 400*time.Millisecond, 100*time.Millisecond)
+	tr.Add(TranscriptSegment{Source: "google", Start: words[0].Start, End: words[len(words)-1].End, Text: "my card is 4111 1111 1111 1111 thank you", Words: words})
+	tr.Add(TranscriptSegment{Source: "google", Start: 6 * time.Second, End: 7 * time.Second, Text: "goodbye"})
+	return tr
+}
+
+func TestRedactorMergesDetectors(t *testing.T) {
+	groups, err := NewRegexDetector("groups", `\d{4} \d{4} \d{4}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedactor()
+	r.Detectors = append(r.Detectors, groups)
+
+	redacted, redactions := r.Redact(redactionTranscript())
+
+	want := []Redaction{{Detectors: []string{"card", "groups"}, Segment: 0, Start: 2 * time.Second, End: 3900 * time.Millisecond, Words: 4}}
+	if !reflect.DeepEqual(redactions, want) {
+		t.Fatalf("redactions = %+v, want %+v", redactions, want)
+	}
+
+	segments := redacted.Segments()
+	if len(segments) != 2 || segments[0].Text != "my card is [REDACTED] thank you" || segments[1].Text != "goodbye" {
+		t.Fatalf("redacted segments = %+v", segments)
+	}
+	if words := segments[0].Words; len(words) != 6 || words[3].Start != 2*time.Second || words[3].End != 3900*time.Millisecond {
+		t.Fatalf("redacted words = %+v", words)
+	}
+}
+
+func TestRedactFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// a constant signal, so that every silenced sample is told apart.
+	samples := make(Frame, 8*audioSampleRate)
+	for i := range samples {
+		samples[i] = 1000
+	}
+	input, err := GenerateAudio(samples.Bytes(nil), dir, "call.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRedactor()
+	// the recording started 500 ms after the transcript.
+	r.RecordingOffset = 500 * time.Millisecond
+
+	output := filepath.Join(dir, "call-redacted.wav")
+	redactions, err := r.RedactFile(input, output, redactionTranscript())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(redactions) != 1 {
+		t.Fatalf("redactions = %+v, want the card number", redactions)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := NewWavReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redacted, err := reader.ReadAll()
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 s to 3.9 s of the transcript, padded by 250 ms and moved by the offset of the recording.
+	first, last := 1250*audioSampleRate/1000, 3650*audioSampleRate/1000
+	if len(redacted) != len(samples) {
+		t.Fatalf("redacted recording holds %d samples, want %d", len(redacted), len(samples))
+	}
+	for i, s := range redacted {
+		if masked := i >= first && i < last; masked != (s == 0) {
+			t.Fatalf("sample %d is %d, masked samples are %d to %d", i, s, first, last)
+		}
+	}
+
+	var log struct {
+		Recording  string `json:"recording"`
+		Redactions []struct {
+			Detectors []string `json:"detectors"`
+			Segment   int      `json:"segment"`
+			Start     float64  `json:"start"`
+			End       float64  `json:"end"`
+			Words     int      `json:"words"`
+		} `json:"redactions"`
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "call-redacted.redactions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Recording != "call.wav" || len(log.Redactions) != 1 || log.Redactions[0].Start != 2 || log.Redactions[0].End != 3.9 ||
+		log.Redactions[0].Words != 4 || !reflect.DeepEqual(log.Redactions[0].Detectors, []string{"card"}) {
+		t.Fatalf("redaction log = %s", content)
+	}
+	if strings.Contains(string(content), "4111") {
+		t.Fatalf("redaction log holds the card number: %s", content)
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, "call-redacted.transcript.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "4111") || !strings.Contains(string(content), `"text": "my card is [REDACTED] thank you"`) {
+		t.Fatalf("redacted transcript = %s", content)
+	}
+}
+
+func TestRedactAudioMarker(t *testing.T) {
+	samples := make(Frame, 2*audioSampleRate)
+
+	r := NewRedactor()
+	r.Mode = SensitiveMarker
+	r.Padding = 0
+	if err := r.RedactAudio(samples, audioSampleRate, []Redaction{{Start: 500 * time.Millisecond, End: time.Second}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if level := ComputeLevel(samples[4000:8000].Bytes(nil)); level < sensitiveMarkerLevel-4 || level > sensitiveMarkerLevel {
+		t.Errorf("marker level = %.1f dBFS, want about %v peak", level, sensitiveMarkerLevel)
+	}
+	if ComputeLevel(samples[:4000].Bytes(nil)) > -90 || ComputeLevel(samples[8000:].Bytes(nil)) > -90 {
+		t.Error("marker tone outside of the redaction")
+	}
+
+	r.Mode = SensitiveDrop
+	if err := r.RedactAudio(samples, audioSampleRate, nil); err == nil {
+		t.Error("SensitiveDrop accepted")
+	}
+}
@@ -0,0 +1,391 @@
+// Package goEagi of resample.go provides a streaming polyphase
+// resampler for 16-bit mono audio, so that audio can be converted
+// between the rates used by Asterisk and the speech providers
+// (8k, 16k, 24k, 48k, ...).
+
+package goEagi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// ResampleQuality selects the length and window of the anti-aliasing filter,
+// a higher quality gives a sharper cut-off at the cost of CPU and latency.
+type ResampleQuality int
+
+const (
+	ResampleQualityLow ResampleQuality = iota
+	ResampleQualityMedium
+	ResampleQualityHigh
+)
+
+const (
+	maxResampleFactor = 1024
+)
+
+type resampleQualityParams struct {
+	taps    int
+	rolloff float64
+	beta    float64
+}
+
+var resampleQualities = map[ResampleQuality]resampleQualityParams{
+	ResampleQualityLow:    {taps: 8, rolloff: 0.80, beta: 5},
+	ResampleQualityMedium: {taps: 16, rolloff: 0.88, beta: 7},
+	ResampleQualityHigh:   {taps: 32, rolloff: 0.94, beta: 9},
+}
+
+// Resampler converts a stream of 16-bit samples from one rate to another.
+// It keeps the filter history between calls to Process, so consecutive frames
+// are resampled as one continuous signal. A Resampler is not safe for concurrent use.
+type Resampler struct {
+	inRate  int
+	outRate int
+	up      int
+	down    int
+	taps    int
+
+	// filter holds one set of taps per phase, filter[p*taps+k] is applied to the k-th newest input.
+	filter []float32
+	// history is a doubled ring buffer, so that the newest taps inputs are always contiguous.
+	history []float32
+	pos     int
+	phase   int
+}
+
+// NewResampler creates a Resampler converting from inRate to outRate with the given quality.
+func NewResampler(inRate, outRate int, quality ResampleQuality) (*Resampler, error) {
+	if inRate <= 0 || outRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rates: %d -> %d", inRate, outRate)
+	}
+
+	params, ok := resampleQualities[quality]
+	if !ok {
+		return nil, fmt.Errorf("unknown resample quality: %d", quality)
+	}
+
+	g := gcd(inRate, outRate)
+	up, down := outRate/g, inRate/g
+	if up > maxResampleFactor || down > maxResampleFactor {
+		return nil, fmt.Errorf("unsupported resample ratio %d/%d", up, down)
+	}
+
+	taps := params.taps
+	if down > up {
+		// keep the transition band constant relative to the output rate when decimating.
+		taps = int(math.Ceil(float64(taps) * float64(down) / float64(up)))
+	}
+
+	r := &Resampler{
+		inRate:  inRate,
+		outRate: outRate,
+		up:      up,
+		down:    down,
+		taps:    taps,
+		filter:  designPolyphaseFilter(up, down, taps, params),
+		history: make([]float32, 2*taps),
+	}
+	return r, nil
+}
+
+// InputRate returns the sample rate expected by Process.
+func (r *Resampler) InputRate() int {
+	return r.inRate
+}
+
+// OutputRate returns the sample rate produced by Process.
+func (r *Resampler) OutputRate() int {
+	return r.outRate
+}
+
+// Latency returns the group delay introduced by the filter.
+func (r *Resampler) Latency() time.Duration {
+	return time.Duration(r.taps) * time.Second / time.Duration(2*r.inRate)
+}
+
+// MaxOutputLength returns the largest number of samples Process can produce for n input samples,
+// it can be used to size the dst buffer so that Process never allocates.
+func (r *Resampler) MaxOutputLength(n int) int {
+	return (n*r.up)/r.down + 1
+}
+
+// Reset clears the filter history, so that the next Process starts a new signal.
+func (r *Resampler) Reset() {
+	for i := range r.history {
+		r.history[i] = 0
+	}
+	r.pos = 0
+	r.phase = 0
+}
+
+// Process resamples src and appends the result to dst[:0].
+// If dst has at least MaxOutputLength(len(src)) capacity no allocation is made.
+func (r *Resampler) Process(dst []int16, src []int16) []int16 {
+	dst = dst[:0]
+
+	for _, s := range src {
+		r.push(float32(s))
+
+		for r.phase < r.up {
+			dst = append(dst, r.output(r.phase))
+			r.phase += r.down
+		}
+		r.phase -= r.up
+	}
+
+	return dst
+}
+
+// Flush feeds the filter with silence to drain the samples still held in its history,
+// and appends them to dst[:0]. The Resampler is reset afterwards.
+func (r *Resampler) Flush(dst []int16) []int16 {
+	dst = dst[:0]
+
+	for i := 0; i < r.taps/2; i++ {
+		r.push(0)
+
+		for r.phase < r.up {
+			dst = append(dst, r.output(r.phase))
+			r.phase += r.down
+		}
+		r.phase -= r.up
+	}
+
+	r.Reset()
+	return dst
+}
+
+// push adds a new input sample to the history ring.
+func (r *Resampler) push(s float32) {
+	r.pos--
+	if r.pos < 0 {
+		r.pos = r.taps - 1
+	}
+	r.history[r.pos] = s
+	r.history[r.pos+r.taps] = s
+}
+
+// output computes one output sample for the given phase.
+func (r *Resampler) output(phase int) int16 {
+	coefficients := r.filter[phase*r.taps : (phase+1)*r.taps]
+	history := r.history[r.pos : r.pos+r.taps]
+
+	var acc float32
+	for k, c := range coefficients {
+		acc += c * history[k]
+	}
+	return clampInt16(acc)
+}
+
+// delay returns the filter group delay in output samples.
+func (r *Resampler) delay() int {
+	return int(math.Round(float64(r.taps*r.up/2) / float64(r.down)))
+}
+
+// ResampleSamples resamples a complete signal in one go.
+// Unlike Process, the filter delay is compensated, so the output is aligned with the input.
+func ResampleSamples(samples []int16, inRate, outRate int, quality ResampleQuality) ([]int16, error) {
+	if inRate == outRate {
+		return append([]int16(nil), samples...), nil
+	}
+
+	r, err := NewResampler(inRate, outRate, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	out := r.Process(make([]int16, 0, r.MaxOutputLength(len(samples))+r.taps), samples)
+	out = append(out, r.Flush(nil)...)
+
+	delay := r.delay()
+	if delay > len(out) {
+		delay = len(out)
+	}
+
+	expected := len(samples) * outRate / inRate
+	out = out[delay:]
+	if len(out) > expected {
+		out = out[:expected]
+	}
+	return out, nil
+}
+
+// ResampleStream launches a new goroutine that resamples the audio of a stream,
+// such as the one returned by StreamAudio, from inRate to outRate.
+// Errors of the input stream are forwarded and end the resampling.
+func ResampleStream(ctx context.Context, stream <-chan AudioResult, inRate, outRate int, quality ResampleQuality) (<-chan AudioResult, error) {
+	r, err := NewResampler(inRate, outRate, quality)
+	if err != nil {
+		return nil, err
+	}
+
+	resampledStream := make(chan AudioResult)
+
+	go func() {
+		defer close(resampledStream)
+
+		var in, out []int16
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case audio, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				if audio.Error != nil {
+					select {
+					case resampledStream <- audio:
+					case <-ctx.Done():
+					}
+					return
+				}
+
+				in = DecodeFrame(in, audio.Stream)
+				out = r.Process(out, in)
+
+				select {
+				case resampledStream <- AudioResult{Stream: Frame(out).Bytes(nil), Captured: audio.Captured}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return resampledStream, nil
+}
+
+// ResampleWavFile reads a wav file in any format supported by NewWavReader, mixes it down to mono
+// and writes it to outputPath as a 16-bit wav file with outRate sample rate.
+func ResampleWavFile(inputPath string, outputPath string, outRate int, quality ResampleQuality) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open wav file: %w", err)
+	}
+	defer in.Close()
+
+	reader, err := NewWavReader(in)
+	if err != nil {
+		return err
+	}
+
+	raw, err := reader.ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read wav samples: %w", err)
+	}
+
+	samples, err := ResampleSamples(raw, reader.Info().SampleRate, outRate, quality)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create audio path: %w", err)
+	}
+
+	header := wavHeader{
+		AudioFormat:   wavFormatPCM,
+		Channels:      audioChannel,
+		SampleRate:    uint32(outRate),
+		BitsPerSample: audioBitsPerSample,
+	}
+	payload := Frame(samples).Bytes(nil)
+
+	if err := writeWavHeader(out, header, uint32(len(payload))); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write wav header: %w", err)
+	}
+	if _, err := out.Write(payload); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write resampled audio: %w", err)
+	}
+	return out.Close()
+}
+
+// designPolyphaseFilter designs a Kaiser windowed-sinc low-pass filter at the upsampled rate
+// and splits it into up phases of taps coefficients each.
+func designPolyphaseFilter(up, down, taps int, params resampleQualityParams) []float32 {
+	length := up * taps
+	widest := up
+	if down > widest {
+		widest = down
+	}
+	cutoff := params.rolloff * 0.5 / float64(widest)
+	center := float64(length / 2)
+
+	prototype := make([]float64, length)
+	for n := range prototype {
+		x := float64(n) - center
+		prototype[n] = 2 * cutoff * sinc(2*cutoff*x) * kaiser(x, center+1, params.beta)
+	}
+
+	// scale by up to compensate the zeros inserted by the upsampling.
+	var sum float64
+	for _, c := range prototype {
+		sum += c
+	}
+	gain := float64(up) / sum
+
+	filter := make([]float32, length)
+	for p := 0; p < up; p++ {
+		for k := 0; k < taps; k++ {
+			filter[p*taps+k] = float32(prototype[p+k*up] * gain)
+		}
+	}
+	return filter
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser evaluates the Kaiser window of half length m at offset x from its center.
+func kaiser(x, m, beta float64) float64 {
+	r := x / m
+	if r <= -1 || r >= 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-r*r)) / besselI0(beta)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+func clampInt16(v float32) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(math.Round(float64(v)))
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,177 @@
+package goEagi
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sine returns n samples of a sine of frequency at rate with the given peak amplitude.
+func sine(n int, frequency float64, rate int, amplitude float64) []int16 {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(rate)))
+	}
+	return samples
+}
+
+// toneAmplitude returns the peak amplitude of the frequency component of samples,
+// skipping skip samples at both ends to leave out the filter transients.
+func toneAmplitude(samples []int16, frequency float64, rate int, skip int) float64 {
+	samples = samples[skip : len(samples)-skip]
+
+	var re, im float64
+	for i, s := range samples {
+		w := 2 * math.Pi * frequency * float64(i) / float64(rate)
+		re += float64(s) * math.Cos(w)
+		im += float64(s) * math.Sin(w)
+	}
+	return 2 * math.Hypot(re, im) / float64(len(samples))
+}
+
+func TestResamplerFrequencyResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		inRate, outRate int
+		frequency       float64
+	}{
+		{"8k to 16k", 8000, 16000, 1000},
+		{"16k to 8k", 16000, 8000, 1000},
+		{"8k to 48k", 8000, 48000, 2500},
+		{"48k to 8k", 48000, 8000, 2500},
+		{"16k to 24k", 16000, 24000, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := sine(tt.inRate, tt.frequency, tt.inRate, 10000)
+
+			out, err := ResampleSamples(in, tt.inRate, tt.outRate, ResampleQualityMedium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(out) != tt.outRate {
+				t.Fatalf("got %d samples, want %d", len(out), tt.outRate)
+			}
+
+			gain := AmplitudeToDb(toneAmplitude(out, tt.frequency, tt.outRate, tt.outRate/10) / 10000)
+			if math.Abs(gain) > 0.5 {
+				t.Fatalf("passband gain at %.0f Hz = %.2f dB, want 0 ± 0.5", tt.frequency, gain)
+			}
+		})
+	}
+}
+
+func TestResamplerAliasing(t *testing.T) {
+	// A 6 kHz tone does not fit in 8 kHz audio, without filtering it would fold back to 2 kHz.
+	in := sine(16000, 6000, 16000, 10000)
+
+	for quality, limit := range map[ResampleQuality]float64{
+		ResampleQualityLow:    -30,
+		ResampleQualityMedium: -40,
+		ResampleQualityHigh:   -50,
+	} {
+		out, err := ResampleSamples(in, 16000, 8000, quality)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		alias := AmplitudeToDb(toneAmplitude(out, 2000, 8000, 800) / 10000)
+		if alias > limit {
+			t.Errorf("quality %d: alias at 2 kHz = %.1f dB, want below %.0f dB", quality, alias, limit)
+		}
+	}
+}
+
+func TestResamplerStreamingMatchesOneShot(t *testing.T) {
+	in := sine(8000, 440, 8000, 8000)
+	for i := range in {
+		in[i] += int16(i%37) * 50
+	}
+
+	for _, rates := range [][2]int{{8000, 16000}, {16000, 8000}, {8000, 11025}, {44100, 8000}} {
+		whole, err := NewResampler(rates[0], rates[1], ResampleQualityMedium)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := whole.Process(nil, in)
+		want = append(want, whole.Flush(nil)...)
+
+		chunked, err := NewResampler(rates[0], rates[1], ResampleQualityMedium)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got, buf []int16
+		for start, size := 0, 1; start < len(in); start, size = start+size, size%173+1 {
+			end := start + size
+			if end > len(in) {
+				end = len(in)
+			}
+			buf = chunked.Process(buf, in[start:end])
+			got = append(got, buf...)
+		}
+		got = append(got, chunked.Flush(nil)...)
+
+		if len(got) != len(want) {
+			t.Fatalf("%d -> %d: streaming produced %d samples, one-shot %d", rates[0], rates[1], len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%d -> %d: sample %d = %d, want %d", rates[0], rates[1], i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestResamplerProcessDoesNotAllocate(t *testing.T) {
+	r, err := NewResampler(8000, 16000, ResampleQualityHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := sine(160, 440, 8000, 8000)
+	dst := make([]int16, 0, r.MaxOutputLength(len(in)))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		dst = r.Process(dst, in)
+	})
+	if allocs != 0 {
+		t.Fatalf("Process allocated %.0f times per call", allocs)
+	}
+}
+
+func TestResampleWavFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.wav")
+	output := filepath.Join(dir, "out.wav")
+
+	header := wavHeader{AudioFormat: wavFormatPCM, Channels: 1, SampleRate: 8000, BitsPerSample: 16}
+	if err := os.WriteFile(input, wavFile(header, Frame(sine(8000, 1000, 8000, 10000)).Bytes(nil)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResampleWavFile(input, output, 16000, ResampleQualityMedium); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := NewWavReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := r.Info().SampleRate; rate != 16000 {
+		t.Fatalf("sample rate = %d, want 16000", rate)
+	}
+	samples, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 16000 {
+		t.Fatalf("got %d samples, want 16000", len(samples))
+	}
+}
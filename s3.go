@@ -0,0 +1,576 @@
+// Package goEagi of s3.go provides a Storage on S3 or any S3-compatible
+// service such as MinIO. Objects are uploaded with multipart uploads
+// while they are written, requests are signed with AWS Signature
+// Version 4 and transient failures are retried.
+
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3MinPartSize        = 5 << 20
+	defaultS3MaxRetries  = 4
+	defaultS3RetryDelay  = 200 * time.Millisecond
+	defaultS3Timeout     = 2 * time.Minute
+	s3SigningAlgorithm   = "AWS4-HMAC-SHA256"
+	s3Service            = "s3"
+	s3TimeFormat         = "20060102T150405Z"
+	s3DateFormat         = "20060102"
+	defaultS3Region      = "us-east-1"
+	s3UnsignedPayloadSum = "UNSIGNED-PAYLOAD"
+)
+
+// S3Config configures an S3Storage.
+type S3Config struct {
+	// Endpoint is the base URL of the service, such as https://s3.eu-west-1.amazonaws.com or http://localhost:9000.
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PathStyle addresses the bucket in the path instead of the host name, as MinIO expects.
+	PathStyle bool
+	// PartSize is the size of the multipart upload parts, at least and by default 5 MiB.
+	PartSize int
+	// MaxRetries is how often a failed request is retried, with an exponential backoff.
+	MaxRetries int
+	// HTTPClient sends the requests, by default a client with a timeout of 2 minutes per request.
+	// It should have a timeout, a stalled endpoint otherwise hangs the upload and Close forever.
+	HTTPClient *http.Client
+}
+
+// S3Storage stores objects in a bucket of an S3-compatible service.
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Storage creates an S3Storage, zero config values fall back to their defaults.
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("s3 bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = defaultS3Region
+	}
+	if config.PartSize < s3MinPartSize {
+		config.PartSize = s3MinPartSize
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = defaultS3MaxRetries
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultS3Timeout}
+	}
+
+	return &S3Storage{
+		config:   config,
+		endpoint: endpoint,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Create starts a multipart upload of key. Parts are uploaded in the background as soon as they are full.
+// The first part is also kept in memory until Close, so that WriteAt can patch a header, and uploaded
+// again if it was patched. An object smaller than a part is uploaded with a single request.
+func (s *S3Storage) Create(ctx context.Context, key string) (StorageWriter, error) {
+	w := s3Writer{
+		ctx:     ctx,
+		storage: s,
+		key:     key,
+		parts:   make(chan s3Part, 1),
+		done:    make(chan struct{}),
+	}
+	go w.upload()
+
+	return &w, nil
+}
+
+// Open downloads an object.
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes an object.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// do sends a signed request, retrying network errors, throttling and server errors.
+// The response body of a successful request must be closed by the caller.
+func (s *S3Storage) do(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	delay := defaultS3RetryDelay
+
+	for attempt := 0; ; attempt++ {
+		resp, err := s.send(ctx, method, key, query, body)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		retry := err != nil
+		if err == nil {
+			payload, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			err = s3ResponseError(method, key, resp.StatusCode, payload)
+			retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		}
+
+		if !retry || attempt >= s.config.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (s *S3Storage) send(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	s.sign(req, s.now())
+
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 Authorization header to req,
+// signing the host and every header already set.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(s3TimeFormat)
+	scope := strings.Join([]string{now.Format(s3DateFormat), s.config.Region, s3Service, "aws4_request"}, "/")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = s3UnsignedPayloadSum
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3SigningAlgorithm, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := s3HMAC([]byte("AWS4"+s.config.SecretAccessKey), now.Format(s3DateFormat))
+	key = s3HMAC(key, s.config.Region)
+	key = s3HMAC(key, s3Service)
+	key = s3HMAC(key, "aws4_request")
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningAlgorithm, s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes every path segment as SigV4 expects, keeping the slashes.
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3CanonicalQuery encodes query with sorted keys, an empty value keeps its '='.
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of RFC 3986.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Error is the error document returned by S3.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func s3ResponseError(method string, key string, status int, payload []byte) error {
+	var e s3Error
+	if xml.Unmarshal(payload, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %d %s: %s", method, key, status, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s %s: %d %s", method, key, status, http.StatusText(status))
+}
+
+type s3Part struct {
+	number int
+	data   []byte
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3Writer uploads an object while it is written. Every full part is handed to a background uploader,
+// at most one part waits while another one is uploading. The first part also stays in memory, so that
+// WriteAt can rewrite it, and is uploaded again on Close when it was rewritten after its upload.
+type s3Writer struct {
+	ctx     context.Context
+	storage *S3Storage
+	key     string
+
+	first      []byte
+	current    []byte
+	next       int
+	size       int64
+	closed     bool
+	firstDirty bool
+
+	uploadID  string
+	completed []s3CompletedPart
+	parts     chan s3Part
+	done      chan struct{}
+	err       error
+}
+
+// Write appends p to the object, it fails once a background upload has failed.
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("s3 object is closed")
+	}
+	if err := w.uploadErr(); err != nil {
+		return 0, err
+	}
+
+	n := len(p)
+	partSize := w.storage.config.PartSize
+
+	for len(p) > 0 {
+		if w.next == 0 {
+			free := partSize - len(w.first)
+			if free > len(p) {
+				free = len(p)
+			}
+			w.first = append(w.first, p[:free]...)
+			p = p[free:]
+			if len(w.first) == partSize {
+				// the uploader gets a copy, WriteAt may still rewrite the first part.
+				if err := w.queue(s3Part{number: 1, data: append([]byte(nil), w.first...)}); err != nil {
+					return n - len(p), err
+				}
+				w.next = 2
+			}
+			continue
+		}
+
+		free := partSize - len(w.current)
+		if free > len(p) {
+			free = len(p)
+		}
+		w.current = append(w.current, p[:free]...)
+		p = p[free:]
+
+		if len(w.current) == partSize {
+			if err := w.flushPart(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+
+	w.size += int64(n)
+	return n, nil
+}
+
+// WriteAt rewrites bytes of the first part, which is still in memory.
+func (w *s3Writer) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(w.first)) {
+		return 0, errors.New("s3 object can only be rewritten within its first part")
+	}
+	if w.next != 0 {
+		w.firstDirty = true
+	}
+	return copy(w.first[off:], p), nil
+}
+
+// Sync is a no-op, parts are uploaded as soon as they are full.
+func (w *s3Writer) Sync() error {
+	return w.uploadErr()
+}
+
+// Close uploads the last part, and the first one again if it was rewritten, and completes the upload.
+func (w *s3Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.next == 0 {
+		close(w.parts)
+		<-w.done
+
+		resp, err := w.storage.do(w.ctx, http.MethodPut, w.key, nil, w.first)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if len(w.current) > 0 {
+		if err := w.flushPart(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	if w.firstDirty {
+		if err := w.queue(s3Part{number: 1, data: w.first}); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	close(w.parts)
+	<-w.done
+
+	if w.err != nil {
+		w.abortUpload()
+		return w.err
+	}
+
+	// a part uploaded again replaces the earlier upload.
+	latest := map[int]s3CompletedPart{}
+	for _, part := range w.completed {
+		latest[part.PartNumber] = part
+	}
+	completed := make([]s3CompletedPart, 0, len(latest))
+	for _, part := range latest {
+		completed = append(completed, part)
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i].PartNumber < completed[j].PartNumber })
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: completed})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.storage.do(w.ctx, http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, body)
+	if err != nil {
+		w.abortUpload()
+		return err
+	}
+	defer resp.Body.Close()
+
+	// an error of CompleteMultipartUpload may come with a 200 status.
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(payload, []byte("<Error>")) {
+		w.abortUpload()
+		return s3ResponseError(http.MethodPost, w.key, resp.StatusCode, payload)
+	}
+
+	w.uploadID = ""
+	return nil
+}
+
+// Abort stops the upload and discards the parts uploaded so far.
+func (w *s3Writer) Abort() error {
+	if !w.closed {
+		w.closed = true
+		close(w.parts)
+	}
+	<-w.done
+
+	return w.abortUpload()
+}
+
+func (w *s3Writer) abortUpload() error {
+	if w.uploadID == "" {
+		return nil
+	}
+
+	resp, err := w.storage.do(w.ctx, http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil)
+	w.uploadID = ""
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// flushPart hands the current part to the uploader, blocking while the previous one is queued.
+func (w *s3Writer) flushPart() error {
+	if err := w.queue(s3Part{number: w.next, data: w.current}); err != nil {
+		return err
+	}
+
+	w.next++
+	w.current = make([]byte, 0, w.storage.config.PartSize)
+	return nil
+}
+
+// queue hands a part to the uploader, it returns the upload error if the uploader has stopped.
+func (w *s3Writer) queue(part s3Part) error {
+	select {
+	case w.parts <- part:
+		return nil
+	case <-w.done:
+		return w.err
+	}
+}
+
+func (w *s3Writer) uploadErr() error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// upload creates the multipart upload on the first part and uploads every part it receives.
+func (w *s3Writer) upload() {
+	defer close(w.done)
+
+	for part := range w.parts {
+		if w.uploadID == "" {
+			id, err := w.createUpload()
+			if err != nil {
+				w.err = err
+				return
+			}
+			w.uploadID = id
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(part.number)}, "uploadId": {w.uploadID}}
+		resp, err := w.storage.do(w.ctx, http.MethodPut, w.key, query, part.data)
+		if err != nil {
+			w.err = err
+			return
+		}
+		resp.Body.Close()
+
+		w.completed = append(w.completed, s3CompletedPart{PartNumber: part.number, ETag: resp.Header.Get("ETag")})
+	}
+}
+
+func (w *s3Writer) createUpload() (string, error) {
+	resp, err := w.storage.do(w.ctx, http.MethodPost, w.key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode multipart upload: %w", err)
+	}
+	if result.UploadID == "" {
+		return "", errors.New("s3 returned no upload id")
+	}
+	return result.UploadID, nil
+}
@@ -0,0 +1,403 @@
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory S3 service with path style addressing, enough for S3Storage.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte
+	requests  []string
+	failPart  int
+	partDelay time.Duration
+	nextID    int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3SigningAlgorithm+" ") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	query := r.URL.Query()
+	key := r.URL.Path
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.requests = append(f.requests, "create")
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+
+	case r.Method == http.MethodPut && uploadID != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.requests = append(f.requests, fmt.Sprintf("part %d", number))
+		if f.partDelay > 0 {
+			f.mu.Unlock()
+			time.Sleep(f.partDelay)
+			f.mu.Lock()
+		}
+		if number == f.failPart {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<Error><Code>InvalidPart</Code><Message>rejected</Message></Error>")
+			return
+		}
+		f.uploads[uploadID][number] = body
+		w.Header().Set("ETag", fmt.Sprintf("%q", fmt.Sprintf("etag-%d-%d", number, len(body))))
+
+	case r.Method == http.MethodPost && uploadID != "":
+		f.requests = append(f.requests, "complete")
+		var complete struct {
+			Parts []s3CompletedPart `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 {
+				fmt.Fprint(w, "<Error><Code>InvalidPartOrder</Code><Message>parts out of order</Message></Error>")
+				return
+			}
+			object = append(object, f.uploads[uploadID][part.PartNumber]...)
+		}
+		f.objects[key] = object
+		delete(f.uploads, uploadID)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == http.MethodDelete && uploadID != "":
+		f.requests = append(f.requests, "abort")
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		f.requests = append(f.requests, "put")
+		f.objects[key] = body
+
+	case r.Method == http.MethodGet:
+		object, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+			return
+		}
+		w.Write(object)
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unsupported request", http.StatusMethodNotAllowed)
+	}
+}
+
+// waitFor reports whether request is received within timeout.
+func (f *fakeS3) waitFor(request string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		f.mu.Lock()
+		for _, r := range f.requests {
+			if r == request {
+				f.mu.Unlock()
+				return true
+			}
+		}
+		f.mu.Unlock()
+	}
+	return false
+}
+
+func newTestS3Storage(t *testing.T, fake *fakeS3) *S3Storage {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3Storage(S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "recordings",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		MaxRetries:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func patternBytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func TestS3StorageSinglePut(t *testing.T) {
+	fake := newFakeS3()
+	s := newTestS3Storage(t, fake)
+	ctx := context.Background()
+
+	w, err := s.Create(ctx, "calls/short.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hello, world"))
+	if _, err := w.WriteAt([]byte("H"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(fake.requests, ",") != "put" {
+		t.Errorf("requests = %v, want a single put", fake.requests)
+	}
+
+	r, err := s.Open(ctx, "calls/short.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "Hello, world" {
+		t.Errorf("object = %q", got)
+	}
+
+	if err := s.Delete(ctx, "calls/short.wav"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open(ctx, "calls/short.wav"); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("Open of a deleted object: %v", err)
+	}
+}
+
+func TestS3StorageMultipart(t *testing.T) {
+	fake := newFakeS3()
+	s := newTestS3Storage(t, fake)
+
+	w, err := s.Create(context.Background(), "calls/long.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := patternBytes(2*s3MinPartSize + 12345)
+	for start := 0; start < len(want); start += 100000 {
+		end := start + 100000
+		if end > len(want) {
+			end = len(want)
+		}
+		if _, err := w.Write(want[start:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first part is uploaded as soon as it is full, before Close.
+	if !fake.waitFor("part 1", time.Second) {
+		t.Error("first part was not uploaded before Close")
+	}
+
+	copy(want, "RIFF")
+	if _, err := w.WriteAt([]byte("RIFF"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteAt([]byte("x"), s3MinPartSize); err == nil {
+		t.Error("WriteAt beyond the first part succeeded")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := fake.objects["/recordings/calls/long.wav"]
+	if !bytes.Equal(got, want) {
+		t.Fatalf("object of %d bytes differs from the %d written", len(got), len(want))
+	}
+
+	requests := append([]string(nil), fake.requests...)
+	sort.Strings(requests)
+	if strings.Join(requests, ",") != "complete,create,part 1,part 1,part 2,part 3" {
+		t.Errorf("requests = %v", fake.requests)
+	}
+}
+
+func TestS3StoragePartFailure(t *testing.T) {
+	fake := newFakeS3()
+	fake.failPart = 2
+	s := newTestS3Storage(t, fake)
+
+	w, err := s.Create(context.Background(), "calls/failed.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes go on while part 2 is failing, at most one part queued behind it.
+	data := patternBytes(s3MinPartSize)
+	var writeErr error
+	for i := 0; i < 4 && writeErr == nil; i++ {
+		_, writeErr = w.Write(data)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Close() }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "InvalidPart") {
+			t.Fatalf("Close error = %v, want the part failure", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked after a part failure")
+	}
+
+	if _, ok := fake.objects["/recordings/calls/failed.wav"]; ok {
+		t.Error("failed upload was completed")
+	}
+	if len(fake.uploads) != 0 || fake.requests[len(fake.requests)-1] != "abort" {
+		t.Errorf("failed upload was not aborted: requests = %v", fake.requests)
+	}
+}
+
+func TestS3StorageFailureWithPartQueued(t *testing.T) {
+	fake := newFakeS3()
+	fake.failPart = 2
+	fake.partDelay = 100 * time.Millisecond
+	s := newTestS3Storage(t, fake)
+
+	w, err := s.Create(context.Background(), "calls/queued.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// parts 1 to 3 full, part 3 queued while 2 fails, nothing left in the current part.
+	data := patternBytes(s3MinPartSize)
+	for i := 0; i < 3; i++ {
+		if _, err := w.Write(data); err != nil {
+			break
+		}
+	}
+	w.WriteAt([]byte("RIFF"), 0)
+
+	done := make(chan error, 1)
+	go func() { done <- w.Close() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Close succeeded after a part failure")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked resending the first part after a part failure")
+	}
+}
+
+func TestS3StorageRecorder(t *testing.T) {
+	fake := newFakeS3()
+	s := newTestS3Storage(t, fake)
+
+	r, err := NewStorageRecorder(context.Background(), s, "calls/recorded.wav", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := toneAudio(440, time.Second)
+	if _, err := r.Write(audio); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	object, err := s.Open(context.Background(), "calls/recorded.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+
+	reader, err := NewWavReader(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames := reader.Info().Frames; frames != audioSampleRate {
+		t.Errorf("recording header has %d frames, want %d", frames, audioSampleRate)
+	}
+	samples, err := reader.ReadAll()
+	if err != nil || !bytes.Equal(samples.Bytes(nil), audio) {
+		t.Errorf("recording differs from the audio written: %v", err)
+	}
+}
+
+func TestS3StorageStalledEndpoint(t *testing.T) {
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	// the handler has to return before the server can be closed.
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(stalled) })
+
+	s, err := NewS3Storage(S3Config{
+		Endpoint:   server.URL,
+		Bucket:     "recordings",
+		PathStyle:  true,
+		MaxRetries: 1,
+		HTTPClient: &http.Client{Timeout: 100 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := s.Create(context.Background(), "calls/stalled.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(patternBytes(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- w.Close() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Close succeeded against a stalled endpoint")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a stalled endpoint")
+	}
+}
+
+func TestS3StorageDefaultClientHasTimeout(t *testing.T) {
+	s, err := NewS3Storage(S3Config{Endpoint: "http://localhost:9000", Bucket: "recordings"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.client == http.DefaultClient || s.client.Timeout != defaultS3Timeout {
+		t.Fatalf("default client timeout = %v, want %v", s.client.Timeout, defaultS3Timeout)
+	}
+}
@@ -0,0 +1,257 @@
+// Package goEagi of sensitive.go provides a SensitiveGuard type, which
+// keeps sensitive caller input, such as card numbers read out or keyed
+// in, out of recordings, speech recognizers and DTMF results while a
+// sensitive section is active, and keeps an audit trail of the sections.
+
+package goEagi
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSensitiveHold = time.Second
+
+	sensitiveMarkerFrequency = 1000
+	sensitiveMarkerLevel     = -30.0
+)
+
+// SensitiveMode chooses what the audio of a sensitive section is replaced by.
+type SensitiveMode int
+
+const (
+	// SensitiveSilence replaces the frames with silence of the same length, which keeps recordings
+	// in time and recognizer streams alive.
+	SensitiveSilence SensitiveMode = iota
+	// SensitiveMarker replaces the frames with a quiet 1 kHz tone, marking the section in recordings.
+	SensitiveMarker
+	// SensitiveDrop does not pass the frames on at all.
+	SensitiveDrop
+)
+
+// SensitiveInterval is an entry of the audit trail of a SensitiveGuard.
+// End is zero while the section is active, and includes the Hold of the guard once ended.
+type SensitiveInterval struct {
+	Reason string
+	Start  time.Time
+	End    time.Time
+}
+
+// SensitiveGuard masks audio and digits captured while a sensitive section is active. Sections may overlap,
+// the audio is masked while any of them is active and for Hold after the last one ends, a margin for
+// the caller still talking. Frames and digits are masked according to when they were captured,
+// not when they are processed, so that the ones still queued when a section ends are masked too.
+// Only those whose capture time is unknown are masked according to the time they are processed.
+//
+// Place the Stage of the guard, or Mask, before every recorder and recognizer, and wrap DTMF callbacks with MaskDTMF.
+// Audit, when set, is called when a section begins and when it ends.
+// A SensitiveGuard is safe for concurrent use.
+type SensitiveGuard struct {
+	Hold       time.Duration
+	SampleRate int
+	Audit      func(SensitiveInterval)
+
+	mu        sync.Mutex
+	open      int
+	until     time.Time
+	intervals []SensitiveInterval
+}
+
+// NewSensitiveGuard creates a SensitiveGuard for 8 kHz audio with a hold of 1 second.
+func NewSensitiveGuard() *SensitiveGuard {
+	return &SensitiveGuard{
+		Hold:       defaultSensitiveHold,
+		SampleRate: audioSampleRate,
+	}
+}
+
+// Begin starts a sensitive section and returns the function ending it, which may be called more than once.
+func (g *SensitiveGuard) Begin(reason string) (end func()) {
+	g.mu.Lock()
+	g.open++
+	index := len(g.intervals)
+	g.intervals = append(g.intervals, SensitiveInterval{Reason: reason, Start: time.Now()})
+	interval := g.intervals[index]
+	g.mu.Unlock()
+
+	g.audit(interval)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { g.end(index) })
+	}
+}
+
+// Active reports whether audio and digits are masked right now.
+func (g *SensitiveGuard) Active() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.open > 0 || time.Now().Before(g.until)
+}
+
+// ActiveAt reports whether audio and digits captured at t are masked.
+func (g *SensitiveGuard) ActiveAt(t time.Time) bool {
+	return g.activeBetween(t, t)
+}
+
+// activeBetween reports whether any section covers part of the time from start to end, both included.
+func (g *SensitiveGuard) activeBetween(start, end time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, interval := range g.intervals {
+		if !end.Before(interval.Start) && (interval.End.IsZero() || start.Before(interval.End)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Intervals returns the audit trail of the sections so far, in the order they began.
+func (g *SensitiveGuard) Intervals() []SensitiveInterval {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]SensitiveInterval(nil), g.intervals...)
+}
+
+// Stage returns a pipeline Stage replacing the frames captured while the guard is active according to mode.
+func (g *SensitiveGuard) Stage(mode SensitiveMode) Stage {
+	return &sensitiveStage{g: g, mode: mode}
+}
+
+type sensitiveStage struct {
+	g     *SensitiveGuard
+	mode  SensitiveMode
+	phase float64
+}
+
+func (s *sensitiveStage) Process(frame []byte) ([]byte, error) {
+	return s.ProcessAt(frame, time.Now())
+}
+
+func (s *sensitiveStage) ProcessAt(frame []byte, captured time.Time) ([]byte, error) {
+	if !s.g.ActiveAt(captured) {
+		return frame, nil
+	}
+	return s.g.mask(frame, s.mode, &s.phase), nil
+}
+
+// Mask passes an audio stream, such as the one returned by StreamAudio, on with the frames captured while
+// the guard is active replaced according to mode. Errors are passed on unchanged.
+func (g *SensitiveGuard) Mask(ctx context.Context, stream <-chan AudioResult, mode SensitiveMode) <-chan AudioResult {
+	maskedStream := make(chan AudioResult)
+
+	go func() {
+		defer close(maskedStream)
+
+		var phase float64
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case audio, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				captured := audio.Captured
+				if captured.IsZero() {
+					captured = time.Now()
+				}
+
+				if audio.Error == nil && g.ActiveAt(captured) {
+					audio.Stream = g.mask(audio.Stream, mode, &phase)
+					if len(audio.Stream) == 0 {
+						continue
+					}
+				}
+
+				select {
+				case maskedStream <- audio:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return maskedStream
+}
+
+// MaskDTMF wraps a DTMF callback, such as the one of DTMFStage, so that it does not receive the digits
+// keyed in while the guard is active, according to their Time. They are dropped rather than replaced,
+// as one event per key press would still reveal how many digits were keyed in and how they were grouped.
+// Errors are passed on.
+func (g *SensitiveGuard) MaskDTMF(fn func(DTMFResult)) func(DTMFResult) {
+	return func(r DTMFResult) {
+		if r.Error == nil && g.digitActive(r) {
+			return
+		}
+		fn(r)
+	}
+}
+
+// digitActive reports whether any part of the digit was keyed in while the guard was active.
+func (g *SensitiveGuard) digitActive(r DTMFResult) bool {
+	if r.Time.IsZero() {
+		return g.Active()
+	}
+	return g.activeBetween(r.Time, r.Time.Add(r.Duration))
+}
+
+func (g *SensitiveGuard) end(index int) {
+	g.mu.Lock()
+	g.open--
+	until := time.Now().Add(g.Hold)
+	if until.After(g.until) {
+		g.until = until
+	}
+	g.intervals[index].End = until
+	interval := g.intervals[index]
+	g.mu.Unlock()
+
+	g.audit(interval)
+}
+
+func (g *SensitiveGuard) audit(interval SensitiveInterval) {
+	if g.Audit != nil {
+		g.Audit(interval)
+	}
+}
+
+// mask returns the replacement of frame, phase carries the marker tone across frames.
+func (g *SensitiveGuard) mask(frame []byte, mode SensitiveMode, phase *float64) []byte {
+	switch mode {
+	case SensitiveDrop:
+		return nil
+
+	case SensitiveMarker:
+		samples := make(Frame, len(frame)/audioBytesPerSample)
+		markerTone(samples, g.SampleRate, phase)
+		return samples.Bytes(nil)
+
+	default:
+		return make([]byte, len(frame))
+	}
+}
+
+// markerTone fills samples with the quiet 1 kHz marker tone at rate, phase carries the tone across calls.
+func markerTone(samples Frame, rate int, phase *float64) {
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+	amplitude := math.Pow(10, sensitiveMarkerLevel/20) * fullScale
+	step := 2 * math.Pi * sensitiveMarkerFrequency / float64(rate)
+
+	for i := range samples {
+		samples[i] = int16(amplitude * math.Sin(*phase))
+		*phase = math.Mod(*phase+step, 2*math.Pi)
+	}
+}
@@ -0,0 +1,183 @@
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSensitiveGuardMasksAudio(t *testing.T) {
+	g := NewSensitiveGuard()
+	g.Hold = 50 * time.Millisecond
+
+	var audited []SensitiveInterval
+	g.Audit = func(i SensitiveInterval) { audited = append(audited, i) }
+
+	speech := toneAudio(440, 20*time.Millisecond)
+	silence := g.Stage(SensitiveSilence)
+	drop := g.Stage(SensitiveDrop)
+
+	if out, _ := silence.Process(speech); string(out) != string(speech) {
+		t.Fatal("audio masked outside a sensitive section")
+	}
+
+	end := g.Begin("card")
+	out, _ := silence.Process(speech)
+	if len(out) != len(speech) || ComputeLevel(out) > -90 {
+		t.Errorf("sensitive audio replaced by %d bytes at %v dBFS, want silence of the same length", len(out), ComputeLevel(out))
+	}
+	if out, _ := drop.Process(speech); len(out) != 0 {
+		t.Errorf("dropped sensitive audio passed on %d bytes", len(out))
+	}
+
+	end()
+	end()
+	if !g.Active() {
+		t.Error("guard inactive during its hold")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if g.Active() {
+		t.Error("guard active after its hold")
+	}
+
+	intervals := g.Intervals()
+	if len(intervals) != 1 || intervals[0].Reason != "card" || intervals[0].End.IsZero() || len(audited) != 2 {
+		t.Errorf("intervals = %+v, audited %d times", intervals, len(audited))
+	}
+}
+
+func TestSensitiveGuardMaskMarker(t *testing.T) {
+	g := NewSensitiveGuard()
+	defer g.Begin("card")()
+
+	out, _ := g.Stage(SensitiveMarker).Process(toneAudio(440, 20*time.Millisecond))
+	if level := ComputeLevel(out); level < sensitiveMarkerLevel-4 || level > sensitiveMarkerLevel {
+		t.Errorf("marker level = %v dBFS, want about %v peak", level, sensitiveMarkerLevel)
+	}
+}
+
+func TestSensitiveGuardDropsDTMF(t *testing.T) {
+	g := NewSensitiveGuard()
+	g.Hold = 0
+
+	var got []DTMFResult
+	fn := g.MaskDTMF(func(r DTMFResult) { got = append(got, r) })
+
+	fn(DTMFResult{Digit: '1'})
+	end := g.Begin("card")
+	for _, d := range "4111111111111111" {
+		fn(DTMFResult{Digit: d})
+	}
+	errDetector := errors.New("detector failed")
+	fn(DTMFResult{Error: errDetector})
+	end()
+	fn(DTMFResult{Digit: '#'})
+
+	if len(got) != 3 || got[0].Digit != '1' || got[1].Error != errDetector || got[2].Digit != '#' {
+		t.Fatalf("callback received %+v, want 1, the error and #", got)
+	}
+}
+
+func TestSensitiveGuardMasksQueuedFrames(t *testing.T) {
+	g := NewSensitiveGuard()
+	g.Hold = 0
+
+	// the section ends before any of its frames is processed, as when they are queued behind a slow stage.
+	g.Begin("card")()
+	section := g.Intervals()[0]
+
+	captures := []struct {
+		name     string
+		captured time.Time
+		masked   bool
+	}{
+		{"before", section.Start.Add(-time.Millisecond), false},
+		{"at the start", section.Start, true},
+		{"within", section.End.Add(-time.Nanosecond), true},
+		{"at the end", section.End, false},
+		{"unknown", time.Time{}, false},
+	}
+
+	speech := toneAudio(440, 20*time.Millisecond)
+	source := make(chan AudioResult, len(captures))
+	for _, c := range captures {
+		source <- AudioResult{Stream: speech, Captured: c.captured}
+	}
+	close(source)
+
+	var masked []bool
+	for audio := range g.Mask(context.Background(), source, SensitiveSilence) {
+		masked = append(masked, ComputeLevel(audio.Stream) < -90)
+	}
+
+	stage := g.Stage(SensitiveSilence).(TimedStage)
+	for i, c := range captures {
+		if masked[i] != c.masked {
+			t.Errorf("Mask: frame captured %s masked %v, want %v", c.name, masked[i], c.masked)
+		}
+		if c.captured.IsZero() {
+			continue
+		}
+		if out, _ := stage.ProcessAt(speech, c.captured); (ComputeLevel(out) < -90) != c.masked {
+			t.Errorf("Stage: frame captured %s masked %v, want %v", c.name, !c.masked, c.masked)
+		}
+	}
+}
+
+func TestSensitiveGuardPipelineCaptureTime(t *testing.T) {
+	g := NewSensitiveGuard()
+	g.Hold = 0
+	g.Begin("card")()
+	section := g.Intervals()[0]
+
+	// frames 2 and 3 were captured within the section.
+	source := make(chan AudioResult, 6)
+	for i := 0; i < 6; i++ {
+		captured := section.Start.Add(time.Duration(i-2) * time.Millisecond)
+		if i == 3 {
+			captured = section.End.Add(-time.Nanosecond)
+		}
+		if i > 3 {
+			captured = section.End.Add(time.Duration(i) * time.Millisecond)
+		}
+		source <- AudioResult{Stream: []byte{byte(i + 1), 0}, Captured: captured}
+	}
+	close(source)
+
+	var all collector
+	p := NewPipeline(source).Stage("mask", g.Stage(SensitiveSilence)).Sink("all", &all)
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := all.frames, []byte{1, 0, 2, 0, 0, 0, 0, 0, 5, 0, 6, 0}; !bytes.Equal(got, want) {
+		t.Fatalf("pipeline passed %v, want %v", got, want)
+	}
+}
+
+func TestSensitiveGuardDropsQueuedDTMF(t *testing.T) {
+	g := NewSensitiveGuard()
+	g.Hold = 0
+	g.Begin("card")()
+	section := g.Intervals()[0]
+
+	var got []DTMFResult
+	fn := g.MaskDTMF(func(r DTMFResult) { got = append(got, r) })
+
+	duration := 60 * time.Millisecond
+	fn(DTMFResult{Digit: '1', Time: section.Start.Add(-time.Second), Duration: duration})
+	// keyed in before the section ended, detected after.
+	fn(DTMFResult{Digit: '4', Time: section.Start, Duration: duration})
+	// still held down when the section began.
+	fn(DTMFResult{Digit: '5', Time: section.Start.Add(-duration / 2), Duration: duration})
+	fn(DTMFResult{Digit: '#', Time: section.End.Add(time.Millisecond), Duration: duration})
+
+	if digits := digits(got); digits != "1#" {
+		t.Fatalf("callback received %q, want 1#", digits)
+	}
+}
@@ -0,0 +1,311 @@
+// Package goEagi of speak.go provides a Speaker type, which plays long
+// synthesized answers with low latency: the text is split into sentences
+// or clauses, and every chunk is played while the next one is being
+// synthesized. Playback can be interrupted for barge-in, and the report
+// tells which chunks the caller actually heard.
+
+package goEagi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultSpeakChunkLength = 160
+	defaultSpeakLookahead   = 1
+)
+
+// SpeechChunkState tells how much of a chunk was played.
+type SpeechChunkState int
+
+const (
+	// SpeechChunkSkipped was never played.
+	SpeechChunkSkipped SpeechChunkState = iota
+	// SpeechChunkInterrupted was stopped by an escape digit.
+	SpeechChunkInterrupted
+	// SpeechChunkPlayed was played to the end.
+	SpeechChunkPlayed
+)
+
+// SpeechChunk is a sentence or clause of the text given to Speak.
+type SpeechChunk struct {
+	Text  string
+	State SpeechChunkState
+}
+
+// SpeakReport tells what happened to every chunk of a Speak call.
+type SpeakReport struct {
+	Chunks []SpeechChunk
+	// Interrupted is set when the Interrupt channel or an escape digit stopped the playback.
+	Interrupted bool
+	// Digit is the escape digit which stopped the playback, 0 if none.
+	Digit rune
+}
+
+// Heard returns the text of the chunks played, in full or in part.
+func (r SpeakReport) Heard() string {
+	var heard []string
+	for _, c := range r.Chunks {
+		if c.State != SpeechChunkSkipped {
+			heard = append(heard, c.Text)
+		}
+	}
+	return strings.Join(heard, " ")
+}
+
+// Speaker speaks text on the channel with a Synthesizer, Request giving the voice settings of every chunk.
+//
+// Chunks are at most MaxChunkLength characters, and up to Lookahead chunks are synthesized ahead of the one playing.
+// Playback stops on any of EscapeDigits, or when Interrupt receives or is closed, for instance by a voice
+// activity detector for barge-in. As AGI commands block until playback ends, Interrupt takes effect at the end
+// of the chunk playing, which short chunks keep responsive.
+type Speaker struct {
+	Request        SynthesisRequest
+	EscapeDigits   string
+	MaxChunkLength int
+	Lookahead      int
+	Interrupt      <-chan struct{}
+
+	eagi        *Eagi
+	synthesizer Synthesizer
+	directory   string
+}
+
+// NewSpeaker creates a Speaker on the channel of e, writing the chunks into audioDirectory
+// unless synthesizer is a TTSCache, which keeps them in its own directory.
+//
+// Barge-in through Interrupt has a latency of up to one chunk: AGI can not stop a STREAM FILE in progress
+// but with an escape digit, so Interrupt is only checked between chunks. Lower MaxChunkLength to shorten it.
+func (e *Eagi) NewSpeaker(synthesizer Synthesizer, audioDirectory string) *Speaker {
+	return &Speaker{
+		MaxChunkLength: defaultSpeakChunkLength,
+		Lookahead:      defaultSpeakLookahead,
+		eagi:           e,
+		synthesizer:    synthesizer,
+		directory:      audioDirectory,
+	}
+}
+
+type speechPrompt struct {
+	path      string
+	extension string
+	temporary bool
+	err       error
+}
+
+// remove deletes the file of a prompt which is not kept by a cache.
+func (p speechPrompt) remove() {
+	if p.temporary && p.path != "" {
+		os.Remove(p.path + p.extension)
+	}
+}
+
+// Speak synthesizes and plays text chunk by chunk. It returns when every chunk has been played,
+// the playback is interrupted, or ctx is cancelled, the report being valid in every case.
+func (s *Speaker) Speak(ctx context.Context, text string) (SpeakReport, error) {
+	maxLength := s.MaxChunkLength
+	if maxLength <= 0 {
+		maxLength = defaultSpeakChunkLength
+	}
+	lookahead := s.Lookahead
+	if lookahead <= 0 {
+		lookahead = defaultSpeakLookahead
+	}
+
+	var report SpeakReport
+	for _, chunk := range splitSpeech(text, maxLength) {
+		report.Chunks = append(report.Chunks, SpeechChunk{Text: chunk})
+	}
+	if len(report.Chunks) == 0 {
+		return report, nil
+	}
+
+	synthesisCtx, cancel := context.WithCancel(ctx)
+	// the producer holds the chunk it synthesized until there is room, which makes lookahead chunks ahead.
+	prompts := make(chan speechPrompt, lookahead-1)
+
+	go func() {
+		defer close(prompts)
+
+		for i, chunk := range report.Chunks {
+			p := s.prompt(synthesisCtx, chunk.Text, i)
+
+			select {
+			case prompts <- p:
+			case <-synthesisCtx.Done():
+				p.remove()
+				return
+			}
+			if p.err != nil {
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		cancel()
+		for p := range prompts {
+			p.remove()
+		}
+	}()
+
+	for i := range report.Chunks {
+		var p speechPrompt
+
+		select {
+		case p = <-prompts:
+		case <-s.Interrupt:
+			report.Interrupted = true
+			return report, nil
+		case <-ctx.Done():
+			return report, ctx.Err()
+		}
+
+		// a prompt received along with the cancellation, or the producer having stopped on it, is not played.
+		if err := ctx.Err(); err != nil {
+			p.remove()
+			return report, err
+		}
+		if p.err != nil {
+			return report, fmt.Errorf("failed to synthesize chunk %d: %w", i, p.err)
+		}
+
+		select {
+		case <-s.Interrupt:
+			p.remove()
+			report.Interrupted = true
+			return report, nil
+		default:
+		}
+
+		reply, err := s.eagi.StreamFile(p.path, s.EscapeDigits)
+		p.remove()
+		if err != nil {
+			return report, fmt.Errorf("failed to play chunk %d: %w", i, err)
+		}
+		if reply.Res < 0 {
+			return report, fmt.Errorf("failed to play chunk %d: channel hung up or file is unplayable", i)
+		}
+		if reply.Res > 0 {
+			report.Chunks[i].State = SpeechChunkInterrupted
+			report.Interrupted = true
+			report.Digit = rune(reply.Res)
+			return report, nil
+		}
+
+		report.Chunks[i].State = SpeechChunkPlayed
+	}
+
+	return report, nil
+}
+
+// prompt synthesizes a chunk. Without a cache, its file gets a unique name and is removed once played.
+func (s *Speaker) prompt(ctx context.Context, text string, index int) speechPrompt {
+	req := s.Request
+	req.Text = text
+
+	if cache, ok := s.synthesizer.(*TTSCache); ok {
+		path, err := synthesizePrompt(ctx, cache, req, "")
+		return speechPrompt{path: path, err: err}
+	}
+
+	extension := ".sln"
+	if req.SampleRate > audioSampleRate {
+		extension = ".sln16"
+		req.SampleRate = 16000
+	}
+
+	name := fmt.Sprintf("%s-%d-%d", cacheKeyOf(s.synthesizer, req), time.Now().UnixNano(), index)
+	path, err := SynthesizeFile(ctx, s.synthesizer, req, s.directory, name+extension)
+	return speechPrompt{path: path, extension: extension, temporary: true, err: err}
+}
+
+// splitSpeech splits text into sentences of at most maxLength characters, longer sentences being split
+// at clause punctuation, then between words.
+func splitSpeech(text string, maxLength int) []string {
+	var chunks []string
+	for _, sentence := range splitSentences(text) {
+		chunks = append(chunks, splitLong(sentence, maxLength)...)
+	}
+	return chunks
+}
+
+// splitSentences splits text at line breaks and after sentence punctuation followed by a space,
+// a full stop also needing a capital letter, digit or quote next, so that abbreviations such as "e.g. this" stay whole.
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+
+	flush := func(end int) {
+		if s := strings.TrimSpace(text[start:end]); s != "" {
+			sentences = append(sentences, strings.Join(strings.Fields(s), " "))
+		}
+		start = end
+	}
+
+	for i, r := range text {
+		switch {
+		case r == '\n':
+			flush(i + 1)
+
+		case r == '.' || r == '!' || r == '?' || r == '…':
+			// closing quotes and brackets stay with the sentence.
+			end := len(text) - len(strings.TrimLeft(text[i+utf8.RuneLen(r):], "\"')”’"))
+			rest := text[end:]
+			trimmed := strings.TrimLeftFunc(rest, unicode.IsSpace)
+			if len(trimmed) == len(rest) && rest != "" {
+				continue
+			}
+			next, _ := utf8.DecodeRuneInString(trimmed)
+			if trimmed == "" || unicode.IsUpper(next) || unicode.IsDigit(next) || r != '.' || next == '"' || next == '“' {
+				flush(end)
+			}
+		}
+	}
+	flush(len(text))
+
+	return sentences
+}
+
+// splitLong splits a sentence longer than maxLength at the last clause punctuation, or space, fitting.
+func splitLong(sentence string, maxLength int) []string {
+	var chunks []string
+
+	for {
+		// limit is the end of the first maxLength characters, -1 when the sentence fits.
+		limit, count := -1, 0
+		for i := range sentence {
+			if count == maxLength {
+				limit = i
+				break
+			}
+			count++
+		}
+		if limit < 0 {
+			break
+		}
+
+		cut := strings.LastIndexAny(sentence[:limit], ",;:—")
+		if cut > 0 {
+			_, size := utf8.DecodeRuneInString(sentence[cut:])
+			cut += size
+		} else if cut = strings.LastIndex(sentence[:limit+1], " "); cut <= 0 {
+			// a space right after the limit ends a fitting chunk too.
+			cut = limit
+		}
+
+		chunks = append(chunks, strings.TrimSpace(sentence[:cut]))
+		sentence = strings.TrimSpace(sentence[cut:])
+	}
+
+	if sentence != "" {
+		chunks = append(chunks, sentence)
+	}
+	return chunks
+}
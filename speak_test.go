@@ -0,0 +1,313 @@
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"  \n ", nil},
+		{"Hello there. How are you? Fine!", []string{"Hello there.", "How are you?", "Fine!"}},
+		{"Use a tool, e.g. this one. Then stop.", []string{"Use a tool, e.g. this one.", "Then stop."}},
+		{"Version 1.5 is out. 2 bugs fixed.", []string{"Version 1.5 is out.", "2 bugs fixed."}},
+		{`He said "stop." Then left.`, []string{`He said "stop."`, "Then left."}},
+		{"(See above.) Next one.", []string{"(See above.)", "Next one."}},
+		{"Wait... what? ok", []string{"Wait... what?", "ok"}},
+		{"Wait... What?", []string{"Wait...", "What?"}},
+		{"Un moment… Voilà.", []string{"Un moment…", "Voilà."}},
+		{"first line\nsecond   line", []string{"first line", "second line"}},
+		{"no punctuation at all", []string{"no punctuation at all"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitSentences(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSplitLong(t *testing.T) {
+	tests := []struct {
+		sentence  string
+		maxLength int
+		want      []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"exactly10!", 10, []string{"exactly10!"}},
+		{"abcdefgh", 4, []string{"abcd", "efgh"}},
+		{"aaaa bbbb", 4, []string{"aaaa", "bbbb"}},
+		{"one two three four", 9, []string{"one two", "three", "four"}},
+		{"ab, cd ef", 3, []string{"ab,", "cd", "ef"}},
+		{"first part; second part", 15, []string{"first part;", "second part"}},
+		{"wait — then go", 7, []string{"wait —", "then go"}},
+		{"éééééé", 3, []string{"ééé", "ééé"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitLong(tt.sentence, tt.maxLength); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLong(%q, %d) = %q, want %q", tt.sentence, tt.maxLength, got, tt.want)
+		}
+	}
+}
+
+func TestSplitLongKeepsEveryWordWithinLimit(t *testing.T) {
+	sentence := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit; sed do eiusmod tempor ", 50)
+	sentence = strings.TrimSpace(sentence)
+
+	for _, maxLength := range []int{1, 5, 11, 40, 160} {
+		chunks := splitLong(sentence, maxLength)
+		for _, c := range chunks {
+			if n := utf8.RuneCountInString(c); n > maxLength || n == 0 {
+				t.Fatalf("maxLength %d: chunk %q has %d characters", maxLength, c, n)
+			}
+		}
+		if got := strings.Join(strings.Fields(strings.Join(chunks, " ")), ""); got != strings.Join(strings.Fields(sentence), "") {
+			t.Fatalf("maxLength %d: chunks lost text", maxLength)
+		}
+	}
+}
+
+func BenchmarkSplitLong(b *testing.B) {
+	sentence := strings.Repeat("word ", 20000)
+	for i := 0; i < b.N; i++ {
+		splitLong(sentence, defaultSpeakChunkLength)
+	}
+}
+
+// countingSynthesizer returns 100 ms of silence for every request, counting them in calls.
+// fail, when not nil, is called first with the index of the request and its error is returned.
+func countingSynthesizer(calls *int32, fail func(ctx context.Context, index int) error) SynthesizerFunc {
+	return func(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+		index := int(atomic.AddInt32(calls, 1)) - 1
+		if fail != nil {
+			if err := fail(ctx, index); err != nil {
+				return nil, err
+			}
+		}
+		return make([]byte, req.SampleRate/5), nil
+	}
+}
+
+// assertEmptyDir fails the test if the speaker left prompts behind in dir.
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("prompt %s left behind", e.Name())
+	}
+}
+
+func chunkStates(report SpeakReport) []SpeechChunkState {
+	var states []SpeechChunkState
+	for _, c := range report.Chunks {
+		states = append(states, c.State)
+	}
+	return states
+}
+
+func TestSpeakLookahead(t *testing.T) {
+	for _, lookahead := range []int{1, 2} {
+		var calls int32
+		dir := t.TempDir()
+
+		e := fakeEagi(t, func(index int, file string) int {
+			if !strings.HasSuffix(file, fmt.Sprintf("-%d", index)) {
+				t.Errorf("lookahead %d: play %d is %s, out of order", lookahead, index, file)
+			}
+			if _, err := os.Stat(file + ".sln"); err != nil {
+				t.Errorf("lookahead %d: prompt of chunk %d is missing: %v", lookahead, index, err)
+			}
+
+			// while a chunk plays, the next lookahead chunks are synthesized, and no more.
+			want := index + 1 + lookahead
+			if want > 4 {
+				want = 4
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for atomic.LoadInt32(&calls) < int32(want) && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(20 * time.Millisecond)
+			if n := atomic.LoadInt32(&calls); n != int32(want) {
+				t.Errorf("lookahead %d: %d chunks synthesized while chunk %d plays, want %d", lookahead, n, index, want)
+			}
+			return 0
+		})
+
+		speaker := e.NewSpeaker(countingSynthesizer(&calls, nil), dir)
+		speaker.Lookahead = lookahead
+
+		report, err := speaker.Speak(context.Background(), "One. Two. Three. Four.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Interrupted || report.Heard() != "One. Two. Three. Four." {
+			t.Errorf("lookahead %d: report %+v, heard %q", lookahead, report, report.Heard())
+		}
+		assertEmptyDir(t, dir)
+	}
+}
+
+func TestSpeakInterrupt(t *testing.T) {
+	skipped, played := SpeechChunkSkipped, SpeechChunkPlayed
+
+	t.Run("before the first chunk", func(t *testing.T) {
+		var calls, plays int32
+		dir := t.TempDir()
+		e := fakeEagi(t, func(int, string) int { atomic.AddInt32(&plays, 1); return 0 })
+
+		interrupt := make(chan struct{})
+		close(interrupt)
+		speaker := e.NewSpeaker(countingSynthesizer(&calls, nil), dir)
+		speaker.Interrupt = interrupt
+
+		report, err := speaker.Speak(context.Background(), "One. Two. Three.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Interrupted || report.Heard() != "" || plays != 0 {
+			t.Errorf("report %+v after %d plays, want interrupted before any", report, plays)
+		}
+		assertEmptyDir(t, dir)
+	})
+
+	t.Run("between chunks", func(t *testing.T) {
+		var calls int32
+		dir := t.TempDir()
+		interrupt := make(chan struct{})
+		// the caller talks during the first chunk, which still plays to its end.
+		e := fakeEagi(t, func(index int, file string) int {
+			if index == 0 {
+				close(interrupt)
+			} else {
+				t.Errorf("chunk %d played after the interruption", index)
+			}
+			return 0
+		})
+
+		speaker := e.NewSpeaker(countingSynthesizer(&calls, nil), dir)
+		speaker.Interrupt = interrupt
+
+		report, err := speaker.Speak(context.Background(), "One. Two. Three.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Interrupted || report.Digit != 0 || report.Heard() != "One." ||
+			!reflect.DeepEqual(chunkStates(report), []SpeechChunkState{played, skipped, skipped}) {
+			t.Errorf("report %+v, heard %q", report, report.Heard())
+		}
+		assertEmptyDir(t, dir)
+	})
+
+	t.Run("escape digit", func(t *testing.T) {
+		var calls int32
+		dir := t.TempDir()
+		e := fakeEagi(t, func(index int, file string) int {
+			if index == 1 {
+				return '#'
+			}
+			return 0
+		})
+
+		speaker := e.NewSpeaker(countingSynthesizer(&calls, nil), dir)
+		speaker.EscapeDigits = "#"
+
+		report, err := speaker.Speak(context.Background(), "One. Two. Three.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Interrupted || report.Digit != '#' || report.Heard() != "One. Two." ||
+			!reflect.DeepEqual(chunkStates(report), []SpeechChunkState{played, SpeechChunkInterrupted, skipped}) {
+			t.Errorf("report %+v, heard %q", report, report.Heard())
+		}
+		assertEmptyDir(t, dir)
+	})
+}
+
+func TestSpeakCleanup(t *testing.T) {
+	errSynthesis := errors.New("synthesis failed")
+
+	t.Run("synthesis error", func(t *testing.T) {
+		var calls int32
+		dir := t.TempDir()
+		e := fakeEagi(t, func(int, string) int { return 0 })
+
+		speaker := e.NewSpeaker(countingSynthesizer(&calls, func(ctx context.Context, index int) error {
+			if index == 2 {
+				return errSynthesis
+			}
+			return nil
+		}), dir)
+
+		report, err := speaker.Speak(context.Background(), "One. Two. Three. Four.")
+		if !errors.Is(err, errSynthesis) || !strings.Contains(err.Error(), "chunk 2") {
+			t.Fatalf("Speak returned %v, want the synthesis error of chunk 2", err)
+		}
+		if report.Heard() != "One. Two." {
+			t.Errorf("heard %q, want the chunks before the error", report.Heard())
+		}
+		assertEmptyDir(t, dir)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		var calls int32
+		dir := t.TempDir()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var once sync.Once
+		e := fakeEagi(t, func(int, string) int {
+			once.Do(cancel)
+			return 0
+		})
+
+		// the synthesis of the third chunk waits for the cancellation.
+		speaker := e.NewSpeaker(countingSynthesizer(&calls, func(ctx context.Context, index int) error {
+			if index == 2 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}), dir)
+
+		report, err := speaker.Speak(ctx, "One. Two. Three.")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Speak returned %v, want context.Canceled", err)
+		}
+		// the second chunk, synthesized before the cancellation, is not played after it.
+		if states := chunkStates(report); !reflect.DeepEqual(states, []SpeechChunkState{SpeechChunkPlayed, SpeechChunkSkipped, SpeechChunkSkipped}) {
+			t.Errorf("chunk states %v after the cancellation", states)
+		}
+		assertEmptyDir(t, dir)
+	})
+
+	t.Run("hangup", func(t *testing.T) {
+		var calls int32
+		dir := t.TempDir()
+		e := fakeEagi(t, func(int, string) int { return -1 })
+
+		report, err := e.NewSpeaker(countingSynthesizer(&calls, nil), dir).Speak(context.Background(), "One. Two.")
+		if err == nil || report.Heard() != "" {
+			t.Fatalf("Speak on a hung up channel returned %v, heard %q", err, report.Heard())
+		}
+		assertEmptyDir(t, dir)
+	})
+}
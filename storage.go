@@ -0,0 +1,181 @@
+// Package goEagi of storage.go provides the Storage interface where
+// recordings are written to, a local filesystem implementation, and
+// the JSON metadata sidecar stored next to every recording.
+
+package goEagi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const metadataSuffix = ".json"
+
+// Storage stores recordings as objects named by slash-separated keys.
+type Storage interface {
+	// Create starts a new object, which is written while the call is in progress
+	// and committed by Close. ctx bounds the whole upload, not only the call to Create.
+	Create(ctx context.Context, key string) (StorageWriter, error)
+	// Open reads an object.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object.
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrAppendOnly is returned by the WriteAt of a StorageWriter which can not rewrite what it has written.
+var ErrAppendOnly = errors.New("storage writer is append-only")
+
+// StorageWriter is an object being written. WriteAt can rewrite bytes already written,
+// such as a wav header, but only within the first part of the object for remote storages,
+// and not at all, returning ErrAppendOnly, for encrypted storages.
+// Sync persists what has been written so far where the storage allows it.
+type StorageWriter interface {
+	io.Writer
+	io.WriterAt
+	Sync() error
+	// Close commits the object.
+	Close() error
+	// Abort discards the object.
+	Abort() error
+}
+
+// RecordingMetadata is the JSON sidecar stored next to a recording, under the key of the recording with a .json suffix.
+type RecordingMetadata struct {
+	UniqueID     string    `json:"uniqueid"`
+	CallerID     string    `json:"callerid"`
+	CallerIDName string    `json:"calleridname,omitempty"`
+	Channel      string    `json:"channel,omitempty"`
+	Key          string    `json:"key"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Duration     float64   `json:"duration"`
+	Format       string    `json:"format"`
+	SampleRate   int       `json:"sample_rate"`
+	Size         int64     `json:"size"`
+}
+
+// NewRecordingMetadata fills the call identification of a sidecar from the AGI environment.
+func NewRecordingMetadata(eagi *Eagi) RecordingMetadata {
+	return RecordingMetadata{
+		UniqueID:     eagi.Env["uniqueid"],
+		CallerID:     eagi.Env["callerid"],
+		CallerIDName: eagi.Env["calleridname"],
+		Channel:      eagi.Env["channel"],
+	}
+}
+
+// WriteMetadata stores meta as the sidecar of the object key.
+func WriteMetadata(ctx context.Context, storage Storage, key string, meta RecordingMetadata) error {
+	payload, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	w, err := storage.Create(ctx, key+metadataSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata: %w", err)
+	}
+	if _, err := w.Write(payload); err != nil {
+		w.Abort()
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	return w.Close()
+}
+
+// ReadMetadata reads the sidecar of the object key.
+func ReadMetadata(ctx context.Context, storage Storage, key string) (RecordingMetadata, error) {
+	var meta RecordingMetadata
+
+	r, err := storage.Open(ctx, key+metadataSuffix)
+	if err != nil {
+		return meta, err
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		return meta, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// LocalStorage stores objects as files under Directory. With an empty Directory,
+// keys are used as file paths as they are.
+type LocalStorage struct {
+	Directory string
+}
+
+// NewLocalStorage creates a LocalStorage, and its directory if needed.
+func NewLocalStorage(directory string) (*LocalStorage, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStorage{Directory: directory}, nil
+}
+
+// Create creates the file of key, and its directory if needed. The file is written in place,
+// so that a crash leaves the data written so far.
+func (l *LocalStorage) Create(_ context.Context, key string) (StorageWriter, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	return localWriter{file}, nil
+}
+
+// Open opens the file of key.
+func (l *LocalStorage) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+// Delete removes the file of key.
+func (l *LocalStorage) Delete(_ context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// path maps a key to a file path, keys may not leave Directory.
+func (l *LocalStorage) path(key string) (string, error) {
+	if l.Directory == "" {
+		return key, nil
+	}
+
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "\\") || clean != "/"+strings.TrimPrefix(key, "/") {
+		return "", errors.New("invalid storage key: " + key)
+	}
+	return filepath.Join(l.Directory, filepath.FromSlash(clean)), nil
+}
+
+type localWriter struct {
+	*os.File
+}
+
+// Abort closes and removes the file.
+func (w localWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.Name())
+}
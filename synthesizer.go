@@ -0,0 +1,157 @@
+// Package goEagi of synthesizer.go provides the Synthesizer interface
+// implemented by the text to speech providers, and the helpers working
+// with any of them: writing prompts in Asterisk formats, falling back
+// from a provider to the next, and playing prompts on the channel.
+
+package goEagi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zaf/agi"
+)
+
+// SynthesisRequest is what to say and how. Empty fields use the defaults of the provider,
+// and a zero SampleRate means 8 kHz.
+type SynthesisRequest struct {
+	// Text is plain text, or an SSML document when SSML is set.
+	Text       string
+	SSML       bool
+	Language   string
+	Voice      string
+	SampleRate int
+}
+
+// Synthesizer is a text to speech provider.
+type Synthesizer interface {
+	// Synthesize returns the speech of req as 16-bit little-endian mono linear PCM at req.SampleRate,
+	// resampled by the provider if needed.
+	Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error)
+}
+
+// CacheKeyer is implemented by synthesizers whose speech depends on more than the request,
+// such as voice settings, so that caches tell their speech apart.
+type CacheKeyer interface {
+	// CacheKey identifies the speech of req, it is safe to use as a file name.
+	CacheKey(req SynthesisRequest) string
+}
+
+// SynthesizerFunc adapts a function to a Synthesizer.
+type SynthesizerFunc func(ctx context.Context, req SynthesisRequest) ([]byte, error)
+
+// Synthesize calls f(ctx, req).
+func (f SynthesizerFunc) Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+	return f(ctx, req)
+}
+
+// SynthesizeFile synthesizes req into the audio file audioName of audioDirectory, whose extension chooses
+// the format: .wav holds slin at req.SampleRate, other extensions are codec names such as .sln16 or .ulaw,
+// whose rate replaces req.SampleRate. It returns the file path without extension for playback.
+func SynthesizeFile(ctx context.Context, synthesizer Synthesizer, req SynthesisRequest, audioDirectory string, audioName string) (string, error) {
+	codec, err := codecOfFile(audioName, req.SampleRate)
+	if err != nil {
+		return "", err
+	}
+	req.SampleRate = codec.SampleRate()
+
+	pcm, err := synthesizer.Synthesize(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	audioPath, err := GenerateEncodedAudio(pcm, codec, audioDirectory, audioName)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(audioPath, filepath.Ext(audioPath)), nil
+}
+
+// FallbackSynthesizer tries its synthesizers in order, returning the speech of the first one succeeding.
+type FallbackSynthesizer []Synthesizer
+
+// Synthesize returns the speech of the first synthesizer succeeding, or the errors of all of them.
+func (f FallbackSynthesizer) Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+	if len(f) == 0 {
+		return nil, errors.New("no synthesizer to fall back to")
+	}
+
+	var failures []string
+	for i, s := range f {
+		pcm, err := s.Synthesize(ctx, req)
+		if err == nil {
+			return pcm, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		failures = append(failures, fmt.Sprintf("synthesizer %d (%T): %v", i, s, err))
+	}
+
+	return nil, fmt.Errorf("all synthesizers failed: %s", strings.Join(failures, "; "))
+}
+
+// PlaySynthesized synthesizes req into audioDirectory, as sln16 when req.SampleRate is above 8 kHz and sln otherwise,
+// and plays it on the channel. A TTSCache synthesizer keeps the file in its own directory instead.
+// Playback stops on any of escapeDigits, which Reply reports.
+func (e *Eagi) PlaySynthesized(ctx context.Context, synthesizer Synthesizer, req SynthesisRequest, audioDirectory string, escapeDigits string) (agi.Reply, error) {
+	audioPath, err := synthesizePrompt(ctx, synthesizer, req, audioDirectory)
+	if err != nil {
+		return agi.Reply{}, err
+	}
+
+	return e.StreamFile(audioPath, escapeDigits)
+}
+
+// synthesizePrompt writes the speech of req as a prompt Asterisk plays, and returns its path without extension.
+func synthesizePrompt(ctx context.Context, synthesizer Synthesizer, req SynthesisRequest, audioDirectory string) (string, error) {
+	extension := ".sln"
+	if req.SampleRate > audioSampleRate {
+		extension = ".sln16"
+		req.SampleRate = 16000
+	}
+
+	if cache, ok := synthesizer.(*TTSCache); ok {
+		return cache.File(ctx, req, extension)
+	}
+	return SynthesizeFile(ctx, synthesizer, req, audioDirectory, cacheKeyOf(synthesizer, req)+extension)
+}
+
+// codecOfFile returns the codec of an audio file name, slin at rate for a .wav file.
+func codecOfFile(audioName string, rate int) (Codec, error) {
+	extension := filepath.Ext(audioName)
+	if extension == ".wav" {
+		return LinearCodec{Rate: rate}, nil
+	}
+	if extension == "" {
+		return nil, fmt.Errorf("audio name %s has no extension", audioName)
+	}
+	return NewCodec(extension[1:])
+}
+
+// cacheKeyOf identifies the speech of req by synthesizer.
+func cacheKeyOf(synthesizer Synthesizer, req SynthesisRequest) string {
+	if k, ok := synthesizer.(CacheKeyer); ok {
+		return k.CacheKey(req)
+	}
+
+	req.Voice += fmt.Sprintf("\x00%T", synthesizer)
+	return req.key()
+}
+
+// key identifies the speech of a request.
+func (r SynthesisRequest) key() string {
+	rate := r.SampleRate
+	if rate <= 0 {
+		rate = audioSampleRate
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%t\x00%s\x00%s\x00%d\x00%s", r.SSML, r.Language, r.Voice, rate, r.Text)))
+	return hex.EncodeToString(sum[:16])
+}
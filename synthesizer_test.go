@@ -0,0 +1,229 @@
+package goEagi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zaf/agi"
+)
+
+// fakeEagi returns an Eagi talking to a fake Asterisk, which answers every STREAM FILE with the result of play,
+// called with the index of the command and the file, and every other command with 0.
+func fakeEagi(t *testing.T, play func(index int, file string) int) *Eagi {
+	t.Helper()
+
+	sessionIn, asteriskOut := io.Pipe()
+	asteriskIn, sessionOut := io.Pipe()
+	t.Cleanup(func() {
+		sessionOut.Close()
+		asteriskOut.Close()
+	})
+
+	go func() {
+		env := []string{"request", "channel", "language", "type", "uniqueid", "version", "callerid", "calleridname",
+			"callingpres", "callingani2", "callington", "callingtns", "dnid", "rdnis", "context", "extension",
+			"priority", "enhanced", "accountcode", "threadid"}
+		for _, key := range env {
+			fmt.Fprintf(asteriskOut, "agi_%s: test\n", key)
+		}
+		fmt.Fprint(asteriskOut, "\n")
+
+		plays := 0
+		scanner := bufio.NewScanner(asteriskIn)
+		for scanner.Scan() {
+			res := 0
+			if fields := strings.Fields(scanner.Text()); len(fields) > 2 && fields[0] == "STREAM" {
+				file, _ := strconv.Unquote(fields[2])
+				res = play(plays, file)
+				plays++
+			}
+			fmt.Fprintf(asteriskOut, "200 result=%d endpos=800\n", res)
+		}
+	}()
+
+	session := agi.New()
+	if err := session.Init(bufio.NewReadWriter(bufio.NewReader(sessionIn), bufio.NewWriter(sessionOut))); err != nil {
+		t.Fatal(err)
+	}
+	return &Eagi{Session: session}
+}
+
+// toneSynthesizer returns one second of a 1 kHz tone at the requested rate, recording the requests in reqs.
+func toneSynthesizer(reqs *[]SynthesisRequest) SynthesizerFunc {
+	return func(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+		*reqs = append(*reqs, req)
+		return Frame(sine(req.SampleRate, 1000, req.SampleRate, 10000)).Bytes(nil), nil
+	}
+}
+
+func TestFallbackSynthesizer(t *testing.T) {
+	errQuota := errors.New("quota exceeded")
+	errVoice := errors.New("unknown voice")
+
+	var called []string
+	fake := func(name string, err error) SynthesizerFunc {
+		return func(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+			called = append(called, name)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(name), nil
+		}
+	}
+
+	pcm, err := FallbackSynthesizer{fake("cloud", errQuota), fake("local", nil), fake("spare", nil)}.Synthesize(context.Background(), SynthesisRequest{Text: "Hello"})
+	if err != nil || string(pcm) != "local" || !reflect.DeepEqual(called, []string{"cloud", "local"}) {
+		t.Fatalf("got %q, %v after calling %v, want the speech of local after cloud", pcm, err, called)
+	}
+
+	called = nil
+	_, err = FallbackSynthesizer{fake("cloud", errQuota), fake("local", errVoice)}.Synthesize(context.Background(), SynthesisRequest{Text: "Hello"})
+	if err == nil || !reflect.DeepEqual(called, []string{"cloud", "local"}) {
+		t.Fatalf("got %v after calling %v, want an error after calling both", err, called)
+	}
+	for _, want := range []string{"synthesizer 0", "quota exceeded", "synthesizer 1", "unknown voice"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// a cancelled caller is not a failing synthesizer, the next one is not tried.
+	called = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = FallbackSynthesizer{fake("cloud", context.Canceled), fake("local", nil)}.Synthesize(ctx, SynthesisRequest{Text: "Hello"})
+	if !errors.Is(err, context.Canceled) || !reflect.DeepEqual(called, []string{"cloud"}) {
+		t.Fatalf("got %v after calling %v, want context.Canceled after calling cloud", err, called)
+	}
+
+	if _, err := (FallbackSynthesizer{}).Synthesize(context.Background(), SynthesisRequest{Text: "Hello"}); err == nil {
+		t.Fatal("empty FallbackSynthesizer succeeded")
+	}
+}
+
+func TestSynthesizeFile(t *testing.T) {
+	tests := []struct {
+		name string
+		rate int
+		// wantRate is the rate of the file, which the synthesizer is asked for.
+		wantRate int
+	}{
+		{"prompt.sln", 16000, 8000},
+		{"prompt.sln16", 0, 16000},
+		{"prompt.wav", 0, 8000},
+		{"prompt.wav", 16000, 16000},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		var reqs []SynthesisRequest
+
+		base, err := SynthesizeFile(context.Background(), toneSynthesizer(&reqs), SynthesisRequest{Text: "Hello", SampleRate: tt.rate}, dir, tt.name)
+		if err != nil {
+			t.Fatalf("%s at %d Hz: %v", tt.name, tt.rate, err)
+		}
+		if want := filepath.Join(dir, "prompt"); base != want {
+			t.Fatalf("%s at %d Hz: returned %s, want %s", tt.name, tt.rate, base, want)
+		}
+		if len(reqs) != 1 || reqs[0].SampleRate != tt.wantRate || reqs[0].Text != "Hello" {
+			t.Fatalf("%s at %d Hz: requested %+v, want %d Hz", tt.name, tt.rate, reqs, tt.wantRate)
+		}
+
+		var samples []int16
+		if filepath.Ext(tt.name) == ".wav" {
+			file, err := os.Open(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader, err := NewWavReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples, err = reader.ReadAll()
+			file.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rate := reader.Info().SampleRate; rate != tt.wantRate {
+				t.Errorf("%s at %d Hz: wav at %d Hz, want %d", tt.name, tt.rate, rate, tt.wantRate)
+			}
+		} else {
+			content, err := os.ReadFile(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples = DecodeFrame(nil, content)
+		}
+
+		if len(samples) != tt.wantRate {
+			t.Errorf("%s at %d Hz: %d samples, want one second", tt.name, tt.rate, len(samples))
+			continue
+		}
+		if amplitude := toneAmplitude(samples, 1000, tt.wantRate, tt.wantRate/10); amplitude < 9900 || amplitude > 10100 {
+			t.Errorf("%s at %d Hz: tone amplitude %.0f, want 10000", tt.name, tt.rate, amplitude)
+		}
+	}
+}
+
+func TestSynthesizeFileWithoutExtension(t *testing.T) {
+	dir := t.TempDir()
+	var reqs []SynthesisRequest
+
+	_, err := SynthesizeFile(context.Background(), toneSynthesizer(&reqs), SynthesisRequest{Text: "Hello"}, dir, "prompt")
+	if err == nil || !strings.Contains(err.Error(), "no extension") {
+		t.Fatalf("got %v, want a missing extension error", err)
+	}
+	if len(reqs) != 0 {
+		t.Fatal("synthesized without a format to write")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("files written: %v", entries)
+	}
+}
+
+func TestPlaySynthesized(t *testing.T) {
+	dir := t.TempDir()
+	var reqs []SynthesisRequest
+
+	var played []string
+	e := fakeEagi(t, func(index int, file string) int {
+		played = append(played, file)
+		return '#'
+	})
+
+	reply, err := e.PlaySynthesized(context.Background(), toneSynthesizer(&reqs), SynthesisRequest{Text: "Hello", SampleRate: 24000}, dir, "#")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Res != '#' {
+		t.Errorf("reply %+v, want the escape digit", reply)
+	}
+
+	// above 8 kHz, the prompt is written as sln16 under the cache key of the request.
+	if len(reqs) != 1 || reqs[0].SampleRate != 16000 {
+		t.Fatalf("requested %+v, want 16 kHz", reqs)
+	}
+	if want := filepath.Join(dir, cacheKeyOf(toneSynthesizer(nil), reqs[0])); len(played) != 1 || played[0] != want {
+		t.Fatalf("played %v, want %s", played, want)
+	}
+	if info, err := os.Stat(played[0] + ".sln16"); err != nil || info.Size() != 32000 {
+		t.Fatalf("prompt %s.sln16: %v", played[0], err)
+	}
+
+	errSynthesis := errors.New("synthesis failed")
+	_, err = e.PlaySynthesized(context.Background(), SynthesizerFunc(func(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+		return nil, errSynthesis
+	}), SynthesisRequest{Text: "Hello"}, dir, "")
+	if !errors.Is(err, errSynthesis) || len(played) != 1 {
+		t.Fatalf("got %v after %d plays, want the synthesis error and nothing played", err, len(played))
+	}
+}
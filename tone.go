@@ -0,0 +1,462 @@
+// Package goEagi of tone.go provides call progress, special information,
+// fax and beep tone detection on the EAGI audio stream. Each tone is
+// described by a ToneProfile, a cadence of tone and silence segments,
+// so that further national tone plans can be added by the caller.
+
+package goEagi
+
+import (
+	"context"
+	"time"
+)
+
+// ToneEventType identifies the signal a ToneProfile detects.
+type ToneEventType string
+
+const (
+	ToneBusy     ToneEventType = "BUSY"
+	ToneReorder  ToneEventType = "REORDER"
+	ToneRingback ToneEventType = "RINGBACK"
+	ToneSIT      ToneEventType = "SIT"
+	ToneFaxCNG   ToneEventType = "FAX_CNG"
+	ToneFaxCED   ToneEventType = "FAX_CED"
+	ToneBeep     ToneEventType = "BEEP"
+)
+
+const (
+	toneBlockDuration = 40 * time.Millisecond
+	toneBlockSize     = audioSampleRate * int(toneBlockDuration/time.Millisecond) / 1000
+
+	defaultToneMinLevel  = -40.0
+	defaultToneMinPurity = 0.6
+)
+
+// ToneSegment is a part of a cadence lasting between Min and Max, a zero Max is unbounded.
+// The segment matches when all Frequencies are present, or when AnyOf is set, when one of them
+// is present as a pure tone. A segment without frequencies matches silence.
+type ToneSegment struct {
+	Frequencies []float64
+	AnyOf       bool
+	Min         time.Duration
+	Max         time.Duration
+}
+
+// ToneProfile is a cadence of segments which has to be observed Repeat times in a row.
+// Its events are dropped when they start while a cadence of a profile of SuppressedBy is being
+// matched or within one which was matched, as when a tone of that cadence resembles this one.
+type ToneProfile struct {
+	Type         ToneEventType
+	Cadence      []ToneSegment
+	Repeat       int
+	SuppressedBy []ToneEventType
+}
+
+// ToneEvent is a detected tone. Offset is the start of the cadence relative to the start of the stream,
+// and Time the capture time of that start.
+type ToneEvent struct {
+	Error    error
+	Type     ToneEventType
+	Offset   time.Duration
+	Duration time.Duration
+	Time     time.Time
+}
+
+// DefaultToneProfiles returns the built-in profiles: North American busy, reorder and ringback,
+// the three tone SIT, fax CNG and CED, and a voicemail beep of any frequency between 400 and 2100 Hz followed by silence.
+// The last tone of a SIT and the fax tones resemble a beep, so the beep is suppressed by SIT, CNG and CED.
+func DefaultToneProfiles() []ToneProfile {
+	busy := []float64{480, 620}
+	ringback := []float64{440, 480}
+
+	var beep []float64
+	for f := 400.0; f <= 2100; f += 10 {
+		beep = append(beep, f)
+	}
+
+	return []ToneProfile{
+		{
+			Type: ToneBusy,
+			Cadence: []ToneSegment{
+				{Frequencies: busy, Min: 400 * time.Millisecond, Max: 600 * time.Millisecond},
+				{Min: 400 * time.Millisecond, Max: 600 * time.Millisecond},
+			},
+			Repeat: 2,
+		},
+		{
+			Type: ToneReorder,
+			Cadence: []ToneSegment{
+				{Frequencies: busy, Min: 200 * time.Millisecond, Max: 320 * time.Millisecond},
+				{Min: 200 * time.Millisecond, Max: 320 * time.Millisecond},
+			},
+			Repeat: 3,
+		},
+		{
+			Type: ToneRingback,
+			Cadence: []ToneSegment{
+				{Frequencies: ringback, Min: 1600 * time.Millisecond, Max: 2400 * time.Millisecond},
+			},
+			Repeat: 1,
+		},
+		{
+			Type: ToneSIT,
+			Cadence: []ToneSegment{
+				{Frequencies: []float64{913.8, 985.2}, AnyOf: true, Min: 240 * time.Millisecond, Max: 420 * time.Millisecond},
+				{Frequencies: []float64{1370.6, 1428.5}, AnyOf: true, Min: 240 * time.Millisecond, Max: 420 * time.Millisecond},
+				{Frequencies: []float64{1776.7}, Min: 240 * time.Millisecond, Max: 420 * time.Millisecond},
+			},
+			Repeat: 1,
+		},
+		{
+			Type: ToneFaxCNG,
+			Cadence: []ToneSegment{
+				{Frequencies: []float64{1100}, Min: 400 * time.Millisecond, Max: 700 * time.Millisecond},
+			},
+			Repeat: 1,
+		},
+		{
+			Type: ToneFaxCED,
+			Cadence: []ToneSegment{
+				{Frequencies: []float64{2100}, Min: 1000 * time.Millisecond},
+			},
+			Repeat: 1,
+		},
+		{
+			Type: ToneBeep,
+			Cadence: []ToneSegment{
+				{Frequencies: beep, AnyOf: true, Min: 120 * time.Millisecond, Max: 1500 * time.Millisecond},
+				{Min: 80 * time.Millisecond},
+			},
+			Repeat:       1,
+			SuppressedBy: []ToneEventType{ToneSIT, ToneFaxCNG, ToneFaxCED},
+		},
+	}
+}
+
+// ToneDetector detects the cadences of its profiles in 8 kHz 16-bit mono audio.
+// A ToneDetector is not safe for concurrent use.
+type ToneDetector struct {
+	// MinLevel is the minimum level (dBFS) of a tone, blocks below it are silence.
+	MinLevel float64
+	// MinPurity is the minimum share of the block energy carried by the tones of a segment.
+	MinPurity float64
+
+	profiles     []ToneProfile
+	matchers     []cadenceMatcher
+	frequencies  []float64
+	coefficients []float64
+	index        map[float64]int
+
+	block   [toneBlockSize]float64
+	powers  []float64
+	filled  int
+	samples int64
+
+	// matched keeps the last event of every type, to suppress the events starting within it.
+	matched map[ToneEventType]ToneEvent
+}
+
+// NewToneDetector creates a ToneDetector for the given profiles, or DefaultToneProfiles if none is given.
+func NewToneDetector(profiles ...ToneProfile) *ToneDetector {
+	if len(profiles) == 0 {
+		profiles = DefaultToneProfiles()
+	}
+
+	t := ToneDetector{
+		MinLevel:  defaultToneMinLevel,
+		MinPurity: defaultToneMinPurity,
+		profiles:  profiles,
+		matchers:  make([]cadenceMatcher, len(profiles)),
+		index:     make(map[float64]int),
+		matched:   make(map[ToneEventType]ToneEvent),
+	}
+
+	for _, p := range profiles {
+		for _, segment := range p.Cadence {
+			for _, f := range segment.Frequencies {
+				if _, ok := t.index[f]; ok {
+					continue
+				}
+				t.index[f] = len(t.frequencies)
+				t.frequencies = append(t.frequencies, f)
+				t.coefficients = append(t.coefficients, goertzelCoefficient(f, audioSampleRate))
+			}
+		}
+	}
+	t.powers = make([]float64, len(t.frequencies))
+
+	return &t
+}
+
+// Process analyzes a frame of 16-bit little-endian audio and returns the tones completed within it.
+// The frame is taken as captured right now, see ProcessAt.
+func (t *ToneDetector) Process(frame []byte) []ToneEvent {
+	return t.ProcessAt(frame, time.Now())
+}
+
+// ProcessAt is Process for a frame captured at the given time, from which the Time of the tones is derived.
+func (t *ToneDetector) ProcessAt(frame []byte, captured time.Time) []ToneEvent {
+	var events []ToneEvent
+	frameStart := samplesDuration(t.samples)
+
+	for i := 0; i < len(frame)/audioBytesPerSample; i++ {
+		t.block[t.filled] = float64(sampleAt(frame, i)) / fullScale
+		t.filled++
+		t.samples++
+
+		if t.filled < toneBlockSize {
+			continue
+		}
+		t.filled = 0
+
+		for _, event := range t.analyzeBlock() {
+			event.Time = captured.Add(event.Offset - frameStart)
+			events = append(events, event)
+		}
+	}
+
+	return events
+}
+
+// Detect launches a new goroutine that detects tones in a stream of audio frames.
+func (t *ToneDetector) Detect(ctx context.Context, stream <-chan []byte) <-chan ToneEvent {
+	toneEventStream := make(chan ToneEvent)
+
+	go func() {
+		defer close(toneEventStream)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case buf, ok := <-stream:
+				if !ok {
+					return
+				}
+
+				for _, event := range t.Process(buf) {
+					select {
+					case toneEventStream <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return toneEventStream
+}
+
+// analyzeBlock measures the tone powers of the block and feeds every profile's cadence matcher.
+func (t *ToneDetector) analyzeBlock() []ToneEvent {
+	var blockPower float64
+	for _, s := range t.block {
+		blockPower += s * s
+	}
+	blockPower /= float64(toneBlockSize)
+
+	for i, c := range t.coefficients {
+		t.powers[i] = goertzelPower(t.block[:], c)
+	}
+
+	var events []ToneEvent
+	end := samplesDuration(t.samples)
+
+	for i := range t.profiles {
+		profile := &t.profiles[i]
+		matcher := &t.matchers[i]
+
+		matches := func(segment int) (bool, int) {
+			return t.matchSegment(profile.Cadence[segment], blockPower)
+		}
+
+		if start, ok := matcher.feed(profile, matches, end); ok {
+			events = append(events, ToneEvent{
+				Type:     profile.Type,
+				Offset:   start,
+				Duration: end - start,
+			})
+		} else if last, ok := t.matched[profile.Type]; ok && matcher.holding {
+			// the event of an unbounded tone still going on covers it, to suppress what starts within it.
+			last.Duration = end - last.Offset
+			t.matched[profile.Type] = last
+		}
+	}
+
+	for _, event := range events {
+		t.matched[event.Type] = event
+	}
+
+	kept := events[:0]
+	for _, event := range events {
+		if !t.suppressed(t.profileOf(event.Type), event) {
+			kept = append(kept, event)
+		}
+	}
+
+	return kept
+}
+
+// profileOf returns the profile of an event type.
+func (t *ToneDetector) profileOf(eventType ToneEventType) *ToneProfile {
+	for i := range t.profiles {
+		if t.profiles[i].Type == eventType {
+			return &t.profiles[i]
+		}
+	}
+	return nil
+}
+
+// suppressed reports whether event starts while a cadence of a profile suppressing it is being matched,
+// or within the last one matched.
+func (t *ToneDetector) suppressed(profile *ToneProfile, event ToneEvent) bool {
+	if profile == nil {
+		return false
+	}
+
+	for _, suppressor := range profile.SuppressedBy {
+		for i := range t.profiles {
+			if t.profiles[i].Type == suppressor && t.matchers[i].active && t.matchers[i].start <= event.Offset {
+				return true
+			}
+		}
+
+		if last, ok := t.matched[suppressor]; ok && event.Offset >= last.Offset && event.Offset < last.Offset+last.Duration {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegment reports whether the current block matches a segment,
+// and for AnyOf segments which of the frequencies is present.
+func (t *ToneDetector) matchSegment(segment ToneSegment, blockPower float64) (bool, int) {
+	minPower := dbToPower(t.MinLevel)
+
+	if len(segment.Frequencies) == 0 {
+		return blockPower < minPower, -1
+	}
+	if blockPower < minPower {
+		return false, -1
+	}
+
+	if segment.AnyOf {
+		best, dominant := 0.0, -1
+		for i, f := range segment.Frequencies {
+			if p := t.powers[t.index[f]]; p > best {
+				best, dominant = p, i
+			}
+		}
+		return best >= minPower && best/blockPower >= t.MinPurity, dominant
+	}
+
+	var total float64
+	for _, f := range segment.Frequencies {
+		p := t.powers[t.index[f]]
+		if p < minPower/float64(len(segment.Frequencies)) {
+			return false, -1
+		}
+		total += p
+	}
+	return total/blockPower >= t.MinPurity, -1
+}
+
+// cadenceMatcher follows a profile's cadence block by block.
+// A single unmatched block is tolerated to absorb segment transitions and glitches,
+// and the frequency of an AnyOf segment must stay the same for the whole segment.
+type cadenceMatcher struct {
+	active    bool
+	segment   int
+	completed int
+	run       time.Duration
+	missed    bool
+	start     time.Duration
+	dominant  int
+	holding   bool
+}
+
+// feed advances the matcher by one block ending at end, and returns the start
+// of the cadence when the profile has been completed.
+func (m *cadenceMatcher) feed(profile *ToneProfile, matches func(int) (bool, int), end time.Duration) (time.Duration, bool) {
+	segments := len(profile.Cadence)
+	total := segments * profile.Repeat
+	if total == 0 {
+		return 0, false
+	}
+
+	if m.holding {
+		// an unbounded final segment is reported once, until it stops.
+		if ok, dominant := matches(m.segment); ok && dominant == m.dominant {
+			return 0, false
+		}
+		m.restart(profile, matches, end)
+		return 0, false
+	}
+
+	if m.active {
+		current := profile.Cadence[m.segment]
+		final := m.completed+1 == total
+
+		if ok, dominant := matches(m.segment); ok && dominant == m.dominant {
+			m.run += toneBlockDuration
+			m.missed = false
+
+			if current.Max > 0 && m.run > current.Max+toneBlockDuration {
+				m.restart(profile, matches, end)
+				return 0, false
+			}
+			if final && current.Max == 0 && m.run >= current.Min {
+				start := m.start
+				*m = cadenceMatcher{holding: true, segment: m.segment, dominant: m.dominant}
+				return start, true
+			}
+			return 0, false
+		}
+
+		ended := m.run >= current.Min && (current.Max == 0 || m.run <= current.Max+toneBlockDuration)
+		if ended && final {
+			start := m.start
+			*m = cadenceMatcher{}
+			return start, true
+		}
+
+		next := (m.segment + 1) % segments
+		if ok, dominant := matches(next); ended && ok {
+			m.completed++
+			m.segment = next
+			m.run = toneBlockDuration
+			m.missed = false
+			m.dominant = dominant
+			return 0, false
+		}
+
+		if !m.missed {
+			m.missed = true
+			m.run += toneBlockDuration
+			return 0, false
+		}
+	}
+
+	m.restart(profile, matches, end)
+	return 0, false
+}
+
+// restart resets the matcher and starts a new cadence if the block matches the first segment.
+// A leading silence segment never starts a cadence, otherwise every pause would.
+func (m *cadenceMatcher) restart(profile *ToneProfile, matches func(int) (bool, int), end time.Duration) {
+	*m = cadenceMatcher{}
+
+	if len(profile.Cadence[0].Frequencies) == 0 {
+		return
+	}
+	ok, dominant := matches(0)
+	if !ok {
+		return
+	}
+
+	m.active = true
+	m.dominant = dominant
+	m.run = toneBlockDuration
+	m.start = end - toneBlockDuration
+}
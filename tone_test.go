@@ -0,0 +1,185 @@
+package goEagi
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// toneAudio returns 16-bit little-endian audio of a sine of frequency, or silence when it is 0, at -10 dBFS.
+func toneAudio(frequency float64, duration time.Duration) []byte {
+	samples := make(Frame, int(duration.Seconds()*audioSampleRate))
+	for i := range samples {
+		if frequency > 0 {
+			samples[i] = int16(0.3 * fullScale * math.Sin(2*math.Pi*frequency*float64(i)/audioSampleRate))
+		}
+	}
+	return samples.Bytes(nil)
+}
+
+func detectTones(audio ...[]byte) []ToneEvent {
+	t := NewToneDetector()
+
+	var events []ToneEvent
+	for _, a := range audio {
+		// 20 ms frames, as read from the EAGI stream.
+		for start := 0; start < len(a); start += 320 {
+			end := start + 320
+			if end > len(a) {
+				end = len(a)
+			}
+			events = append(events, t.Process(a[start:end])...)
+		}
+	}
+	return events
+}
+
+func toneTypes(events []ToneEvent) []ToneEventType {
+	var types []ToneEventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestToneDetectorSITIsNotABeep(t *testing.T) {
+	events := detectTones(
+		toneAudio(0, 200*time.Millisecond),
+		toneAudio(913.8, 274*time.Millisecond),
+		toneAudio(1370.6, 274*time.Millisecond),
+		toneAudio(1776.7, 380*time.Millisecond),
+		toneAudio(0, time.Second),
+	)
+
+	types := toneTypes(events)
+	if len(types) != 1 || types[0] != ToneSIT {
+		t.Fatalf("events = %v, want a single SIT", types)
+	}
+	if offset := events[0].Offset; offset < 160*time.Millisecond || offset > 240*time.Millisecond {
+		t.Errorf("SIT offset = %v, want about 200ms", offset)
+	}
+}
+
+func TestToneDetectorBeep(t *testing.T) {
+	for _, frequency := range []float64{440, 1000, 1776.7, 2100} {
+		events := detectTones(
+			toneAudio(0, 200*time.Millisecond),
+			toneAudio(frequency, 400*time.Millisecond),
+			toneAudio(0, 500*time.Millisecond),
+		)
+
+		if types := toneTypes(events); len(types) != 1 || types[0] != ToneBeep {
+			t.Errorf("%v Hz: events = %v, want a single BEEP", frequency, types)
+		}
+	}
+}
+
+func TestToneDetectorBeepAfterSIT(t *testing.T) {
+	events := detectTones(
+		toneAudio(913.8, 274*time.Millisecond),
+		toneAudio(1370.6, 274*time.Millisecond),
+		toneAudio(1776.7, 380*time.Millisecond),
+		toneAudio(0, time.Second),
+		toneAudio(1000, 400*time.Millisecond),
+		toneAudio(0, 500*time.Millisecond),
+	)
+
+	if types := toneTypes(events); len(types) != 2 || types[0] != ToneSIT || types[1] != ToneBeep {
+		t.Fatalf("events = %v, want SIT then BEEP", types)
+	}
+}
+
+func TestToneDetectorFaxIsNotABeep(t *testing.T) {
+	var cng [][]byte
+	for i := 0; i < 3; i++ {
+		cng = append(cng, toneAudio(1100, 500*time.Millisecond), toneAudio(0, 3*time.Second))
+	}
+
+	tests := []struct {
+		name  string
+		audio [][]byte
+		want  []ToneEventType
+	}{
+		{"CNG", cng, []ToneEventType{ToneFaxCNG, ToneFaxCNG, ToneFaxCNG}},
+		{"CED", [][]byte{toneAudio(2100, 3*time.Second), toneAudio(0, time.Second)}, []ToneEventType{ToneFaxCED}},
+		// a CED cut short, no longer than a beep.
+		{"short CED", [][]byte{toneAudio(2100, 1200*time.Millisecond), toneAudio(0, time.Second)}, []ToneEventType{ToneFaxCED}},
+	}
+
+	for _, tt := range tests {
+		if types := toneTypes(detectTones(tt.audio...)); !reflect.DeepEqual(types, tt.want) {
+			t.Errorf("%s: events = %v, want %v", tt.name, types, tt.want)
+		}
+	}
+}
+
+func TestToneDetectorCaptureTime(t *testing.T) {
+	audio := append(toneAudio(0, 300*time.Millisecond), toneAudio(1100, 500*time.Millisecond)...)
+	audio = append(audio, toneAudio(0, time.Second)...)
+	captured := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d := NewToneDetector()
+	var events []ToneEvent
+	for start := 0; start < len(audio); start += 320 {
+		events = append(events, d.ProcessAt(audio[start:start+320], captured.Add(frameDuration(start)))...)
+	}
+
+	if len(events) != 1 || events[0].Type != ToneFaxCNG {
+		t.Fatalf("events = %v, want FAX_CNG", toneTypes(events))
+	}
+	// the tone is reported after it ended, its Time is still its start.
+	if want := captured.Add(events[0].Offset); !events[0].Time.Equal(want) {
+		t.Fatalf("time %v, want %v", events[0].Time, want)
+	}
+}
+
+func TestToneStageCaptureTime(t *testing.T) {
+	audio := append(toneAudio(0, 300*time.Millisecond), toneAudio(1100, 500*time.Millisecond)...)
+	audio = append(audio, toneAudio(0, time.Second)...)
+	captured := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// frames captured long before the stage runs, as when queued behind a slow stage.
+	source := make(chan AudioResult, len(audio)/320)
+	for start := 0; start < len(audio); start += 320 {
+		source <- AudioResult{Stream: audio[start : start+320], Captured: captured.Add(frameDuration(start))}
+	}
+	close(source)
+
+	var events []ToneEvent
+	p := NewPipeline(source).
+		Stage("tones", ToneStage(NewToneDetector(), func(e ToneEvent) { events = append(events, e) })).
+		Sink("all", &collector{})
+	if err := p.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitPipeline(t, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || events[0].Type != ToneFaxCNG {
+		t.Fatalf("events = %v, want FAX_CNG", toneTypes(events))
+	}
+	// the tone is reported after it ended, its Time is still its start.
+	if want := captured.Add(events[0].Offset); !events[0].Time.Equal(want) {
+		t.Fatalf("time %v, want %v", events[0].Time, want)
+	}
+}
+
+func TestToneDetectorBusy(t *testing.T) {
+	var audio [][]byte
+	for i := 0; i < 3; i++ {
+		busy := make(Frame, audioSampleRate/2)
+		for j := range busy {
+			v := math.Sin(2*math.Pi*480*float64(j)/audioSampleRate) + math.Sin(2*math.Pi*620*float64(j)/audioSampleRate)
+			busy[j] = int16(0.15 * fullScale * v)
+		}
+		audio = append(audio, busy.Bytes(nil), toneAudio(0, 500*time.Millisecond))
+	}
+
+	types := toneTypes(detectTones(audio...))
+	if len(types) == 0 || types[0] != ToneBusy {
+		t.Fatalf("events = %v, want BUSY", types)
+	}
+}
@@ -0,0 +1,433 @@
+// Package goEagi of transcript.go provides a Transcript type, which
+// accumulates the final results of the speech recognizers with times
+// relative to the start of the call, and exports them as WebVTT or SRT
+// captions, plain text or JSON.
+
+package goEagi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultCaptionLineLength  = 42
+	defaultCaptionLines       = 2
+	defaultCaptionMaxDuration = 7 * time.Second
+	defaultCaptionMinDuration = time.Second
+
+	transcriptJSONVersion = 1
+)
+
+// TranscriptWord is a recognized word, its times are relative to the start of the call.
+type TranscriptWord struct {
+	Word       string
+	Start      time.Duration
+	End        time.Duration
+	Confidence float64
+}
+
+// TranscriptSegment is a final recognition result, its times are relative to the start of the call.
+// Words is empty when the recognizer gave no word timings.
+type TranscriptSegment struct {
+	Source     string
+	Start      time.Duration
+	End        time.Duration
+	Text       string
+	Confidence float64
+	Words      []TranscriptWord
+}
+
+// Transcript collects the final results of a call, ordered by start time.
+//
+// Captions are cut from the words of the segments: a caption holds at most MaxLines lines of MaxLineLength
+// characters, a longer word having a line of its own, and spans at most MaxCaptionDuration.
+// A caption is shown for MinCaptionDuration at least, unless the next caption starts before.
+// A Transcript is safe for concurrent use.
+type Transcript struct {
+	// Start is the wall-clock time of the start of the call, which recognizer times are relative to.
+	Start time.Time
+
+	MaxLineLength      int
+	MaxLines           int
+	MaxCaptionDuration time.Duration
+	MinCaptionDuration time.Duration
+
+	mu           sync.Mutex
+	segments     []TranscriptSegment
+	googleOffset time.Duration
+	googleEnd    time.Duration
+	voskEnd      time.Duration
+}
+
+type caption struct {
+	start time.Duration
+	end   time.Duration
+	lines []string
+}
+
+// NewTranscript creates a Transcript starting now, with captions of 2 lines of 42 characters lasting 1 to 7 seconds.
+func NewTranscript() *Transcript {
+	return &Transcript{
+		Start:              time.Now(),
+		MaxLineLength:      defaultCaptionLineLength,
+		MaxLines:           defaultCaptionLines,
+		MaxCaptionDuration: defaultCaptionMaxDuration,
+		MinCaptionDuration: defaultCaptionMinDuration,
+	}
+}
+
+// Add inserts a segment in start order.
+func (t *Transcript) Add(segment TranscriptSegment) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := sort.Search(len(t.segments), func(i int) bool { return t.segments[i].Start > segment.Start })
+	t.segments = append(t.segments, TranscriptSegment{})
+	copy(t.segments[i+1:], t.segments[i:])
+	t.segments[i] = segment
+}
+
+// AddGoogleResult adds a final result of GoogleService.SpeechToTextResponse, other results are ignored.
+// Its words are only timed when the service was created by NewGoogleServiceWithWordTimes.
+// Google times restart with every stream, so the successful reinitialization notice moves the following
+// results to the time it is received at, relative to Start.
+func (t *Transcript) AddGoogleResult(r GoogleResult) {
+	t.mu.Lock()
+
+	if r.Error != nil || r.Result == nil {
+		if r.Error == nil && !r.Reinitialized && r.ReinitializedInfo != "" {
+			t.googleOffset = time.Since(t.Start)
+			t.googleEnd = 0
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	alternatives := r.Result.GetAlternatives()
+	if !r.Result.GetIsFinal() || len(alternatives) == 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	offset := t.googleOffset
+	start := t.googleEnd
+	end := r.Result.GetResultEndTime().AsDuration()
+	t.googleEnd = end
+	t.mu.Unlock()
+
+	alternative := alternatives[0]
+	words := make([]TranscriptWord, 0, len(alternative.GetWords()))
+	for _, w := range alternative.GetWords() {
+		words = append(words, TranscriptWord{
+			Word:       w.GetWord(),
+			Start:      offset + w.GetStartTime().AsDuration(),
+			End:        offset + w.GetEndTime().AsDuration(),
+			Confidence: float64(w.GetConfidence()),
+		})
+	}
+	if len(words) > 0 {
+		start = words[0].Start - offset
+	}
+
+	t.Add(TranscriptSegment{
+		Source:     "google",
+		Start:      offset + start,
+		End:        offset + end,
+		Text:       strings.TrimSpace(alternative.GetTranscript()),
+		Confidence: float64(alternative.GetConfidence()),
+		Words:      words,
+	})
+}
+
+// AddVoskResult adds a final result of VoskService.SpeechToTextResponse, partial results are ignored.
+// The Vosk stream is expected to start at Start. Without word timings, the segment ends when it is received.
+func (t *Transcript) AddVoskResult(r VoskResult) {
+	text := strings.TrimSpace(r.Text)
+	if text == "" {
+		return
+	}
+
+	segment := TranscriptSegment{Source: "vosk", Text: text}
+
+	for _, w := range r.Result {
+		segment.Words = append(segment.Words, TranscriptWord{
+			Word:       w.Word,
+			Start:      secondsToDuration(w.Start),
+			End:        secondsToDuration(w.End),
+			Confidence: w.Conf,
+		})
+		segment.Confidence += w.Conf / float64(len(r.Result))
+	}
+
+	t.mu.Lock()
+	if len(segment.Words) > 0 {
+		segment.Start = segment.Words[0].Start
+		segment.End = segment.Words[len(segment.Words)-1].End
+	} else {
+		segment.Start = t.voskEnd
+		segment.End = time.Since(t.Start)
+	}
+	t.voskEnd = segment.End
+	t.mu.Unlock()
+
+	t.Add(segment)
+}
+
+// Segments returns the segments collected so far, in start order.
+func (t *Transcript) Segments() []TranscriptSegment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]TranscriptSegment(nil), t.segments...)
+}
+
+// WriteWebVTT writes the captions as a WebVTT file.
+func (t *Transcript) WriteWebVTT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+
+	for i, c := range t.captions() {
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n%s\n", i+1, formatCaptionTime(c.start, '.'), formatCaptionTime(c.end, '.'), strings.Join(c.lines, "\n"))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteSRT writes the captions as a SubRip file.
+func (t *Transcript) WriteSRT(w io.Writer) error {
+	var b strings.Builder
+
+	for i, c := range t.captions() {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n", i+1, formatCaptionTime(c.start, ','), formatCaptionTime(c.end, ','), strings.Join(c.lines, "\n"))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteText writes the text of every segment on a line of its own.
+func (t *Transcript) WriteText(w io.Writer) error {
+	var b strings.Builder
+
+	for _, s := range t.Segments() {
+		b.WriteString(s.Text)
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the transcript as a JSON document of the following schema, times being in seconds
+// from the start of the call and confidences being 0 when unknown:
+//
+//	{
+//	  "version": 1,
+//	  "start": "2024-05-01T10:00:00Z",  // wall-clock time of the start of the call, RFC 3339
+//	  "duration": 12.5,                 // end of the last segment
+//	  "segments": [
+//	    {
+//	      "source": "google",           // "google", "vosk", or the source given to Add
+//	      "start": 1.2,
+//	      "end": 3.4,
+//	      "text": "hello world",
+//	      "confidence": 0.92,
+//	      "words": [
+//	        {"word": "hello", "start": 1.2, "end": 1.6, "confidence": 0.95}
+//	      ]
+//	    }
+//	  ]
+//	}
+func (t *Transcript) WriteJSON(w io.Writer) error {
+	type jsonWord struct {
+		Word       string  `json:"word"`
+		Start      float64 `json:"start"`
+		End        float64 `json:"end"`
+		Confidence float64 `json:"confidence"`
+	}
+	type jsonSegment struct {
+		Source     string     `json:"source"`
+		Start      float64    `json:"start"`
+		End        float64    `json:"end"`
+		Text       string     `json:"text"`
+		Confidence float64    `json:"confidence"`
+		Words      []jsonWord `json:"words"`
+	}
+	type jsonTranscript struct {
+		Version  int           `json:"version"`
+		Start    time.Time     `json:"start"`
+		Duration float64       `json:"duration"`
+		Segments []jsonSegment `json:"segments"`
+	}
+
+	doc := jsonTranscript{
+		Version:  transcriptJSONVersion,
+		Start:    t.Start,
+		Segments: []jsonSegment{},
+	}
+
+	for _, s := range t.Segments() {
+		segment := jsonSegment{
+			Source:     s.Source,
+			Start:      s.Start.Seconds(),
+			End:        s.End.Seconds(),
+			Text:       s.Text,
+			Confidence: s.Confidence,
+			Words:      []jsonWord{},
+		}
+		for _, w := range s.Words {
+			segment.Words = append(segment.Words, jsonWord{
+				Word:       w.Word,
+				Start:      w.Start.Seconds(),
+				End:        w.End.Seconds(),
+				Confidence: w.Confidence,
+			})
+		}
+
+		doc.Segments = append(doc.Segments, segment)
+		if segment.End > doc.Duration {
+			doc.Duration = segment.End
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// captions cuts the segments into captions following the line and duration rules.
+func (t *Transcript) captions() []caption {
+	segments := t.Segments()
+
+	lineLength := t.MaxLineLength
+	if lineLength <= 0 {
+		lineLength = defaultCaptionLineLength
+	}
+	maxLines := t.MaxLines
+	if maxLines <= 0 {
+		maxLines = defaultCaptionLines
+	}
+	maxDuration := t.MaxCaptionDuration
+	if maxDuration <= 0 {
+		maxDuration = defaultCaptionMaxDuration
+	}
+
+	var captions []caption
+	for _, s := range segments {
+		words := s.Words
+		if len(words) == 0 {
+			words = spreadWords(s)
+		}
+
+		var cue []TranscriptWord
+		flush := func() {
+			if len(cue) > 0 {
+				captions = append(captions, caption{start: cue[0].Start, end: cue[len(cue)-1].End, lines: wrapWords(cue, lineLength)})
+			}
+			cue = nil
+		}
+
+		for _, w := range words {
+			if len(cue) > 0 {
+				next := append(cue[:len(cue):len(cue)], w)
+				if len(wrapWords(next, lineLength)) > maxLines || w.End-cue[0].Start > maxDuration {
+					flush()
+				}
+			}
+			cue = append(cue, w)
+		}
+		flush()
+	}
+
+	sort.SliceStable(captions, func(i, j int) bool { return captions[i].start < captions[j].start })
+
+	for i := range captions {
+		c := &captions[i]
+		if c.end-c.start >= t.MinCaptionDuration {
+			continue
+		}
+		c.end = c.start + t.MinCaptionDuration
+		if i+1 < len(captions) && captions[i+1].start < c.end {
+			c.end = captions[i+1].start
+		}
+		if c.end < c.start {
+			c.end = c.start
+		}
+	}
+
+	return captions
+}
+
+// spreadWords splits the text of a segment without word timings into words, sharing its span by their length.
+func spreadWords(s TranscriptSegment) []TranscriptWord {
+	fields := strings.Fields(s.Text)
+
+	total := 0
+	for _, f := range fields {
+		total += utf8.RuneCountInString(f) + 1
+	}
+
+	words := make([]TranscriptWord, 0, len(fields))
+	span := s.End - s.Start
+	at := 0
+	for _, f := range fields {
+		start := s.Start + span*time.Duration(at)/time.Duration(total)
+		at += utf8.RuneCountInString(f) + 1
+		end := s.Start + span*time.Duration(at)/time.Duration(total)
+		words = append(words, TranscriptWord{Word: f, Start: start, End: end})
+	}
+
+	return words
+}
+
+// wrapWords fills lines of at most lineLength characters with words, a longer word having a line of its own.
+func wrapWords(words []TranscriptWord, lineLength int) []string {
+	var lines []string
+	var line strings.Builder
+	length := 0
+
+	for _, w := range words {
+		n := utf8.RuneCountInString(w.Word)
+		if length > 0 && length+1+n > lineLength {
+			lines = append(lines, line.String())
+			line.Reset()
+			length = 0
+		}
+		if length > 0 {
+			line.WriteString(" ")
+			length++
+		}
+		line.WriteString(w.Word)
+		length += n
+	}
+	if length > 0 {
+		lines = append(lines, line.String())
+	}
+
+	return lines
+}
+
+// formatCaptionTime formats d as HH:MM:SS.mmm, with sep before the milliseconds.
+func formatCaptionTime(d time.Duration, sep byte) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// secondsToDuration converts fractional seconds to a Duration.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,249 @@
+package goEagi
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// timedWords returns words of the text, each lasting length from start and followed by a gap.
+func timedWords(text string, start, length, gap time.Duration) []TranscriptWord {
+	var words []TranscriptWord
+	for _, w := range strings.Fields(text) {
+		words = append(words, TranscriptWord{Word: w, Start: start, End: start + length, Confidence: 0.9})
+		start += length + gap
+	}
+	return words
+}
+
+// goldenTranscript returns a transcript exercising every caption rule.
+func goldenTranscript() *Transcript {
+	tr := NewTranscript()
+	tr.Start = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	ms := time.Millisecond
+	add := func(source string, words []TranscriptWord) {
+		var text []string
+		for _, w := range words {
+			text = append(text, w.Word)
+		}
+		tr.Add(TranscriptSegment{
+			Source:     source,
+			Start:      words[0].Start,
+			End:        words[len(words)-1].End,
+			Text:       strings.Join(text, " "),
+			Confidence: 0.9,
+			Words:      words,
+		})
+	}
+
+	// without word timings, the words share the span of the segment. Segments are kept in start order.
+	tr.Add(TranscriptSegment{Source: "vosk", Start: 18 * time.Second, End: 20 * time.Second, Text: "okay bye"})
+	// too short, shown until the next caption starts.
+	add("google", timedWords("hello there", 1000*ms, 200*ms, 100*ms))
+	// too long for 2 lines of 42 characters.
+	add("google", timedWords("thanks for calling the customer service line of the city water board, how may I help you today", 1500*ms, 150*ms, 50*ms))
+	// too long for 7 seconds.
+	add("google", timedWords("one two three", 7000*ms, 500*ms, 3000*ms))
+	// a word longer than a line.
+	add("google", timedWords("my email is averyveryveryverylongname@averylongdomainname.example", 15000*ms, 300*ms, 0))
+
+	return tr
+}
+
+func TestTranscriptWebVTT(t *testing.T) {
+	var b strings.Builder
+	if err := goldenTranscript().WriteWebVTT(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `WEBVTT
+
+1
+00:00:01.000 --> 00:00:01.500
+hello there
+
+2
+00:00:01.500 --> 00:00:04.450
+thanks for calling the customer service
+line of the city water board, how may I
+
+3
+00:00:04.500 --> 00:00:05.500
+help you today
+
+4
+00:00:07.000 --> 00:00:11.000
+one two
+
+5
+00:00:14.000 --> 00:00:15.000
+three
+
+6
+00:00:15.000 --> 00:00:16.200
+my email is
+averyveryveryverylongname@averylongdomainname.example
+
+7
+00:00:18.000 --> 00:00:20.000
+okay bye
+`
+	if b.String() != want {
+		t.Fatalf("WebVTT:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestTranscriptSRT(t *testing.T) {
+	var b strings.Builder
+	if err := goldenTranscript().WriteSRT(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `1
+00:00:01,000 --> 00:00:01,500
+hello there
+
+2
+00:00:01,500 --> 00:00:04,450
+thanks for calling the customer service
+line of the city water board, how may I
+
+3
+00:00:04,500 --> 00:00:05,500
+help you today
+
+4
+00:00:07,000 --> 00:00:11,000
+one two
+
+5
+00:00:14,000 --> 00:00:15,000
+three
+
+6
+00:00:15,000 --> 00:00:16,200
+my email is
+averyveryveryverylongname@averylongdomainname.example
+
+7
+00:00:18,000 --> 00:00:20,000
+okay bye
+`
+	if b.String() != want {
+		t.Fatalf("SRT:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestTranscriptText(t *testing.T) {
+	var b strings.Builder
+	if err := goldenTranscript().WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `hello there
+thanks for calling the customer service line of the city water board, how may I help you today
+one two three
+my email is averyveryveryverylongname@averylongdomainname.example
+okay bye
+`
+	if b.String() != want {
+		t.Fatalf("text:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestTranscriptJSON(t *testing.T) {
+	tr := NewTranscript()
+	tr.Start = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tr.Add(TranscriptSegment{Source: "vosk", Start: 4 * time.Second, End: 5500 * time.Millisecond, Text: "okay bye"})
+	tr.Add(TranscriptSegment{
+		Source:     "google",
+		Start:      time.Second,
+		End:        1500 * time.Millisecond,
+		Text:       "hello there",
+		Confidence: 0.9,
+		Words:      timedWords("hello there", time.Second, 200*time.Millisecond, 100*time.Millisecond),
+	})
+
+	var b strings.Builder
+	if err := tr.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "version": 1,
+  "start": "2024-05-01T10:00:00Z",
+  "duration": 5.5,
+  "segments": [
+    {
+      "source": "google",
+      "start": 1,
+      "end": 1.5,
+      "text": "hello there",
+      "confidence": 0.9,
+      "words": [
+        {
+          "word": "hello",
+          "start": 1,
+          "end": 1.2,
+          "confidence": 0.9
+        },
+        {
+          "word": "there",
+          "start": 1.3,
+          "end": 1.5,
+          "confidence": 0.9
+        }
+      ]
+    },
+    {
+      "source": "vosk",
+      "start": 4,
+      "end": 5.5,
+      "text": "okay bye",
+      "confidence": 0,
+      "words": []
+    }
+  ]
+}
+`
+	if b.String() != want {
+		t.Fatalf("JSON:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	var empty strings.Builder
+	if err := NewTranscript().WriteJSON(&empty); err != nil || !strings.Contains(empty.String(), `"segments": []`) {
+		t.Fatalf("empty transcript written as %s, err %v", empty.String(), err)
+	}
+}
+
+func TestTranscriptCaptionSettings(t *testing.T) {
+	tr := NewTranscript()
+	tr.MaxLineLength = 12
+	tr.MaxLines = 1
+	tr.MinCaptionDuration = 0
+	// line lengths are counted in characters, "café au lait" fills a line of 12 exactly.
+	words := timedWords("café au lait s'il vous plaît", 0, 400*time.Millisecond, 100*time.Millisecond)
+	tr.Add(TranscriptSegment{Source: "test", Start: words[0].Start, End: words[len(words)-1].End, Text: "café au lait s'il vous plaît", Words: words})
+
+	var b strings.Builder
+	if err := tr.WriteSRT(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `1
+00:00:00,000 --> 00:00:01,400
+café au lait
+
+2
+00:00:01,500 --> 00:00:02,400
+s'il vous
+
+3
+00:00:02,500 --> 00:00:02,900
+plaît
+`
+	if b.String() != want {
+		t.Fatalf("SRT:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,346 @@
+// Package goEagi of ttscache.go provides a TTSCache type, which keeps
+// synthesized speech of any Synthesizer on disk, named after a SHA-256
+// of every synthesis parameter, so that prompts are synthesized once.
+
+package goEagi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ttsCacheTempSuffix = ".tmp"
+	ttsCacheStaleTemp  = time.Hour
+	// ttsCacheGrace keeps entries used recently from eviction, so that a file is not removed
+	// between the lookup returning it and its playback.
+	ttsCacheGrace = time.Minute
+)
+
+// TTSCacheStats counts the lookups of a TTSCache. Shared lookups waited for an identical synthesis in progress.
+type TTSCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Shared    uint64
+	Errors    uint64
+	Evictions uint64
+}
+
+// TTSCache is a Synthesizer keeping the speech of another one in Directory. Entries are written to a temporary
+// file renamed into place, so that a crash never leaves a truncated entry, and identical requests made
+// while one is being synthesized wait for it rather than synthesizing it again.
+//
+// After every miss, entries older than MaxAge are removed, then the least recently used ones until
+// the entries take at most MaxSize bytes, either limit being zero for unlimited. Entries used within
+// the last minute are never removed, so the cache may exceed MaxSize for that long.
+// A TTSCache is safe for concurrent use.
+type TTSCache struct {
+	Directory string
+	MaxSize   int64
+	MaxAge    time.Duration
+
+	synthesizer Synthesizer
+
+	mu       sync.Mutex
+	inflight map[string]*ttsCacheCall
+	evicting sync.Mutex
+
+	hits      uint64
+	misses    uint64
+	shared    uint64
+	errors    uint64
+	evictions uint64
+}
+
+// ttsCacheCall is a synthesis in progress. It runs on its own context, cancelled once every caller waiting for it gave up.
+type ttsCacheCall struct {
+	done    chan struct{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewTTSCache creates a TTSCache of synthesizer in directory, and the directory if needed.
+func NewTTSCache(synthesizer Synthesizer, directory string) (*TTSCache, error) {
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return &TTSCache{
+		Directory:   directory,
+		synthesizer: synthesizer,
+		inflight:    map[string]*ttsCacheCall{},
+	}, nil
+}
+
+// Synthesize implements Synthesizer, returning the cached speech of req.
+func (c *TTSCache) Synthesize(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+	audioPath, err := c.File(ctx, req, ".wav")
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(audioPath + ".wav")
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := NewWavReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	samples, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return samples.Bytes(nil), nil
+}
+
+// CacheKey identifies the speech of req by the cached synthesizer.
+func (c *TTSCache) CacheKey(req SynthesisRequest) string {
+	return cacheKeyOf(c.synthesizer, req)
+}
+
+// File returns the path without extension of the cached speech of req in the format of extension,
+// as in SynthesizeFile, synthesizing it on a miss. A caller whose ctx is done stops waiting,
+// the synthesis going on for the others waiting for the same entry.
+func (c *TTSCache) File(ctx context.Context, req SynthesisRequest, extension string) (string, error) {
+	codec, err := codecOfFile(extension, req.SampleRate)
+	if err != nil {
+		return "", err
+	}
+	req.SampleRate = codec.SampleRate()
+
+	key := cacheKeyOf(c.synthesizer, req)
+	audioPath := filepath.Join(c.Directory, key)
+
+	if c.touch(audioPath + extension) {
+		atomic.AddUint64(&c.hits, 1)
+		return audioPath, nil
+	}
+
+	name := key + extension
+
+	c.mu.Lock()
+	if c.inflight == nil {
+		c.inflight = map[string]*ttsCacheCall{}
+	}
+	call, shared := c.inflight[name]
+	if shared {
+		atomic.AddUint64(&c.shared, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+		fillCtx, cancel := context.WithCancel(context.Background())
+		call = &ttsCacheCall{done: make(chan struct{}), cancel: cancel}
+		c.inflight[name] = call
+		go c.run(fillCtx, call, req, codec, name)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		c.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// the next caller starts a synthesis of its own rather than joining the cancelled one.
+			call.cancel()
+			if c.inflight[name] == call {
+				delete(c.inflight, name)
+			}
+		}
+		c.mu.Unlock()
+		return "", ctx.Err()
+	}
+
+	if call.err != nil {
+		return "", call.err
+	}
+
+	if !shared && (c.MaxSize > 0 || c.MaxAge > 0) {
+		if err := c.Evict(); err != nil {
+			return "", err
+		}
+	}
+
+	return audioPath, nil
+}
+
+// Stats returns the lookup counters so far.
+func (c *TTSCache) Stats() TTSCacheStats {
+	return TTSCacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Shared:    atomic.LoadUint64(&c.shared),
+		Errors:    atomic.LoadUint64(&c.errors),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+// Evict removes the entries older than MaxAge, then the least recently used ones above MaxSize,
+// and temporary files left behind by a crash.
+func (c *TTSCache) Evict() error {
+	c.evicting.Lock()
+	defer c.evicting.Unlock()
+
+	dirEntries, err := os.ReadDir(c.Directory)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var entries []entry
+	var total int64
+	now := time.Now()
+
+	for _, d := range dirEntries {
+		if d.IsDir() {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		name := d.Name()
+		path := filepath.Join(c.Directory, name)
+
+		if strings.HasSuffix(name, ttsCacheTempSuffix) {
+			if now.Sub(info.ModTime()) > ttsCacheStaleTemp {
+				os.Remove(path)
+			}
+			continue
+		}
+		if !isCacheEntryName(name) {
+			continue
+		}
+		if now.Sub(info.ModTime()) < ttsCacheGrace {
+			// a recent entry may be about to play, it takes room but is not a candidate.
+			total += info.Size()
+			continue
+		}
+
+		if c.MaxAge > 0 && now.Sub(info.ModTime()) > c.MaxAge {
+			if os.Remove(path) == nil {
+				atomic.AddUint64(&c.evictions, 1)
+			}
+			continue
+		}
+
+		entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	if c.MaxSize <= 0 || total <= c.MaxSize {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+		if os.Remove(e.path) == nil {
+			atomic.AddUint64(&c.evictions, 1)
+			total -= e.size
+		}
+	}
+
+	return nil
+}
+
+// touch reports whether the entry at path exists, and marks it used. It holds the eviction lock,
+// so that the entry is within the eviction grace period by the time Evict looks at it.
+func (c *TTSCache) touch(path string) bool {
+	c.evicting.Lock()
+	defer c.evicting.Unlock()
+
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	// the modification time orders entries for eviction.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true
+}
+
+// run fills the entry name for a call, whoever waits for it.
+func (c *TTSCache) run(ctx context.Context, call *ttsCacheCall, req SynthesisRequest, codec Codec, name string) {
+	call.err = c.fill(ctx, req, codec, name)
+	if call.err != nil {
+		atomic.AddUint64(&c.errors, 1)
+	}
+
+	c.mu.Lock()
+	if c.inflight[name] == call {
+		delete(c.inflight, name)
+	}
+	c.mu.Unlock()
+	call.cancel()
+	close(call.done)
+}
+
+// fill synthesizes req into the entry name through a temporary file.
+func (c *TTSCache) fill(ctx context.Context, req SynthesisRequest, codec Codec, name string) error {
+	pcm, err := c.synthesizer.Synthesize(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	content, err := encodeAudioFile(pcm, codec, name)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(c.Directory, name+"-*"+ttsCacheTempSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create cache entry: %w", err)
+	}
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	// readable by Asterisk, which may run as another user.
+	os.Chmod(tmp.Name(), 0644)
+
+	if err := os.Rename(tmp.Name(), filepath.Join(c.Directory, name)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to commit cache entry: %w", err)
+	}
+	return nil
+}
+
+// isCacheEntryName reports whether name is a cache key followed by an extension, other files are left alone.
+func isCacheEntryName(name string) bool {
+	key := strings.TrimSuffix(name, filepath.Ext(name))
+	if len(key) != 32 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
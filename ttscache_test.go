@@ -0,0 +1,405 @@
+package goEagi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingSynthesizer returns a second of silence once release is closed, or fails when its ctx is done first.
+func blockingSynthesizer(calls *int32, release <-chan struct{}) SynthesizerFunc {
+	return func(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+		atomic.AddInt32(calls, 1)
+		select {
+		case <-release:
+			return make([]byte, 2*req.SampleRate), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestTTSCacheSharedFillOutlivesFirstCaller(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	cache, err := NewTTSCache(blockingSynthesizer(&calls, release), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := SynthesisRequest{Text: "hello"}
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.File(first, req, ".wav")
+		firstErr <- err
+	}()
+	waitStats(t, cache, func(s TTSCacheStats) bool { return s.Misses == 1 })
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cache.File(context.Background(), req, ".wav")
+		second <- err
+	}()
+	waitStats(t, cache, func(s TTSCacheStats) bool { return s.Shared == 1 })
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller: got %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("second caller failed with the first one: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("synthesized %d times, want 1", n)
+	}
+}
+
+func TestTTSCacheFillCancelledWithoutWaiters(t *testing.T) {
+	var calls int32
+	cache, err := NewTTSCache(blockingSynthesizer(&calls, nil), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.File(ctx, SynthesisRequest{Text: "hello"}, ".wav")
+		done <- err
+	}()
+	waitStats(t, cache, func(s TTSCacheStats) bool { return s.Misses == 1 })
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	waitStats(t, cache, func(s TTSCacheStats) bool { return s.Errors == 1 })
+}
+
+func TestTTSCacheCallerAfterCancelledFill(t *testing.T) {
+	var calls int32
+	// the first synthesis only returns once its ctx is done and finished is closed, as a slow request being torn down.
+	finished := make(chan struct{})
+	synthesizer := SynthesizerFunc(func(ctx context.Context, req SynthesisRequest) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			<-finished
+			return nil, ctx.Err()
+		}
+		return make([]byte, 2*req.SampleRate), nil
+	})
+	cache, err := NewTTSCache(synthesizer, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := SynthesisRequest{Text: "hello"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.File(ctx, req, ".wav")
+		first <- err
+	}()
+	waitStats(t, cache, func(s TTSCacheStats) bool { return s.Misses == 1 })
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller: got %v, want context.Canceled", err)
+	}
+
+	// the cancelled synthesis has not returned yet, joining it would wait for it.
+	timeout, stop := context.WithTimeout(context.Background(), 2*time.Second)
+	defer stop()
+	_, err = cache.File(timeout, req, ".wav")
+	close(finished)
+	if err != nil {
+		t.Fatalf("caller after the cancelled synthesis: %v", err)
+	}
+
+	if s := cache.Stats(); s.Misses != 2 || s.Shared != 0 {
+		t.Errorf("stats = %+v, want 2 misses and no shared lookup", s)
+	}
+	waitStats(t, cache, func(s TTSCacheStats) bool { return s.Errors == 1 })
+
+	// the end of the cancelled synthesis leaves the entry alone.
+	if _, err := cache.File(context.Background(), req, ".wav"); err != nil || cache.Stats().Hits != 1 {
+		t.Fatalf("lookup after both syntheses: %v, stats %+v", err, cache.Stats())
+	}
+}
+
+func TestTTSCacheEvictKeepsRecentEntries(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	close(release)
+	dir := t.TempDir()
+	cache, err := NewTTSCache(blockingSynthesizer(&calls, release), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := cache.File(context.Background(), SynthesisRequest{Text: "old"}, ".wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit, err := cache.File(context.Background(), SynthesisRequest{Text: "hit"}, ".wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-2 * ttsCacheGrace)
+	for _, path := range []string{old, hit} {
+		if err := os.Chtimes(path+".wav", past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the hit is marked used, and the new entry is too recent to be evicted.
+	if _, err := cache.File(context.Background(), SynthesisRequest{Text: "hit"}, ".wav"); err != nil {
+		t.Fatal(err)
+	}
+	cache.MaxSize = 1
+	fresh, err := cache.File(context.Background(), SynthesisRequest{Text: "fresh"}, ".wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]bool{old: false, hit: true, fresh: true} {
+		if _, err := os.Stat(path + ".wav"); (err == nil) != want {
+			t.Errorf("%s kept: %v, want %v", filepath.Base(path), err == nil, want)
+		}
+	}
+	if s := cache.Stats(); s.Evictions != 1 || s.Hits != 1 {
+		t.Errorf("stats: %+v, want 1 eviction and 1 hit", s)
+	}
+}
+
+func TestTTSCacheKeys(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	close(release)
+	cache, err := NewTTSCache(blockingSynthesizer(&calls, release), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every request differs from the first one by a single parameter, SSML being case-sensitive.
+	requests := []SynthesisRequest{
+		{Text: "<speak>Hello</speak>", SSML: true},
+		{Text: "<speak>hello</speak>", SSML: true},
+		{Text: "<speak>Hello</speak>"},
+		{Text: "<speak>Hello</speak>", SSML: true, Voice: "en-US-Neural2-F"},
+		{Text: "<speak>Hello</speak>", SSML: true, Language: "en-GB"},
+		{Text: "<speak>Hello</speak>", SSML: true, SampleRate: 16000},
+	}
+
+	paths := map[string]bool{}
+	for _, req := range requests {
+		path, err := cache.File(context.Background(), req, ".wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths[path] = true
+	}
+	if len(paths) != len(requests) || calls != int32(len(requests)) {
+		t.Fatalf("%d requests gave %d entries after %d syntheses", len(requests), len(paths), calls)
+	}
+
+	// the same request again is a hit, whatever the order.
+	for i := len(requests) - 1; i >= 0; i-- {
+		if _, err := cache.File(context.Background(), requests[i], ".wav"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := cache.Stats(); s.Hits != uint64(len(requests)) || calls != int32(len(requests)) {
+		t.Fatalf("stats = %+v after %d syntheses, want a hit for every request", s, calls)
+	}
+}
+
+// cacheEntries fills cache with an entry for every text, each last used the given time ago.
+func cacheEntries(t *testing.T, cache *TTSCache, ages map[string]time.Duration) map[string]string {
+	t.Helper()
+
+	paths := map[string]string{}
+	for text, age := range ages {
+		path, err := cache.File(context.Background(), SynthesisRequest{Text: text}, ".wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		used := time.Now().Add(-age)
+		if err := os.Chtimes(path+".wav", used, used); err != nil {
+			t.Fatal(err)
+		}
+		paths[text] = path + ".wav"
+	}
+	return paths
+}
+
+// assertKept fails the test unless exactly the files of paths named in kept are left.
+func assertKept(t *testing.T, paths map[string]string, kept ...string) {
+	t.Helper()
+
+	for name, path := range paths {
+		want := false
+		for _, k := range kept {
+			want = want || k == name
+		}
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s kept: %v, want %v", name, err == nil, want)
+		}
+	}
+}
+
+func TestTTSCacheEvictMaxAge(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	close(release)
+	cache, err := NewTTSCache(blockingSynthesizer(&calls, release), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := cacheEntries(t, cache, map[string]time.Duration{
+		"expired": 3 * time.Hour,
+		"old":     90 * time.Minute,
+		"aging":   30 * time.Minute,
+		"recent":  0,
+	})
+
+	cache.MaxAge = time.Hour
+	if err := cache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	assertKept(t, paths, "aging", "recent")
+	if s := cache.Stats(); s.Evictions != 2 {
+		t.Errorf("stats = %+v, want 2 evictions", s)
+	}
+}
+
+func TestTTSCacheEvictLeastRecentlyUsed(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	close(release)
+	cache, err := NewTTSCache(blockingSynthesizer(&calls, release), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// created in alphabetical order, used in another.
+	paths := cacheEntries(t, cache, map[string]time.Duration{
+		"a": 10 * time.Minute,
+		"b": 2 * time.Minute,
+		"c": 8 * time.Minute,
+		"d": 6 * time.Minute,
+	})
+	info, err := os.Stat(paths["a"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.MaxSize = 2*info.Size() + 1
+	if err := cache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	assertKept(t, paths, "b", "d")
+
+	cache.MaxSize = 1
+	if err := cache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	assertKept(t, paths)
+	if s := cache.Stats(); s.Evictions != 4 {
+		t.Errorf("stats = %+v, want 4 evictions", s)
+	}
+}
+
+func TestTTSCacheEvictStaleTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewTTSCache(blockingSynthesizer(new(int32), nil), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a fill crashed long ago, one in progress, and a file which is not the cache's.
+	tests := []struct {
+		name string
+		file string
+		age  time.Duration
+	}{
+		{"stale", "0123456789abcdef0123456789abcdef.wav-42" + ttsCacheTempSuffix, 2 * ttsCacheStaleTemp},
+		{"writing", "fedcba9876543210fedcba9876543210.wav-7" + ttsCacheTempSuffix, time.Second},
+		{"foreign", "notes.txt", 2 * ttsCacheStaleTemp},
+	}
+
+	files := map[string]string{}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.name), 0600); err != nil {
+			t.Fatal(err)
+		}
+		modified := time.Now().Add(-tt.age)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		files[tt.name] = path
+	}
+
+	if err := cache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	assertKept(t, files, "writing", "foreign")
+}
+
+func TestGoogleTTSGenerateAudioCached(t *testing.T) {
+	// any synthesis fails without credentials.
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+	dir := t.TempDir()
+
+	tts, err := NewGoogleTTS("", dir, "en-US", "en-US-Neural2-F")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the speech of an earlier call.
+	entry := filepath.Join(dir, tts.CacheKey(SynthesisRequest{Text: "Hello", SampleRate: audioSampleRate})+audioExtension)
+	content := wavHeader{wavFormatPCM, 1, audioSampleRate, 16}.encode(16000)
+	content = append(content, make([]byte, 16000)...)
+	if err := os.WriteFile(entry, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		path, err := tts.GenerateAudio("Hello")
+		if err != nil {
+			t.Fatalf("call %d synthesized again: %v", i, err)
+		}
+		if path+audioExtension != entry {
+			t.Fatalf("call %d returned %s, want %s", i, path, entry)
+		}
+	}
+	if written, err := os.ReadFile(entry); err != nil || len(written) != len(content) {
+		t.Fatalf("entry of %d bytes after the calls, want %d unchanged", len(written), len(content))
+	}
+
+	// the same text in another voice is not the cached speech, it is synthesized and fails here.
+	tts.VoiceName = "en-US-Neural2-D"
+	if _, err := tts.GenerateAudio("Hello"); err == nil {
+		t.Fatal("speech of another voice returned from the cache")
+	}
+}
+
+func waitStats(t *testing.T, cache *TTSCache, ok func(TTSCacheStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok(cache.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats never reached the expected state: %+v", cache.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -1,21 +1,46 @@
 // Package goEagi of vad.go provides functionality on
 // detecting voice/speech activity based on audio bytes.
+// The detection threshold is either fixed, or adaptive and
+// derived from a continuously estimated noise floor.
 
 package goEagi
 
+import (
+	"math"
+	"sync"
+	"time"
+)
+
 const (
 	defaultAmplitudeDetectionThreshold = -27.5
+
+	defaultSNRMargin      = 10.0
+	defaultNoiseWindow    = 3 * time.Second
+	noiseFloorSubWindows  = 8
+	noiseFloorSmoothing   = 0.7
+	noiseFloorBias        = 1.2
+	minimumAmplitudeLevel = -96.0
 )
 
 type VadResult struct {
-	Error     error
-	Detected  bool
-	Amplitude float64
-	Frame     []byte
+	Error      error
+	Detected   bool
+	Amplitude  float64
+	NoiseFloor float64
+	Frame      []byte
 }
 
 type Vad struct {
 	AmplitudeDetectionThreshold float64
+
+	// Adaptive enables the noise floor tracking, when it is true
+	// the detection threshold is NoiseFloor() + SNRMargin and
+	// AmplitudeDetectionThreshold is ignored.
+	Adaptive  bool
+	SNRMargin float64
+
+	noise *noiseFloorEstimator
+	mu    sync.Mutex
 }
 
 // NewVad is a constructor of Vad.
@@ -27,6 +52,80 @@ func NewVad(amplitudeThreshold float64) *Vad {
 	return &Vad{AmplitudeDetectionThreshold: defaultAmplitudeDetectionThreshold}
 }
 
+// NewAdaptiveVad is a constructor of an adaptive Vad.
+// snrMargin is how far above the estimated noise floor (in dB) a frame must be to count as voice,
+// window is the sliding window of the minimum statistics noise tracker,
+// seed is the leading part of the call used to calibrate the initial noise floor.
+// Zero values fall back to defaultSNRMargin, defaultNoiseWindow and no seeding respectively.
+func NewAdaptiveVad(snrMargin float64, window, seed time.Duration) *Vad {
+	if snrMargin == 0 {
+		snrMargin = defaultSNRMargin
+	}
+	if window <= 0 {
+		window = defaultNoiseWindow
+	}
+
+	return &Vad{
+		AmplitudeDetectionThreshold: defaultAmplitudeDetectionThreshold,
+		Adaptive:                    true,
+		SNRMargin:                   snrMargin,
+		noise:                       newNoiseFloorEstimator(window, seed),
+	}
+}
+
+// NoiseFloor returns the current noise floor estimate in the unit of ComputeAmplitude.
+// It returns the fixed threshold minus SNRMargin if no frame has been analyzed yet.
+func (v *Vad) NoiseFloor() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.noise == nil || !v.noise.initialized {
+		return v.AmplitudeDetectionThreshold - v.SNRMargin
+	}
+	return v.noise.floor()
+}
+
+// Threshold returns the amplitude a frame has to exceed to be detected as voice.
+func (v *Vad) Threshold() float64 {
+	if !v.Adaptive {
+		return v.AmplitudeDetectionThreshold
+	}
+	return v.NoiseFloor() + v.SNRMargin
+}
+
+// Analyze computes the amplitude of a single frame, updates the noise floor
+// estimate when the Vad is adaptive, and reports whether voice was detected.
+func (v *Vad) Analyze(frame []byte) (VadResult, error) {
+	amp, err := ComputeAmplitude(frame)
+	if err != nil {
+		return VadResult{}, err
+	}
+
+	if !v.Adaptive {
+		return VadResult{
+			Detected:   v.AmplitudeDetectionThreshold < amp,
+			Amplitude:  amp,
+			NoiseFloor: v.AmplitudeDetectionThreshold - v.SNRMargin,
+			Frame:      frame,
+		}, nil
+	}
+
+	v.mu.Lock()
+	if v.noise == nil {
+		v.noise = newNoiseFloorEstimator(defaultNoiseWindow, 0)
+	}
+	v.noise.update(amp, frameDuration(len(frame)))
+	floor := v.noise.floor()
+	v.mu.Unlock()
+
+	return VadResult{
+		Detected:   floor+v.SNRMargin < amp,
+		Amplitude:  amp,
+		NoiseFloor: floor,
+		Frame:      frame,
+	}, nil
+}
+
 // Detect analyzes voice activity for a given slice of bytes.
 func (v *Vad) Detect(done <-chan interface{}, stream <-chan []byte) <-chan VadResult {
 
@@ -41,14 +140,14 @@ func (v *Vad) Detect(done <-chan interface{}, stream <-chan []byte) <-chan VadRe
 				return
 
 			case buf := <-stream:
-				amp, err := ComputeAmplitude(buf)
+				result, err := v.Analyze(buf)
 				if err != nil {
 					vadResultStream <- VadResult{Error: err}
 					return
 				}
 
-				if v.AmplitudeDetectionThreshold < amp {
-					vadResultStream <- VadResult{Detected: true, Amplitude: amp, Frame: buf}
+				if result.Detected {
+					vadResultStream <- result
 				}
 			}
 		}
@@ -56,3 +155,114 @@ func (v *Vad) Detect(done <-chan interface{}, stream <-chan []byte) <-chan VadRe
 
 	return vadResultStream
 }
+
+// noiseFloorEstimator tracks the noise floor with minimum statistics:
+// the smoothed frame power is followed by its minimum over a sliding window,
+// which is split into sub-windows so that the minimum can be updated in constant time.
+// Speech raises the power only briefly, so the minimum stays close to the background noise.
+type noiseFloorEstimator struct {
+	subWindow time.Duration
+	seed      time.Duration
+
+	initialized bool
+	elapsed     time.Duration
+	seedPower   float64
+	seedFrames  int
+
+	smoothed      float64
+	currentMin    float64
+	currentLength time.Duration
+	minima        []float64
+	next          int
+}
+
+func newNoiseFloorEstimator(window, seed time.Duration) *noiseFloorEstimator {
+	return &noiseFloorEstimator{
+		subWindow: window / noiseFloorSubWindows,
+		seed:      seed,
+		minima:    make([]float64, 0, noiseFloorSubWindows),
+	}
+}
+
+// update feeds the amplitude of a frame which lasts d into the estimator.
+func (n *noiseFloorEstimator) update(amplitude float64, d time.Duration) {
+	p := dbToPower(amplitude)
+
+	if n.elapsed < n.seed {
+		n.elapsed += d
+		n.seedPower += p
+		n.seedFrames++
+		n.reset(n.seedPower / float64(n.seedFrames))
+		return
+	}
+	n.elapsed += d
+
+	if !n.initialized {
+		n.reset(p)
+		return
+	}
+
+	n.smoothed = noiseFloorSmoothing*n.smoothed + (1-noiseFloorSmoothing)*p
+	if n.smoothed < n.currentMin {
+		n.currentMin = n.smoothed
+	}
+
+	n.currentLength += d
+	if n.currentLength < n.subWindow {
+		return
+	}
+
+	if len(n.minima) < cap(n.minima) {
+		n.minima = append(n.minima, n.currentMin)
+	} else {
+		n.minima[n.next] = n.currentMin
+		n.next = (n.next + 1) % len(n.minima)
+	}
+	n.currentMin = n.smoothed
+	n.currentLength = 0
+}
+
+// reset initializes the estimator with a known noise power.
+func (n *noiseFloorEstimator) reset(p float64) {
+	n.initialized = true
+	n.smoothed = p
+	n.currentMin = p
+	n.currentLength = 0
+	n.minima = n.minima[:0]
+	n.next = 0
+}
+
+// floor returns the estimated noise floor in dB, compensated for the
+// downward bias of taking a minimum over noisy power values.
+func (n *noiseFloorEstimator) floor() float64 {
+	p := n.currentMin
+	for _, m := range n.minima {
+		if m < p {
+			p = m
+		}
+	}
+
+	if n.elapsed > n.seed || n.seed == 0 {
+		p *= noiseFloorBias
+	}
+	return powerToDb(p)
+}
+
+// frameDuration returns the duration of a 16-bit mono frame of length n bytes.
+func frameDuration(n int) time.Duration {
+	samples := n / audioBytesPerSample
+	return time.Duration(samples) * time.Second / audioSampleRate
+}
+
+// dbToPower converts a level in dB to linear power, silence is clamped to minimumAmplitudeLevel.
+func dbToPower(db float64) float64 {
+	if math.IsInf(db, -1) || math.IsNaN(db) || db < minimumAmplitudeLevel {
+		db = minimumAmplitudeLevel
+	}
+	return math.Pow(10, db/10)
+}
+
+// powerToDb converts linear power to a level in dB.
+func powerToDb(p float64) float64 {
+	return 10 * math.Log10(p)
+}
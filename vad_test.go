@@ -0,0 +1,87 @@
+package goEagi
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// noiseFrames returns n 20 ms frames of uniform white noise with the given peak amplitude.
+func noiseFrames(r *rand.Rand, n int, amplitude float64) [][]byte {
+	frames := make([][]byte, n)
+	for i := range frames {
+		f := make(Frame, 160)
+		for j := range f {
+			f[j] = int16(amplitude * (2*r.Float64() - 1))
+		}
+		frames[i] = f.Bytes(nil)
+	}
+	return frames
+}
+
+// noiseLevel returns the amplitude of white noise with the given peak amplitude, in the unit of ComputeAmplitude.
+func noiseLevel(amplitude float64) float64 {
+	return AmplitudeToDb(amplitude/math.Sqrt(3)/fullScale) + amplitudeOffset
+}
+
+func TestAdaptiveVadNoiseFloorConverges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	v := NewAdaptiveVad(0, time.Second, 0)
+
+	// 3 s of quiet noise with a 300 ms word every second, which must not lift the floor.
+	quiet := noiseLevel(300)
+	for i, f := range noiseFrames(r, 150, 300) {
+		if i%50 < 15 {
+			f = noiseFrames(r, 1, 12000)[0]
+		}
+		if _, err := v.Analyze(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if floor := v.NoiseFloor(); math.Abs(floor-quiet) > 3 {
+		t.Fatalf("noise floor = %.1f, want %.1f ± 3", floor, quiet)
+	}
+
+	// The line gets noisier, the floor has to follow within about one window.
+	loud := noiseLevel(3000)
+	for _, f := range noiseFrames(r, 75, 3000) {
+		if _, err := v.Analyze(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if floor := v.NoiseFloor(); math.Abs(floor-loud) > 3 {
+		t.Fatalf("noise floor after level change = %.1f, want %.1f ± 3", floor, loud)
+	}
+
+	res, err := v.Analyze(noiseFrames(r, 1, 3000)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Detected {
+		t.Fatalf("background noise at %.1f detected as voice, threshold %.1f", res.Amplitude, v.Threshold())
+	}
+
+	res, err = v.Analyze(noiseFrames(r, 1, 30000)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Detected {
+		t.Fatalf("speech at %.1f not detected, threshold %.1f", res.Amplitude, v.Threshold())
+	}
+}
+
+func TestAdaptiveVadSeed(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	v := NewAdaptiveVad(0, time.Second, 200*time.Millisecond)
+
+	want := noiseLevel(1000)
+	for _, f := range noiseFrames(r, 10, 1000) {
+		if _, err := v.Analyze(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if floor := v.NoiseFloor(); math.Abs(floor-want) > 1.5 {
+		t.Fatalf("seeded noise floor = %.1f, want %.1f ± 1.5", floor, want)
+	}
+}
@@ -0,0 +1,87 @@
+// Package goEagi of wav.go provides the RIFF/WAVE header layout
+// shared by the audio generation functions.
+
+package goEagi
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	wavFormatPCM   = 0x0001
+	wavFormatALaw  = 0x0006
+	wavFormatMuLaw = 0x0007
+
+	// wavUnknownSize is the data size of a file whose writer could not seek back, the data lasts until EOF.
+	wavUnknownSize = 0xFFFFFFFF
+)
+
+// wavHeader describes the fmt chunk of a wav file.
+type wavHeader struct {
+	AudioFormat   uint16
+	Channels      uint16
+	SampleRate    uint32
+	BitsPerSample uint16
+}
+
+// blockAlign returns the number of bytes of one sample frame.
+func (h wavHeader) blockAlign() uint16 {
+	return h.Channels * h.BitsPerSample / 8
+}
+
+// size returns the number of bytes written by writeWavHeader,
+// which is also the offset of the first sample.
+func (h wavHeader) size() int {
+	if h.AudioFormat == wavFormatPCM {
+		return 44
+	}
+	// non-PCM formats carry cbSize in the fmt chunk and a fact chunk.
+	return 58
+}
+
+// writeWavHeader writes a canonical header for dataSize bytes of samples.
+func writeWavHeader(w io.Writer, h wavHeader, dataSize uint32) error {
+	_, err := w.Write(h.encode(dataSize))
+	return err
+}
+
+// encode returns the header bytes for dataSize bytes of samples, or wavUnknownSize.
+func (h wavHeader) encode(dataSize uint32) []byte {
+	buf := make([]byte, h.size())
+	le := binary.LittleEndian
+
+	riffSize := uint32(h.size()-8) + dataSize
+	if dataSize == wavUnknownSize {
+		riffSize = wavUnknownSize
+	}
+
+	copy(buf[0:], "RIFF")
+	le.PutUint32(buf[4:], riffSize)
+	copy(buf[8:], "WAVE")
+
+	copy(buf[12:], "fmt ")
+	le.PutUint32(buf[16:], 16)
+	le.PutUint16(buf[20:], h.AudioFormat)
+	le.PutUint16(buf[22:], h.Channels)
+	le.PutUint32(buf[24:], h.SampleRate)
+	le.PutUint32(buf[28:], h.SampleRate*uint32(h.blockAlign()))
+	le.PutUint16(buf[32:], h.blockAlign())
+	le.PutUint16(buf[34:], h.BitsPerSample)
+
+	offset := 36
+	if h.AudioFormat != wavFormatPCM {
+		// cbSize and the fact chunk holding the number of sample frames.
+		le.PutUint32(buf[16:], 18)
+		le.PutUint16(buf[36:], 0)
+		copy(buf[38:], "fact")
+		le.PutUint32(buf[42:], 4)
+		le.PutUint32(buf[46:], dataSize/uint32(h.blockAlign()))
+		offset = 50
+	}
+
+	copy(buf[offset:], "data")
+	le.PutUint32(buf[offset+4:], dataSize)
+
+	return buf
+}
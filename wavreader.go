@@ -0,0 +1,331 @@
+// Package goEagi of wavreader.go provides a streaming RIFF/WAVE reader
+// for PCM, float, μ-law and A-law files with any number of channels,
+// and the conversion of such files to 8 or 16 kHz mono slin.
+
+package goEagi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+
+	// wavFormatMaxSize is the size of an extensible fmt chunk, the largest one read, the rest of a fmt chunk is skipped.
+	wavFormatMaxSize = 40
+)
+
+// wavSubFormatSuffix is the part of a WAVE_FORMAT_EXTENSIBLE sub-format GUID following the format tag.
+var wavSubFormatSuffix = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+var (
+	// ErrInvalidWav is wrapped by the errors of a malformed wav file.
+	ErrInvalidWav = errors.New("invalid wav file")
+	// ErrUnsupportedWav is wrapped by the errors of a well-formed wav file in a format which can not be decoded.
+	ErrUnsupportedWav = errors.New("unsupported wav file")
+)
+
+// WavInfo describes the audio of a wav file. For extensible files AudioFormat is the format of the sub-format GUID.
+type WavInfo struct {
+	AudioFormat   uint16
+	Channels      int
+	SampleRate    int
+	BitsPerSample int
+	// Frames is the number of sample frames, -1 if the data size is unknown.
+	Frames int64
+}
+
+// Duration returns the duration of the audio, zero if the data size is unknown.
+func (i WavInfo) Duration() time.Duration {
+	if i.Frames < 0 || i.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(i.Frames) * time.Second / time.Duration(i.SampleRate)
+}
+
+// WavReader decodes the samples of a wav file and mixes the channels down to mono.
+type WavReader struct {
+	r          io.Reader
+	info       WavInfo
+	blockAlign int
+	remaining  int64
+	buf        []byte
+}
+
+// NewWavReader parses the header of a wav file up to the data chunk, r does not need to be seekable.
+// Supported are 8, 16, 24 and 32-bit PCM, 32 and 64-bit float, μ-law and A-law, plain or extensible.
+// A data size of zero or 0xFFFFFFFF, as left by an interrupted writer, is read until EOF.
+func NewWavReader(r io.Reader) (*WavReader, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("%w: truncated RIFF header: %v", ErrInvalidWav, err)
+	}
+	if string(riff[0:4]) != "RIFF" {
+		return nil, fmt.Errorf("%w: missing RIFF signature, found %q", ErrInvalidWav, riff[0:4])
+	}
+	if string(riff[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%w: RIFF type is %q, not WAVE", ErrInvalidWav, riff[8:12])
+	}
+
+	w := WavReader{r: r}
+	haveFormat := false
+
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			if !haveFormat {
+				return nil, fmt.Errorf("%w: no fmt chunk", ErrInvalidWav)
+			}
+			return nil, fmt.Errorf("%w: no data chunk", ErrInvalidWav)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			if err := w.readFormat(size); err != nil {
+				return nil, err
+			}
+			haveFormat = true
+
+		case "data":
+			if !haveFormat {
+				return nil, fmt.Errorf("%w: data chunk before fmt chunk", ErrInvalidWav)
+			}
+
+			w.info.Frames = -1
+			w.remaining = -1
+			if size != 0 && size != wavUnknownSize {
+				w.info.Frames = int64(size) / int64(w.blockAlign)
+				w.remaining = w.info.Frames * int64(w.blockAlign)
+			}
+			return &w, nil
+
+		default:
+			// chunks are padded to an even size.
+			if _, err := io.CopyN(io.Discard, r, int64(size)+int64(size&1)); err != nil {
+				return nil, fmt.Errorf("%w: truncated %q chunk", ErrInvalidWav, id)
+			}
+		}
+	}
+}
+
+// readFormat parses and validates a fmt chunk of size bytes.
+func (w *WavReader) readFormat(size uint32) error {
+	if size < 16 {
+		return fmt.Errorf("%w: fmt chunk of %d bytes, at least 16 expected", ErrInvalidWav, size)
+	}
+
+	// the size is not trusted for an allocation, a malformed header may claim up to 4 GiB.
+	read := size
+	if read > wavFormatMaxSize {
+		read = wavFormatMaxSize
+	}
+	chunk := make([]byte, read)
+	if _, err := io.ReadFull(w.r, chunk); err != nil {
+		return fmt.Errorf("%w: truncated fmt chunk", ErrInvalidWav)
+	}
+	if _, err := io.CopyN(io.Discard, w.r, int64(size-read)+int64(size&1)); err != nil {
+		return fmt.Errorf("%w: truncated fmt chunk", ErrInvalidWav)
+	}
+	le := binary.LittleEndian
+
+	format := le.Uint16(chunk[0:])
+	channels := int(le.Uint16(chunk[2:]))
+	rate := int(le.Uint32(chunk[4:]))
+	blockAlign := int(le.Uint16(chunk[12:]))
+	bits := int(le.Uint16(chunk[14:]))
+
+	if format == wavFormatExtensible {
+		if size < 40 {
+			return fmt.Errorf("%w: extensible fmt chunk of %d bytes, 40 expected", ErrInvalidWav, size)
+		}
+		subFormat := chunk[24:40]
+		if string(subFormat[2:]) != string(wavSubFormatSuffix) {
+			return fmt.Errorf("%w: unknown extensible sub-format % x", ErrUnsupportedWav, subFormat)
+		}
+		format = le.Uint16(subFormat)
+	}
+
+	switch {
+	case channels == 0:
+		return fmt.Errorf("%w: zero channels", ErrInvalidWav)
+	case rate == 0:
+		return fmt.Errorf("%w: zero sample rate", ErrInvalidWav)
+	case bits == 0 || bits%8 != 0:
+		return fmt.Errorf("%w: %d bits per sample", ErrUnsupportedWav, bits)
+	case blockAlign != channels*bits/8:
+		return fmt.Errorf("%w: block align %d does not match %d channels of %d bits", ErrInvalidWav, blockAlign, channels, bits)
+	}
+
+	supported := false
+	switch format {
+	case wavFormatPCM:
+		supported = bits <= 32
+	case wavFormatFloat:
+		supported = bits == 32 || bits == 64
+	case wavFormatMuLaw, wavFormatALaw:
+		supported = bits == 8
+	}
+	if !supported {
+		return fmt.Errorf("%w: format 0x%04x with %d bits per sample", ErrUnsupportedWav, format, bits)
+	}
+
+	w.info = WavInfo{
+		AudioFormat:   format,
+		Channels:      channels,
+		SampleRate:    rate,
+		BitsPerSample: bits,
+	}
+	w.blockAlign = blockAlign
+	return nil
+}
+
+// Info returns the format of the file.
+func (w *WavReader) Info() WavInfo {
+	return w.info
+}
+
+// ReadMono reads up to len(dst) sample frames mixed down to 16-bit mono, it returns io.EOF at the end of the data.
+// A data chunk shorter than its declared size is read up to the end of the file. As with io.Reader,
+// the frames read before a read error are returned along with it.
+func (w *WavReader) ReadMono(dst Frame) (int, error) {
+	if len(dst) == 0 {
+		return 0, nil
+	}
+
+	want := int64(len(dst)) * int64(w.blockAlign)
+	if w.remaining >= 0 && want > w.remaining {
+		want = w.remaining
+	}
+	if want == 0 {
+		return 0, io.EOF
+	}
+
+	if int64(cap(w.buf)) < want {
+		w.buf = make([]byte, want)
+	}
+	buf := w.buf[:want]
+
+	n, err := io.ReadFull(w.r, buf)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	frames := n / w.blockAlign
+	if frames == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, err
+	}
+	if w.remaining >= 0 {
+		w.remaining -= int64(n)
+	}
+
+	bytesPerSample := w.info.BitsPerSample / 8
+	for i := 0; i < frames; i++ {
+		block := buf[i*w.blockAlign:]
+		var sum float64
+		for c := 0; c < w.info.Channels; c++ {
+			sum += w.decodeSample(block[c*bytesPerSample:])
+		}
+		dst[i] = clampInt16(float32(sum / float64(w.info.Channels) * fullScale))
+	}
+
+	return frames, err
+}
+
+// ReadAll reads the remaining audio mixed down to 16-bit mono.
+func (w *WavReader) ReadAll() (Frame, error) {
+	var samples Frame
+	chunk := make(Frame, 4096)
+
+	for {
+		n, err := w.ReadMono(chunk)
+		samples = append(samples, chunk[:n]...)
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return samples, err
+		}
+	}
+}
+
+// decodeSample returns the sample at the start of b relative to full scale.
+func (w *WavReader) decodeSample(b []byte) float64 {
+	le := binary.LittleEndian
+
+	switch w.info.AudioFormat {
+	case wavFormatMuLaw:
+		return float64(DecodeULaw(b[0])) / fullScale
+	case wavFormatALaw:
+		return float64(DecodeALaw(b[0])) / fullScale
+	case wavFormatFloat:
+		if w.info.BitsPerSample == 64 {
+			return math.Float64frombits(le.Uint64(b))
+		}
+		return float64(math.Float32frombits(le.Uint32(b)))
+	}
+
+	switch w.info.BitsPerSample {
+	case 8:
+		// 8-bit PCM is unsigned.
+		return float64(int(b[0])-128) / 128
+	case 16:
+		return float64(int16(le.Uint16(b))) / fullScale
+	case 24:
+		v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		return float64(v) / (1 << 23)
+	default:
+		return float64(int32(le.Uint32(b))) / (1 << 31)
+	}
+}
+
+// ConvertWav reads a wav file of any supported format and returns it as 16-bit mono linear bytes at rate,
+// typically 8000 or 16000.
+func ConvertWav(r io.Reader, rate int) ([]byte, error) {
+	reader, err := NewWavReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wav samples: %w", err)
+	}
+
+	resampled, err := ResampleSamples(samples, reader.Info().SampleRate, rate, ResampleQualityHigh)
+	if err != nil {
+		return nil, err
+	}
+
+	return Frame(resampled).Bytes(nil), nil
+}
+
+// ConvertWavFile converts a wav file of any supported format to 16-bit mono at rate.
+// The output is a wav file if outputPath ends with .wav, otherwise the headerless
+// .sln (8 kHz) or .sln16 (16 kHz) file Asterisk plays.
+func ConvertWavFile(inputPath string, outputPath string, rate int) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open wav file: %w", err)
+	}
+	defer in.Close()
+
+	slin, err := ConvertWav(in, rate)
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputPath, err)
+	}
+
+	_, err = GenerateEncodedAudio(slin, LinearCodec{Rate: rate}, filepath.Dir(outputPath), filepath.Base(outputPath))
+	return err
+}
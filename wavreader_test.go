@@ -0,0 +1,146 @@
+package goEagi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// wavFile returns a wav file of data in the format of h.
+func wavFile(h wavHeader, data []byte) []byte {
+	return append(h.encode(uint32(len(data))), data...)
+}
+
+func TestWavReaderFormats(t *testing.T) {
+	mono := Frame{0, 1000, -1000, 32767, -32768}
+
+	stereo := make(Frame, 0, 2*len(mono))
+	for _, s := range mono {
+		stereo = append(stereo, s, s)
+	}
+
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{"16-bit mono", wavFile(wavHeader{wavFormatPCM, 1, 8000, 16}, mono.Bytes(nil))},
+		{"16-bit stereo", wavFile(wavHeader{wavFormatPCM, 2, 8000, 16}, stereo.Bytes(nil))},
+		{"unknown size", append(wavHeader{wavFormatPCM, 1, 8000, 16}.encode(wavUnknownSize), mono.Bytes(nil)...)},
+	}
+
+	for _, tc := range tests {
+		r, err := NewWavReader(bytes.NewReader(tc.file))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got, err := r.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(got.Bytes(nil), mono.Bytes(nil)) {
+			t.Errorf("%s: read %v, want %v", tc.name, got, mono)
+		}
+	}
+
+	ulaw := wavFile(wavHeader{wavFormatMuLaw, 1, 8000, 8}, ULawCodec{}.Encode(nil, mono))
+	r, err := NewWavReader(bytes.NewReader(ulaw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAll()
+	if err != nil || len(got) != len(mono) || got[1] != DecodeULaw(EncodeULaw(1000)) {
+		t.Errorf("μ-law: read %v, %v", got, err)
+	}
+}
+
+func TestWavReaderTruncatedData(t *testing.T) {
+	data := make(Frame, 100).Bytes(nil)
+	file := wavFile(wavHeader{wavFormatPCM, 1, 8000, 16}, data)
+
+	// the header declares 100 samples, the file holds 40 and a half.
+	r, err := NewWavReader(bytes.NewReader(file[:44+81]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAll()
+	if err != nil || len(got) != 40 {
+		t.Fatalf("read %d samples, %v, want 40 and no error", len(got), err)
+	}
+}
+
+func TestWavReaderFormatChunkSize(t *testing.T) {
+	mono := Frame{0, 1000, -1000}
+	file := wavFile(wavHeader{wavFormatPCM, 1, 8000, 16}, mono.Bytes(nil))
+
+	// a fmt chunk of an odd 51 bytes, its padding byte, then the data chunk.
+	long := append(append(append([]byte(nil), file[:36]...), make([]byte, 36)...), file[36:]...)
+	binary.LittleEndian.PutUint32(long[16:], 51)
+	r, err := NewWavReader(bytes.NewReader(long))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.ReadAll(); err != nil || !bytes.Equal(got.Bytes(nil), mono.Bytes(nil)) {
+		t.Fatalf("long fmt chunk: read %v, %v, want %v", got, err, mono)
+	}
+
+	// a fmt chunk claiming 4 GiB must not be allocated.
+	huge := append([]byte(nil), file...)
+	binary.LittleEndian.PutUint32(huge[16:], 0xFFFFFFFF)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = NewWavReader(bytes.NewReader(huge))
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, ErrInvalidWav) {
+		t.Fatalf("huge fmt chunk: got %v, want ErrInvalidWav", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("huge fmt chunk: allocated %d bytes", allocated)
+	}
+}
+
+// failingReader returns its data, then err.
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWavReaderReadError(t *testing.T) {
+	errDisk := errors.New("disk failure")
+	file := wavFile(wavHeader{wavFormatPCM, 1, 8000, 16}, make(Frame, 100).Bytes(nil))
+
+	r, err := NewWavReader(&failingReader{data: file[:44+60], err: errDisk})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := make(Frame, 100)
+	n, err := r.ReadMono(dst)
+	if n != 30 || !errors.Is(err, errDisk) {
+		t.Fatalf("ReadMono = %d, %v, want 30 frames and the read error", n, err)
+	}
+
+	r, _ = NewWavReader(&failingReader{data: file[:44+60], err: errDisk})
+	got, err := r.ReadAll()
+	if len(got) != 30 || !errors.Is(err, errDisk) {
+		t.Fatalf("ReadAll = %d samples, %v, want 30 and the read error", len(got), err)
+	}
+
+	r, _ = NewWavReader(&failingReader{data: file[:44+60], err: io.EOF})
+	if got, err := r.ReadAll(); len(got) != 30 || err != nil {
+		t.Fatalf("ReadAll at EOF = %d samples, %v, want 30 and no error", len(got), err)
+	}
+}